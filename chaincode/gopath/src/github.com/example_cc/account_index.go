@@ -0,0 +1,222 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "strconv"
+)
+
+//
+// account indexes
+//
+// ACCOUNT_TABLE has secondary indexes (see util.DefineIndex) by status and by the MSP ID of the owner, so that
+// the accounts having a given status or belonging to a given organization can be listed without reading every
+// account.  Accounts without a bound owner are left out of the owner index.
+//
+
+var account_status_index = util.DefineIndex(ACCOUNT_TABLE, "Status", func (row_json_bytes []byte) ([]string, error) {
+    var account Account
+    err := json.Unmarshal(row_json_bytes, &account)
+    if err != nil {
+        return nil, err
+    }
+    // Accounts stored before statuses existed are active.
+    if account.Status == "" {
+        return []string{ACCOUNT_ACTIVE}, nil
+    }
+    return []string{account.Status}, nil
+})
+
+var account_owner_msp_id_index = util.DefineIndex(ACCOUNT_TABLE, "OwnerMspID", func (row_json_bytes []byte) ([]string, error) {
+    var account Account
+    err := json.Unmarshal(row_json_bytes, &account)
+    if err != nil {
+        return nil, err
+    }
+    if account.Owner == nil {
+        return nil, nil
+    }
+    return []string{account.Owner.MspID}, nil
+})
+
+// Returns the ACCOUNT_TABLE index with the given name.
+func get_account_index (index_name string) (*util.Index, error) {
+    index := util.GetIndex(ACCOUNT_TABLE, index_name)
+    if index == nil {
+        return nil, coded_error(INVALID_PARAMS, "Unknown account index \"%s\"; must be \"%s\" or \"%s\"", index_name, account_status_index.Name, account_owner_msp_id_index.Name)
+    }
+    return index, nil
+}
+
+// Bookmark is empty if there are no further accounts; otherwise it is passed to query_accounts_by_index to get
+// the next page.
+type AccountsPage struct {
+    Accounts    []Account   `json:"Accounts"`
+    Count       int         `json:"Count"`
+    Bookmark    string      `json:"Bookmark"`
+}
+
+func normalize_accounts_ (stub shim.ChaincodeStubInterface, accounts []Account) error {
+    decimals,err := get_ledger_decimals(stub)
+    if err != nil {
+        return err
+    }
+    for i := range accounts {
+        err = normalize_account_(&accounts[i], decimals)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Raw form of function which does no permissions checking.  Returns the accounts whose key in the given index
+// is key, in name order.
+func get_accounts_by_index_ (stub shim.ChaincodeStubInterface, index *util.Index, key string) ([]Account, error) {
+    var accounts []Account
+    err := account_table.ScanIndex(stub, index, []string{key}, &accounts)
+    if err != nil {
+        return nil, wrap_error(err, "Could not get accounts by %s; %v", index.Name, err.Error())
+    }
+    err = normalize_accounts_(stub, accounts)
+    if err != nil {
+        return nil, err
+    }
+    return accounts, nil
+}
+
+// Raw form of function which does no permissions checking.  Returns up to page_size of the accounts whose key in
+// the given index is key, in name order, starting at the account named bookmark (or the next one after it) if
+// bookmark is not empty.
+func get_accounts_by_index_page_ (stub shim.ChaincodeStubInterface, index *util.Index, key string, bookmark string, page_size int) (*AccountsPage, error) {
+    var start_row_keys []string
+    if bookmark != "" {
+        start_row_keys = row_keys_of_Account(&Account{Name:bookmark})
+    }
+    page := &AccountsPage{Accounts:[]Account{}}
+    next_row_keys,err := account_table.ScanIndexPage(stub, index, []string{key}, start_row_keys, page_size, &page.Accounts)
+    if err != nil {
+        return nil, wrap_error(err, "Could not get accounts by %s; %v", index.Name, err.Error())
+    }
+    err = normalize_accounts_(stub, page.Accounts)
+    if err != nil {
+        return nil, err
+    }
+    page.Count = len(page.Accounts)
+    if len(next_row_keys) > 0 {
+        page.Bookmark = next_row_keys[0]
+    }
+    return page, nil
+}
+
+// Migration from schema version 2 to 3, which rewrites each account so that its index entries are put.
+func reindex_account_row (stub shim.ChaincodeStubInterface, row_json_bytes []byte) (interface{}, error) {
+    var account Account
+    err := json.Unmarshal(row_json_bytes, &account)
+    if err != nil {
+        return nil, err
+    }
+    return &account, nil
+}
+
+//
+// account index related chaincode API functions
+//
+
+// Query the accounts having a key in an account index.  Args are index_name ("Status" or "OwnerMspID") and key,
+// e.g. "Status" and "frozen", and optionally page_size and bookmark (as returned in the previous page).  Without
+// a page_size, a JSON array of all the accounts is returned; otherwise an AccountsPage is returned.  This has
+// the same authorization as query_account_names.
+func (t *SimpleChaincode) query_accounts_by_index (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) < 2 || len(args) > 4 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 2 to 4; index_name, key, and optionally page_size and bookmark")
+    }
+
+    index,err := get_account_index(args[0])
+    if err != nil {
+        return error_response(err)
+    }
+    err = check_permission(stub, "query_account_names", fmt.Sprintf("query accounts by %s", index.Name))
+    if err != nil {
+        return error_response(err)
+    }
+
+    if len(args) > 2 {
+        page_size,err := strconv.Atoi(args[2])
+        if err != nil || page_size < 1 || page_size > MAX_ACCOUNT_NAMES_PAGE_SIZE {
+            return coded_error_response(INVALID_PARAMS, "Invalid page_size \"%s\"; expecting integer between 1 and %d", args[2], MAX_ACCOUNT_NAMES_PAGE_SIZE)
+        }
+        bookmark := ""
+        if len(args) == 4 {
+            bookmark = args[3]
+        }
+        page,err := get_accounts_by_index_page_(stub, index, args[1], bookmark, page_size)
+        if err != nil {
+            return error_response(err)
+        }
+        bytes,err := json.Marshal(page)
+        if err != nil {
+            return shim.Error(fmt.Sprintf("Serializing accounts page failed in query_accounts_by_index because json.Marshal failed with error %v", err))
+        }
+        return shim.Success(bytes)
+    }
+
+    accounts,err := get_accounts_by_index_(stub, index, args[1])
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(accounts)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing accounts failed in query_accounts_by_index because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// Checks that an account index has exactly one entry for each account, and if repair is "true", rebuilds it.
+// Args are index_name and repair.  The payload is a util.IndexCheckReport.
+func (t *SimpleChaincode) check_account_index (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 2; index_name and repair")
+    }
+
+    // only Admin is allowed to check_account_index
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to check_account_index")
+    }
+
+    index,err := get_account_index(args[0])
+    if err != nil {
+        return error_response(err)
+    }
+    repair,err := strconv.ParseBool(args[1])
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid repair \"%s\"; expecting \"true\" or \"false\"", args[1])
+    }
+
+    report,err := util.CheckIndex(stub, index, repair)
+    if err != nil {
+        return error_response(err)
+    }
+
+    if repair && (len(report.MissingEntries) > 0 || len(report.StaleEntries) > 0) {
+        err = emit_event(stub, events.INDEX_REPAIRED, &events.IndexRepaired{Table:report.Table, Index:report.Index, MissingEntries:len(report.MissingEntries), StaleEntries:len(report.StaleEntries)})
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    bytes,err := json.Marshal(report)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing index check report failed in check_account_index because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
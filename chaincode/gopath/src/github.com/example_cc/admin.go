@@ -0,0 +1,396 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "strconv"
+)
+
+//
+// admin handover
+//
+// The admin can only be changed by a two-step handover: the current admin proposes a new admin, and the
+// proposed admin accepts.  If an admin council has been configured, then a proposal can't be accepted until
+// Threshold distinct council members have approved it.  There is at most one pending proposal at a time; a new
+// proposal replaces any pending one.  Every change emits a chaincode event (see the events package).
+//
+
+type AdminCouncil struct {
+    Members     []Identity  `json:"Members"`
+    Threshold   int         `json:"Threshold"`
+}
+
+var admin_council_table = util.NewTable(CONFIG_TABLE, AdminCouncil{}, util.FixedRowKeys("AdminCouncil"), nil)
+
+func (council *AdminCouncil) has_member (identity *Identity) bool {
+    for i := range council.Members {
+        if council.Members[i] == *identity {
+            return true
+        }
+    }
+    return false
+}
+
+// ProposalID is the ID of the transaction which made the proposal.
+type AdminChangeProposal struct {
+    ProposalID  string      `json:"ProposalID"`
+    NewAdmin    Identity    `json:"NewAdmin"`
+    ProposedBy  Identity    `json:"ProposedBy"`
+    // The distinct council members who have approved the proposal.
+    Approvals   []Identity  `json:"Approvals"`
+}
+
+var admin_change_proposal_table = util.NewTable(CONFIG_TABLE, AdminChangeProposal{}, util.FixedRowKeys("AdminChangeProposal"), nil)
+
+func (proposal *AdminChangeProposal) is_approved_by (identity *Identity) bool {
+    for i := range proposal.Approvals {
+        if proposal.Approvals[i] == *identity {
+            return true
+        }
+    }
+    return false
+}
+
+func (proposal *AdminChangeProposal) event_data () *events.AdminChangeProposal {
+    return &events.AdminChangeProposal{
+        ProposalID: proposal.ProposalID,
+        NewAdmin:   events.Identity(proposal.NewAdmin),
+        ProposedBy: events.Identity(proposal.ProposedBy),
+        Approvals:  event_identities(proposal.Approvals),
+    }
+}
+
+// If no council has been configured, then council is nil.
+func get_admin_council_ (stub shim.ChaincodeStubInterface) (*AdminCouncil, error) {
+    var council AdminCouncil
+    err := admin_council_table.Get(stub, admin_council_table.RowKeys(&council), &council)
+    if util.IsNotFound(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, wrap_error(err, "Could not retrieve AdminCouncil; error was %v", err.Error())
+    }
+    return &council, nil
+}
+
+// Raw form of function which does no permissions checking.  An empty council removes the council.
+func set_admin_council_ (stub shim.ChaincodeStubInterface, council *AdminCouncil) error {
+    if len(council.Members) == 0 {
+        err := admin_council_table.Delete(stub, admin_council_table.RowKeys(council), nil)
+        if util.IsNotFound(err) {
+            return nil
+        }
+        return err
+    }
+    _,err := admin_council_table.Upsert(stub, council, nil)
+    return err
+}
+
+// If there is no pending proposal, then proposal is nil.
+func get_admin_change_proposal_ (stub shim.ChaincodeStubInterface) (*AdminChangeProposal, error) {
+    var proposal AdminChangeProposal
+    err := admin_change_proposal_table.Get(stub, admin_change_proposal_table.RowKeys(&proposal), &proposal)
+    if util.IsNotFound(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, wrap_error(err, "Could not retrieve AdminChangeProposal; error was %v", err.Error())
+    }
+    return &proposal, nil
+}
+
+// Returns the pending proposal if its ID is proposal_id, and otherwise an error.
+func get_pending_admin_change_proposal_ (stub shim.ChaincodeStubInterface, proposal_id string) (*AdminChangeProposal, error) {
+    proposal,err := get_admin_change_proposal_(stub)
+    if err != nil {
+        return nil, err
+    }
+    if proposal == nil || proposal.ProposalID != proposal_id {
+        return nil, coded_error(NOT_FOUND, "There is no pending admin change proposal with ID \"%s\"", proposal_id)
+    }
+    return proposal, nil
+}
+
+func set_admin_change_proposal_ (stub shim.ChaincodeStubInterface, proposal *AdminChangeProposal) error {
+    _,err := admin_change_proposal_table.Upsert(stub, proposal, nil)
+    return err
+}
+
+func delete_admin_change_proposal_ (stub shim.ChaincodeStubInterface) error {
+    return admin_change_proposal_table.Delete(stub, admin_change_proposal_table.RowKeys(nil), nil)
+}
+
+//
+// admin handover chaincode API functions
+//
+
+// Proposes the identity given by an MSP ID and PEM-encoded certificate as the new admin.  Only the admin may
+// propose, so that the admin council can't replace the admin without the admin taking part; a new proposal
+// replaces the pending one.  The response payload is the ID of the proposal, which is needed to approve, accept
+// or cancel it.
+func (t *SimpleChaincode) propose_admin_change (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 2; new_admin_msp_id and new_admin_cert_pem")
+    }
+    new_admin,err := IdentityFromCertificatePEM(args[0], []byte(args[1]))
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid new admin; %v", err.Error())
+    }
+
+    transactor,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    // only Admin is allowed to propose_admin_change
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to propose_admin_change")
+    }
+    council,err := get_admin_council_(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    is_council_member := council != nil && council.has_member(transactor)
+
+    proposal := &AdminChangeProposal{
+        ProposalID: stub.GetTxID(),
+        NewAdmin:   *new_admin,
+        ProposedBy: *transactor,
+        Approvals:  []Identity{},
+    }
+    // Proposing implies approving.
+    if is_council_member {
+        proposal.Approvals = append(proposal.Approvals, *transactor)
+    }
+    err = set_admin_change_proposal_(stub, proposal)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not propose_admin_change; error was %v", err.Error()))
+    }
+    err = emit_event(stub, events.ADMIN_CHANGE_PROPOSED, proposal.event_data())
+    if err != nil {
+        return error_response(err)
+    }
+    return shim.Success([]byte(proposal.ProposalID))
+}
+
+// Records the transactor's approval of the pending proposal.  Only admin council members may approve.
+func (t *SimpleChaincode) approve_admin_change (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1; proposal_id")
+    }
+
+    transactor,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    council,err := get_admin_council_(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if council == nil || !council.has_member(transactor) {
+        return coded_error_response(UNAUTHORIZED, "Only a member of the admin council is authorized to approve_admin_change")
+    }
+
+    proposal,err := get_pending_admin_change_proposal_(stub, args[0])
+    if err != nil {
+        return error_response(err)
+    }
+    if proposal.is_approved_by(transactor) {
+        return shim.Error(fmt.Sprintf("User %v has already approved admin change proposal \"%s\"", transactor, proposal.ProposalID))
+    }
+    proposal.Approvals = append(proposal.Approvals, *transactor)
+    err = set_admin_change_proposal_(stub, proposal)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not approve_admin_change; error was %v", err.Error()))
+    }
+    err = emit_event(stub, events.ADMIN_CHANGE_APPROVED, proposal.event_data())
+    if err != nil {
+        return error_response(err)
+    }
+    return shim.Success(nil)
+}
+
+// Completes the handover.  Only the proposed new admin may accept, and only once the proposal has enough
+// approvals, if an admin council has been configured.
+func (t *SimpleChaincode) accept_admin_change (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1; proposal_id")
+    }
+
+    proposal,err := get_pending_admin_change_proposal_(stub, args[0])
+    if err != nil {
+        return error_response(err)
+    }
+    is_new_admin,err := transactor_is(stub, &proposal.NewAdmin)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_new_admin {
+        return coded_error_response(UNAUTHORIZED, "Only the proposed new admin user is authorized to accept_admin_change")
+    }
+    council,err := get_admin_council_(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if council != nil {
+        // Approvals by identities which have since been removed from the council don't count.
+        approval_count := 0
+        for i := range proposal.Approvals {
+            if council.has_member(&proposal.Approvals[i]) {
+                approval_count++
+            }
+        }
+        if approval_count < council.Threshold {
+            return shim.Error(fmt.Sprintf("Admin change proposal \"%s\" has %d of the %d required admin council approvals", proposal.ProposalID, approval_count, council.Threshold))
+        }
+    }
+
+    old_admin,err := get_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    err = set_admin(stub, &Admin{Identity:proposal.NewAdmin})
+    if err != nil {
+        return error_response(err)
+    }
+    err = delete_admin_change_proposal_(stub)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not accept_admin_change; error was %v", err.Error()))
+    }
+    err = emit_event(stub, events.ADMIN_CHANGED, &events.AdminChanged{ProposalID:proposal.ProposalID, OldAdmin:events.Identity(old_admin.Identity), NewAdmin:events.Identity(proposal.NewAdmin)})
+    if err != nil {
+        return error_response(err)
+    }
+    return shim.Success(nil)
+}
+
+// Withdraws the pending proposal.  The admin and the proposer may cancel.
+func (t *SimpleChaincode) cancel_admin_change (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1; proposal_id")
+    }
+
+    proposal,err := get_pending_admin_change_proposal_(stub, args[0])
+    if err != nil {
+        return error_response(err)
+    }
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    is_proposer,err := transactor_is(stub, &proposal.ProposedBy)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin && !is_proposer {
+        return coded_error_response(UNAUTHORIZED, "Only the admin user or the proposer is authorized to cancel_admin_change")
+    }
+
+    err = delete_admin_change_proposal_(stub)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not cancel_admin_change; error was %v", err.Error()))
+    }
+    err = emit_event(stub, events.ADMIN_CHANGE_CANCELLED, proposal.event_data())
+    if err != nil {
+        return error_response(err)
+    }
+    return shim.Success(nil)
+}
+
+// Sets the admin council to the given members (a JSON array of identities, as returned by
+// query_transactor_identity), of whom threshold must approve an admin change.  An empty array of members
+// removes the council, so that the admin alone can hand over.
+func (t *SimpleChaincode) set_admin_council (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 2; threshold and members (JSON array of identities)")
+    }
+
+    // only Admin is allowed to set_admin_council
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to set_admin_council")
+    }
+
+    council := AdminCouncil{Members:[]Identity{}}
+    council.Threshold,err = strconv.Atoi(args[0])
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Malformed threshold string \"%s\"; expecting nonnegative integer", args[0])
+    }
+    err = json.Unmarshal([]byte(args[1]), &council.Members)
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Malformed members \"%s\"; expecting a JSON array of identities", args[1])
+    }
+    for i := range council.Members {
+        err = council.Members[i].Validate()
+        if err != nil {
+            return coded_error_response(INVALID_PARAMS, "Invalid admin council member %d; %v", i, err.Error())
+        }
+        for j := 0; j < i; j++ {
+            if council.Members[j] == council.Members[i] {
+                return shim.Error(fmt.Sprintf("Admin council member %v is listed more than once", &council.Members[i]))
+            }
+        }
+    }
+    if len(council.Members) == 0 && council.Threshold != 0 {
+        return coded_error_response(INVALID_PARAMS, "Invalid threshold %d; expecting 0 for an empty admin council", council.Threshold)
+    }
+    if len(council.Members) > 0 && (council.Threshold < 1 || council.Threshold > len(council.Members)) {
+        return coded_error_response(INVALID_PARAMS, "Invalid threshold %d; expecting between 1 and the number of members (%d)", council.Threshold, len(council.Members))
+    }
+
+    err = set_admin_council_(stub, &council)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not set_admin_council; error was %v", err.Error()))
+    }
+    err = emit_event(stub, events.ADMIN_COUNCIL_CHANGED, &events.AdminCouncilChanged{Members:event_identities(council.Members), Threshold:council.Threshold})
+    if err != nil {
+        return error_response(err)
+    }
+    return shim.Success(nil)
+}
+
+// Query the admin, the admin council (null if there is none) and the pending admin change proposal (null if
+// there is none).
+func (t *SimpleChaincode) query_admin (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 arguments, got %v", args)
+    }
+
+    err := check_permission(stub, "query_admin", "query_admin")
+    if err != nil {
+        return error_response(err)
+    }
+
+    admin,err := get_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    council,err := get_admin_council_(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    proposal,err := get_admin_change_proposal_(stub)
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(struct{
+        Admin       Identity                `json:"Admin"`
+        Council     *AdminCouncil           `json:"Council"`
+        Proposal    *AdminChangeProposal    `json:"Proposal"`
+    }{admin.Identity, council, proposal})
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing admin failed in query_admin because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/decimal"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//
+// allowances
+//
+// The holder of an account can approve a spender (any identity) to transfer up to some amount of the default
+// asset out of the account using transfer_from, which decrements the allowance by the amount transferred.  An
+// allowance is an Allowance row, which is absent while the allowance is zero.
+//
+
+const ALLOWANCE_TABLE = "AllowanceTable"
+
+type Allowance struct {
+    Account     string          `json:"Account"`
+    Spender     Identity        `json:"Spender"`
+    Amount      decimal.Amount  `json:"Amount"`
+}
+
+func row_keys_of_Allowance (allowance *Allowance) []string {
+    return []string{allowance.Account, allowance.Spender.MspID, allowance.Spender.Subject, allowance.Spender.Issuer}
+}
+
+var allowance_table = util.NewTable(ALLOWANCE_TABLE, Allowance{}, func (row interface{}) []string { return row_keys_of_Allowance(row.(*Allowance)) }, nil)
+
+// Raw form of function which does no permissions checking.  A spender that was never approved has a zero
+// allowance.
+func get_allowance_ (stub shim.ChaincodeStubInterface, account_name string, spender *Identity) (*Allowance, error) {
+    decimals,err := get_ledger_decimals(stub)
+    if err != nil {
+        return nil, err
+    }
+    allowance := Allowance{Account:account_name, Spender:*spender, Amount:decimal.Zero(decimals)}
+    err = allowance_table.Get(stub, row_keys_of_Allowance(&allowance), &allowance)
+    if err != nil && !util.IsNotFound(err) {
+        return nil, wrap_error(err, "Could not retrieve allowance of %v for account \"%s\"; error was %v", spender, account_name, err.Error())
+    }
+    return &allowance, nil
+}
+
+// Raw form of function which does no permissions checking.  A zero allowance is stored by deleting its row.
+func put_allowance_ (stub shim.ChaincodeStubInterface, allowance *Allowance) error {
+    if allowance.Amount.Sign() == 0 {
+        err := allowance_table.Delete(stub, row_keys_of_Allowance(allowance), nil)
+        if util.IsNotFound(err) {
+            return nil
+        }
+        return err
+    }
+    _,err := allowance_table.Upsert(stub, allowance, nil)
+    return err
+}
+
+// Raw form of function which does no permissions checking.  Deletes every allowance granted by the account.
+func delete_allowances_ (stub shim.ChaincodeStubInterface, account_name string) error {
+    var allowances []Allowance
+    err := allowance_table.Scan(stub, []string{account_name}, &allowances)
+    if err != nil {
+        return wrap_error(err, "Could not get allowances of account \"%s\"; %v", account_name, err.Error())
+    }
+    for i := range allowances {
+        err = allowance_table.Delete(stub, row_keys_of_Allowance(&allowances[i]), nil)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+//
+// allowance related chaincode API functions
+//
+
+// Sets the allowance of a spender for an account, replacing any previous allowance.  Args are account_name,
+// spender_msp_id, spender_cert_pem and amount.  Only the account holder may approve spenders.
+func (t *SimpleChaincode) approve (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 4 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 4; account_name, spender_msp_id, spender_cert_pem and amount")
+    }
+
+    account_name := args[0]
+    spender,err := IdentityFromCertificatePEM(args[1], []byte(args[2]))
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid spender for account \"%s\"; %v", account_name, err.Error())
+    }
+    amount,err := parse_amount(stub, args[3])
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid allowance amount \"%s\"; %v", args[3], err.Error())
+    }
+    if amount.Sign() < 0 {
+        return coded_error_response(INVALID_PARAMS, "Invalid allowance amount %v; expecting non-negative amount", amount)
+    }
+
+    is_holder,err := transactor_is_account_owner(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_holder {
+        return coded_error_response(UNAUTHORIZED, "Only the holder of account \"%s\" is authorized to approve spenders", account_name)
+    }
+
+    account,err := get_account_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    err = check_account_is_active(account)
+    if err != nil {
+        return error_response(err)
+    }
+
+    err = put_allowance_(stub, &Allowance{Account:account_name, Spender:*spender, Amount:amount})
+    if err != nil {
+        return error_response(wrap_error(err, "Could not approve %v for account \"%s\"; error was %v", spender, account_name, err.Error()))
+    }
+
+    err = emit_event(stub, events.APPROVED, &events.Approved{Account:account_name, Spender:events.Identity(*spender), Amount:amount.String()})
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Query the allowance of a spender for an account.  Args are account_name, spender_msp_id and spender_cert_pem.
+func (t *SimpleChaincode) query_allowance (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 3; account_name, spender_msp_id and spender_cert_pem")
+    }
+
+    account_name := args[0]
+    spender,err := IdentityFromCertificatePEM(args[1], []byte(args[2]))
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid spender for account \"%s\"; %v", account_name, err.Error())
+    }
+
+    // The account holder and the spender are allowed to query_allowance, as is anyone with permission to
+    // query_balance (e.g. Admin).
+    is_holder,err := transactor_is_account_owner(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    is_spender,err := transactor_is(stub, spender)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_holder && !is_spender {
+        err = check_permission(stub, "query_balance", fmt.Sprintf("query allowances of account \"%s\"", account_name))
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    if _,err = get_account_(stub, account_name); err != nil {
+        return error_response(wrap_error(err, "Could not query_allowance for account \"%s\"; error was %v", account_name, err))
+    }
+    allowance,err := get_allowance_(stub, account_name, spender)
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(allowance)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing allowance failed in query_allowance because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// Transfers from an account as a spender approved by its holder, decrementing the transactor's allowance.  Args
+// are from_account_name, to_account_name and amount.  Any transfer fee is paid by the account, and doesn't count
+// against the allowance.
+func (t *SimpleChaincode) transfer_from (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 3; 2 names and 1 value")
+    }
+
+    from_account_name := args[0]
+    to_account_name := args[1]
+    amount,err := parse_amount(stub, args[2])
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid transaction amount \"%s\"; %v", args[2], err.Error())
+    }
+    if amount.Sign() < 0 {
+        return shim.Error(fmt.Sprintf("Can't transfer a negative amount (%v)", amount))
+    }
+
+    spender,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    allowance,err := get_allowance_(stub, from_account_name, spender)
+    if err != nil {
+        return error_response(err)
+    }
+    if allowance.Amount.Cmp(amount) < 0 {
+        return shim.Error(fmt.Sprintf("Could not transfer %v from account \"%s\"; the allowance of %v is only %v", amount, from_account_name, spender, allowance.Amount))
+    }
+    allowance.Amount,err = allowance.Amount.Sub(amount)
+    if err != nil {
+        return error_response(err)
+    }
+    err = put_allowance_(stub, allowance)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not update allowance of %v for account \"%s\"; error was %v", spender, from_account_name, err.Error()))
+    }
+
+    result := &TransferResult{FromAccount:from_account_name, ToAccount:to_account_name, Amount:amount}
+    result.Fee,err = transfer_(stub, from_account_name, to_account_name, amount)
+    if err != nil {
+        return error_response(err)
+    }
+
+    event_data := result.event_data()
+    event_spender := events.Identity(*spender)
+    event_data.Spender = &event_spender
+    err = emit_event(stub, events.TRANSFERRED, event_data)
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(result)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing transfer result failed in transfer_from because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/decimal"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "regexp"
+    "strconv"
+)
+
+//
+// assets
+//
+// Besides the default asset, whose balance is Account.Balance and whose number of decimals is given by
+// LedgerSettings, the admin can define further assets.  The balance of an account in such an asset is an
+// AssetBalance row, which is absent until the account first holds any of it.
+//
+
+// The symbol that denotes the default asset in chaincode args.
+const DEFAULT_ASSET = ""
+
+const ASSET_TABLE = "AssetTable"
+const BALANCE_TABLE = "BalanceTable"
+
+var asset_symbol_regexp = regexp.MustCompile("^[A-Z][A-Z0-9]{0,11}$")
+
+func ValidateAssetSymbol (symbol string) error {
+    if !asset_symbol_regexp.MatchString(symbol) {
+        return coded_error(INVALID_PARAMS, "Invalid asset symbol \"%s\"; must be 1 to 12 uppercase letters and digits, starting with a letter", symbol)
+    }
+    return nil
+}
+
+type Asset struct {
+    Symbol      string          `json:"Symbol"`
+    // The number of decimals of balances and amounts of this asset.
+    Decimals    int             `json:"Decimals"`
+    // The identity responsible for issuing the asset, or nil if there is none.
+    Issuer      *Identity       `json:"Issuer,omitempty"`
+    // The maximum Supply, or nil if the supply is uncapped.
+    SupplyCap   *decimal.Amount `json:"SupplyCap,omitempty"`
+    // The total of all balances of this asset.
+    Supply      decimal.Amount  `json:"Supply"`
+}
+
+func row_keys_of_Asset (asset *Asset) []string {
+    return []string{asset.Symbol}
+}
+
+var asset_table = util.NewTable(ASSET_TABLE, Asset{}, func (row interface{}) []string { return row_keys_of_Asset(row.(*Asset)) }, nil)
+
+type AssetBalance struct {
+    Account     string          `json:"Account"`
+    Asset       string          `json:"Asset"`
+    Balance     decimal.Amount  `json:"Balance"`
+}
+
+func row_keys_of_AssetBalance (asset_balance *AssetBalance) []string {
+    return []string{asset_balance.Account, asset_balance.Asset}
+}
+
+var asset_balance_table = util.NewTable(BALANCE_TABLE, AssetBalance{}, func (row interface{}) []string { return row_keys_of_AssetBalance(row.(*AssetBalance)) }, nil)
+
+// Raw form of function which does no permissions checking
+func create_asset_ (stub shim.ChaincodeStubInterface, asset *Asset) error {
+    return asset_table.Insert(stub, asset)
+}
+
+// Raw form of function which does no permissions checking
+func overwrite_asset_ (stub shim.ChaincodeStubInterface, asset *Asset) error {
+    return asset_table.Update(stub, asset)
+}
+
+// Raw form of function which does no permissions checking
+func get_asset_ (stub shim.ChaincodeStubInterface, symbol string) (*Asset, error) {
+    var asset Asset
+    err := asset_table.Get(stub, []string{symbol}, &asset)
+    if util.IsNotFound(err) {
+        return nil, coded_error(NOT_FOUND, "Could not retrieve asset \"%s\"; error was %v", symbol, err.Error())
+    }
+    if err != nil {
+        return nil, wrap_error(err, "Could not retrieve asset \"%s\"; error was %v", symbol, err.Error())
+    }
+    return &asset, nil
+}
+
+func get_assets_ (stub shim.ChaincodeStubInterface) ([]Asset, error) {
+    var assets []Asset
+    err := asset_table.Scan(stub, []string{}, &assets)
+    if err != nil {
+        return nil, wrap_error(err, "Could not get assets; %v", err.Error())
+    }
+    return assets, nil
+}
+
+// Returns the number of decimals of amounts of the asset with the given symbol (which may be DEFAULT_ASSET).
+func get_asset_decimals (stub shim.ChaincodeStubInterface, symbol string) (int, error) {
+    if symbol == DEFAULT_ASSET {
+        return get_ledger_decimals(stub)
+    }
+    asset,err := get_asset_(stub, symbol)
+    if err != nil {
+        return 0, err
+    }
+    return asset.Decimals, nil
+}
+
+// Parses an amount given as a chaincode arg, which may have at most the asset's number of decimals.
+func parse_asset_amount (stub shim.ChaincodeStubInterface, symbol string, s string) (decimal.Amount, error) {
+    decimals,err := get_asset_decimals(stub, symbol)
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+    return decimal.Parse(s, decimals)
+}
+
+// Raw form of function which does no permissions checking.  An account that has never held the asset has a
+// zero balance of it.
+func get_asset_balance_ (stub shim.ChaincodeStubInterface, account_name string, asset *Asset) (*AssetBalance, error) {
+    asset_balance := AssetBalance{Account:account_name, Asset:asset.Symbol, Balance:decimal.Zero(asset.Decimals)}
+    err := asset_balance_table.Get(stub, row_keys_of_AssetBalance(&asset_balance), &asset_balance)
+    if err != nil && !util.IsNotFound(err) {
+        return nil, wrap_error(err, "Could not retrieve balance of account \"%s\" in asset \"%s\"; error was %v", account_name, asset.Symbol, err.Error())
+    }
+    return &asset_balance, nil
+}
+
+// Raw form of function which does no permissions checking
+func put_asset_balance_ (stub shim.ChaincodeStubInterface, asset_balance *AssetBalance) error {
+    _,err := asset_balance_table.Upsert(stub, asset_balance, nil)
+    return err
+}
+
+// Raw form of function which does no permissions checking.  Returns every asset balance row of the account.
+func get_asset_balances_ (stub shim.ChaincodeStubInterface, account_name string) ([]AssetBalance, error) {
+    var asset_balances []AssetBalance
+    err := asset_balance_table.Scan(stub, []string{account_name}, &asset_balances)
+    if err != nil {
+        return nil, wrap_error(err, "Could not get balances of account \"%s\"; %v", account_name, err.Error())
+    }
+    return asset_balances, nil
+}
+
+// Raw form of function which does no permissions checking.  This doesn't change the supply of the asset.
+func delete_asset_balance_ (stub shim.ChaincodeStubInterface, asset_balance *AssetBalance) error {
+    return asset_balance_table.Delete(stub, row_keys_of_AssetBalance(asset_balance), nil)
+}
+
+// Takes amount out of the supply of the asset in memory only.
+func retire_ (asset *Asset, amount decimal.Amount) error {
+    supply,err := asset.Supply.Sub(amount)
+    if err != nil {
+        return err
+    }
+    if supply.Sign() < 0 {
+        return fmt.Errorf("Can't retire %v %s; the supply is only %v", amount, asset.Symbol, asset.Supply)
+    }
+    asset.Supply = supply
+    return nil
+}
+
+// Adds amount to the supply of the asset in memory only, checking the supply cap.
+func issue_ (asset *Asset, amount decimal.Amount) error {
+    supply,err := asset.Supply.Add(amount)
+    if err != nil {
+        return wrap_error(err, "Can't issue %v %s; %v", amount, asset.Symbol, err.Error())
+    }
+    if asset.SupplyCap != nil && supply.Cmp(*asset.SupplyCap) > 0 {
+        return fmt.Errorf("Can't issue %v %s; the supply would be %v, which exceeds the supply cap of %v", amount, asset.Symbol, supply, *asset.SupplyCap)
+    }
+    asset.Supply = supply
+    return nil
+}
+
+// Raw form of function which does no permissions checking
+func transfer_asset_by_name_ (stub shim.ChaincodeStubInterface, from_account_name string, to_account_name string, asset_symbol string, amount decimal.Amount) error {
+    from_account,err := get_account_(stub, from_account_name)
+    if err != nil {
+        return wrap_error(err, "Error in retrieving \"from\" account \"%s\"; %v", from_account_name, err.Error())
+    }
+    if err = check_account_is_active(from_account); err != nil {
+        return err
+    }
+    to_account,err := get_account_(stub, to_account_name)
+    if err != nil {
+        return wrap_error(err, "Error in retrieving \"to\" account \"%s\"; %v", to_account_name, err.Error())
+    }
+    if err = check_account_is_active(to_account); err != nil {
+        return err
+    }
+    if err = check_asset_transfer_limits_(stub, from_account, to_account_name, asset_symbol); err != nil {
+        return err
+    }
+    asset,err := get_asset_(stub, asset_symbol)
+    if err != nil {
+        return err
+    }
+    return transfer_asset_(stub, from_account_name, to_account_name, asset, amount)
+}
+
+// Raw form of function which does no permissions checking.  The accounts must exist.
+func transfer_asset_ (stub shim.ChaincodeStubInterface, from_account_name string, to_account_name string, asset *Asset, amount decimal.Amount) error {
+    from_balance,err := get_asset_balance_(stub, from_account_name, asset)
+    if err != nil {
+        return err
+    }
+    to_balance,err := get_asset_balance_(stub, to_account_name, asset)
+    if err != nil {
+        return err
+    }
+    err = move_amount_(&from_balance.Balance, &to_balance.Balance, from_account_name == to_account_name, amount)
+    if err != nil {
+        return err
+    }
+    err = put_asset_balance_(stub, from_balance)
+    if err != nil {
+        return err
+    }
+    return put_asset_balance_(stub, to_balance)
+}
+
+//
+// asset related chaincode API functions
+//
+
+// Defines a new asset.  Args are symbol, decimals, supply_cap (empty for an uncapped supply), and optionally
+// issuer_msp_id and issuer_cert_pem.
+func (t *SimpleChaincode) define_asset (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 && len(args) != 5 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 3 or 5; symbol, decimals, supply_cap, and optionally issuer_msp_id and issuer_cert_pem")
+    }
+
+    // only Admin is allowed to define_asset
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to define_asset")
+    }
+
+    symbol := args[0]
+    err = ValidateAssetSymbol(symbol)
+    if err != nil {
+        return error_response(err)
+    }
+    // The decimals are validated by rescaling the zero supply to them.
+    decimals,err := strconv.Atoi(args[1])
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Malformed decimals \"%s\"; expecting integer between 0 and %d", args[1], decimal.MAX_DECIMALS)
+    }
+    supply,err := decimal.Zero(0).Rescale(decimals)
+    if err != nil {
+        return error_response(err)
+    }
+    asset := Asset{Symbol:symbol, Decimals:decimals, Supply:supply}
+    if args[2] != "" {
+        supply_cap,err := decimal.Parse(args[2], decimals)
+        if err != nil || supply_cap.Sign() < 0 {
+            return coded_error_response(INVALID_PARAMS, "Invalid supply_cap \"%s\"; expecting nonnegative amount with at most %d decimals", args[2], decimals)
+        }
+        asset.SupplyCap = &supply_cap
+    }
+    if len(args) == 5 {
+        asset.Issuer,err = IdentityFromCertificatePEM(args[3], []byte(args[4]))
+        if err != nil {
+            return coded_error_response(INVALID_PARAMS, "Invalid issuer for asset \"%s\"; %v", symbol, err.Error())
+        }
+    }
+
+    err = create_asset_(stub, &asset)
+    if err != nil {
+        return error_response(err)
+    }
+
+    event := &events.AssetDefined{Symbol:symbol, Decimals:decimals, Issuer:(*events.Identity)(asset.Issuer)}
+    if asset.SupplyCap != nil {
+        event.SupplyCap = asset.SupplyCap.String()
+    }
+    err = emit_event(stub, events.ASSET_DEFINED, event)
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Query all assets other than the default asset.
+func (t *SimpleChaincode) query_assets (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 arguments, got %v", args)
+    }
+
+    err := check_permission(stub, "query_assets", "query_assets")
+    if err != nil {
+        return error_response(err)
+    }
+
+    assets,err := get_assets_(stub)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not query_assets due to error %v", err.Error()))
+    }
+
+    bytes,err := json.Marshal(assets)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing assets failed in query_assets because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/decimal"
+    "github.com/example_cc/events"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//
+// batch transfers
+//
+
+const MAX_BATCH_TRANSFER_LEGS = 100
+
+// Amount may be given as a JSON string (e.g. "12.50") or number, with at most the asset's number of decimals.
+// Asset may be omitted for the default asset.
+type TransferLeg struct {
+    From    string          `json:"From"`
+    To      string          `json:"To"`
+    Asset   string          `json:"Asset,omitempty"`
+    Amount  decimal.Amount  `json:"Amount"`
+}
+
+// The outcome of one leg of a batch_transfer.  If Error is empty, then the leg succeeded, and the balances (in
+// the leg's asset) are those of the accounts right after the leg (taking the preceding legs of the batch into
+// account).
+type TransferLegResult struct {
+    TransferLeg
+    Index       int             `json:"Index"`
+    Error       string          `json:"Error,omitempty"`
+    // The code of Error (see CodedError), if the leg failed.
+    ErrorCode   ErrorCode       `json:"ErrorCode,omitempty"`
+    FromBalance decimal.Amount  `json:"FromBalance"`
+    ToBalance   decimal.Amount  `json:"ToBalance"`
+    // The fee charged on a leg of the default asset (see FeeSchedule), or nil if the leg was free.
+    Fee         *TransferFee    `json:"Fee,omitempty"`
+}
+
+func (result *TransferLegResult) fail (err error) {
+    result.Error = err.Error()
+    result.ErrorCode = error_code_of(err)
+}
+
+// Applies the legs in order to accounts read from the ledger, checking authorization and transfer limits (using
+// tracker) and charging fees for each leg as transfer does.  A failed leg doesn't change any balances, and the
+// remaining legs are still checked, so that the report covers every leg.  Nothing is written to the ledger; the
+// accounts and asset balances touched are returned (in order of first use) along with the report and whether
+// every leg succeeded.
+func apply_transfer_legs_ (stub shim.ChaincodeStubInterface, legs []TransferLeg, tracker *outflow_tracker) ([]*Account, []*AssetBalance, []TransferLegResult, bool, error) {
+    // GetState doesn't see this transaction's writes, so the balances are tracked here.
+    accounts := new_account_cache(stub)
+    assets := make(map[string]*Asset)
+    asset_balances := make(map[string]*AssetBalance)
+    var touched_asset_balances []*AssetBalance
+    asset_balance := func (account_name string, asset_symbol string) (*AssetBalance, error) {
+        // Account names and asset symbols can't contain U+0000 (see CreateCompositeKey), so this is unambiguous.
+        key := account_name + "\x00" + asset_symbol
+        if b,ok := asset_balances[key]; ok {
+            return b, nil
+        }
+        asset,ok := assets[asset_symbol]
+        if !ok {
+            var err error
+            asset,err = get_asset_(stub, asset_symbol)
+            if err != nil {
+                return nil, err
+            }
+            assets[asset_symbol] = asset
+        }
+        b,err := get_asset_balance_(stub, account_name, asset)
+        if err != nil {
+            return nil, err
+        }
+        asset_balances[key] = b
+        touched_asset_balances = append(touched_asset_balances, b)
+        return b, nil
+    }
+
+    // Computed on first need, since most batches are made by holders or by someone with permission for all legs.
+    var permission_error error
+    permission_checked := false
+
+    results := make([]TransferLegResult, len(legs))
+    all_succeeded := true
+    for i,leg := range legs {
+        result := &results[i]
+        result.TransferLeg = leg
+        result.Index = i
+
+        from_account,err := accounts.get(leg.From)
+        if err != nil {
+            result.fail(wrap_error(err, "Error in retrieving \"from\" account \"%s\"; %v", leg.From, err.Error()))
+            all_succeeded = false
+            continue
+        }
+        to_account,err := accounts.get(leg.To)
+        if err != nil {
+            result.fail(wrap_error(err, "Error in retrieving \"to\" account \"%s\"; %v", leg.To, err.Error()))
+            all_succeeded = false
+            continue
+        }
+
+        // The account holder is allowed to transfer, as is anyone with permission to transfer (e.g. Admin).
+        is_holder := false
+        if from_account.Owner != nil {
+            is_holder,err = transactor_is(stub, from_account.Owner)
+            if err != nil {
+                return nil, nil, nil, false, err
+            }
+        }
+        if !is_holder {
+            if !permission_checked {
+                permission_error = check_permission(stub, "transfer", "transfer from accounts they don't hold")
+                permission_checked = true
+            }
+            if permission_error != nil {
+                result.fail(wrap_error(permission_error, "Not authorized to transfer from account \"%s\"; %v", leg.From, permission_error.Error()))
+                all_succeeded = false
+                continue
+            }
+        }
+
+        if leg.Asset == DEFAULT_ASSET {
+            result.Fee,err = move_with_fee_(stub, accounts, tracker, leg.From, leg.To, leg.Amount)
+            if err != nil {
+                result.fail(err)
+                all_succeeded = false
+                continue
+            }
+            result.FromBalance = from_account.Balance
+            result.ToBalance = to_account.Balance
+        } else {
+            err = check_account_is_active(from_account)
+            if err == nil {
+                err = check_account_is_active(to_account)
+            }
+            if err == nil {
+                err = check_asset_transfer_limits_(stub, from_account, leg.To, leg.Asset)
+            }
+            if err != nil {
+                result.fail(err)
+                all_succeeded = false
+                continue
+            }
+            from_balance,err := asset_balance(leg.From, leg.Asset)
+            if err != nil {
+                result.fail(err)
+                all_succeeded = false
+                continue
+            }
+            to_balance,err := asset_balance(leg.To, leg.Asset)
+            if err != nil {
+                result.fail(err)
+                all_succeeded = false
+                continue
+            }
+            err = move_amount_(&from_balance.Balance, &to_balance.Balance, leg.From == leg.To, leg.Amount)
+            if err != nil {
+                result.fail(err)
+                all_succeeded = false
+                continue
+            }
+            result.FromBalance = from_balance.Balance
+            result.ToBalance = to_balance.Balance
+        }
+    }
+    return accounts.touched, touched_asset_balances, results, all_succeeded, nil
+}
+
+// Transfers between several pairs of accounts.  The single arg is a JSON array of TransferLeg, e.g.
+// [{"From":"Alice","To":"Bob","Amount":10}, ...].  Either every leg is applied or none is.  The payload
+// (or, upon failure, the end of the error message) is a JSON array of TransferLegResult.
+func (t *SimpleChaincode) batch_transfer (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1; a JSON array of transfer legs")
+    }
+
+    var legs []TransferLeg
+    err := json.Unmarshal([]byte(args[0]), &legs)
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid transfer legs \"%s\"; json.Unmarshal failed with error %v", args[0], err)
+    }
+    if len(legs) == 0 || len(legs) > MAX_BATCH_TRANSFER_LEGS {
+        return shim.Error(fmt.Sprintf("Expected between 1 and %d transfer legs, got %d", MAX_BATCH_TRANSFER_LEGS, len(legs)))
+    }
+
+    for i := range legs {
+        decimals,err := get_asset_decimals(stub, legs[i].Asset)
+        if err != nil {
+            return coded_error_response(INVALID_PARAMS, "Invalid asset in transfer leg %d; %v", i, err.Error())
+        }
+        legs[i].Amount,err = legs[i].Amount.Rescale(decimals)
+        if err != nil {
+            return coded_error_response(INVALID_PARAMS, "Invalid amount in transfer leg %d; %v", i, err.Error())
+        }
+    }
+
+    tracker := new_outflow_tracker(stub)
+    touched_accounts,touched_asset_balances,results,all_succeeded,err := apply_transfer_legs_(stub, legs, tracker)
+    if err != nil {
+        return error_response(err)
+    }
+
+    report,err := json.Marshal(results)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing transfer report failed in batch_transfer because json.Marshal failed with error %v", err))
+    }
+    if !all_succeeded {
+        // The response has the code of the first failed leg.
+        code := CHAINCODE_ERROR
+        for _,result := range results {
+            if result.Error != "" {
+                code = result.ErrorCode
+                break
+            }
+        }
+        return error_response(&CodedError{Code:code, Message:fmt.Sprintf("batch_transfer failed, so no transfers were made; report: %s", string(report))})
+    }
+
+    for _,account := range touched_accounts {
+        err = overwrite_account_(stub, account)
+        if err != nil {
+            return error_response(wrap_error(err, "Could not batch_transfer; error was %v", err.Error()))
+        }
+    }
+    for _,asset_balance := range touched_asset_balances {
+        err = put_asset_balance_(stub, asset_balance)
+        if err != nil {
+            return error_response(wrap_error(err, "Could not batch_transfer; error was %v", err.Error()))
+        }
+    }
+    err = tracker.write()
+    if err != nil {
+        return error_response(wrap_error(err, "Could not batch_transfer; error was %v", err.Error()))
+    }
+
+    transfers := make([]events.Transferred, len(results))
+    for i,result := range results {
+        transfer_result := &TransferResult{FromAccount:result.From, ToAccount:result.To, Asset:result.Asset, Amount:result.Amount, Fee:result.Fee}
+        transfers[i] = *transfer_result.event_data()
+    }
+    err = emit_event(stub, events.BATCH_TRANSFERRED, &events.BatchTransferred{Transfers:transfers})
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(report)
+}
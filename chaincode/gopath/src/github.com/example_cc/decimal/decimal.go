@@ -0,0 +1,210 @@
+// Package decimal provides the fixed-point decimal Amount used for account balances.  An Amount is an integer
+// number of units together with a number of decimals, so e.g. "12.50" is 1250 units with 2 decimals.  The
+// arithmetic is exact, and results whose magnitude would need more than MAX_DIGITS digits are rejected rather
+// than silently wrapping around.
+package decimal
+
+import (
+    "encoding/json"
+    "fmt"
+    "math/big"
+    "regexp"
+    "strings"
+)
+
+// The maximum number of decimal digits (integer and fractional together) of an Amount.
+const MAX_DIGITS = 38
+
+// The maximum number of decimals of an Amount.
+const MAX_DECIMALS = 18
+
+var amount_regexp = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+var ten = big.NewInt(10)
+
+// The zero value is zero with no decimals.  Amounts are immutable; arithmetic returns new Amounts.
+type Amount struct {
+    units       *big.Int
+    decimals    int
+}
+
+func Zero (decimals int) Amount {
+    return Amount{decimals:decimals}
+}
+
+// Parses s, which must have at most the given number of decimals, e.g. Parse("12.5", 2) is "12.50".
+// Exponents, leading "+" and surrounding whitespace are not accepted.
+func Parse (s string, decimals int) (Amount, error) {
+    amount,err := parse_exact(s)
+    if err != nil {
+        return Amount{}, err
+    }
+    if amount.decimals > decimals {
+        return Amount{}, fmt.Errorf("Amount \"%s\" has more than %d decimals", s, decimals)
+    }
+    return amount.Rescale(decimals)
+}
+
+// Parses s, with as many decimals as s has digits after the decimal point.
+func parse_exact (s string) (Amount, error) {
+    if !amount_regexp.MatchString(s) {
+        return Amount{}, fmt.Errorf("Malformed amount \"%s\"; expecting a decimal number such as \"12.50\"", s)
+    }
+    digits := s
+    decimals := 0
+    if point := strings.Index(s, "."); point >= 0 {
+        digits = s[:point] + s[point+1:]
+        decimals = len(s) - point - 1
+    }
+    if decimals > MAX_DECIMALS {
+        return Amount{}, fmt.Errorf("Amount \"%s\" has more than %d decimals", s, MAX_DECIMALS)
+    }
+    units,ok := new(big.Int).SetString(digits, 10)
+    if !ok {
+        return Amount{}, fmt.Errorf("Malformed amount \"%s\"", s)
+    }
+    return new_amount(units, decimals)
+}
+
+func new_amount (units *big.Int, decimals int) (Amount, error) {
+    if len(new(big.Int).Abs(units).String()) > MAX_DIGITS {
+        return Amount{}, fmt.Errorf("Amount overflow; %s has more than %d digits", Amount{units:units, decimals:decimals}.String(), MAX_DIGITS)
+    }
+    return Amount{units:units, decimals:decimals}, nil
+}
+
+func pow10 (n int) *big.Int {
+    return new(big.Int).Exp(ten, big.NewInt(int64(n)), nil)
+}
+
+func (a Amount) int () *big.Int {
+    if a.units == nil {
+        return new(big.Int)
+    }
+    return a.units
+}
+
+func (a Amount) Decimals () int {
+    return a.decimals
+}
+
+// Returns the same amount with the given number of decimals, or an error if that would lose precision.
+func (a Amount) Rescale (decimals int) (Amount, error) {
+    if decimals < 0 || decimals > MAX_DECIMALS {
+        return Amount{}, fmt.Errorf("Invalid number of decimals %d; expecting between 0 and %d", decimals, MAX_DECIMALS)
+    }
+    if decimals >= a.decimals {
+        return new_amount(new(big.Int).Mul(a.int(), pow10(decimals - a.decimals)), decimals)
+    }
+    quotient,remainder := new(big.Int).QuoRem(a.int(), pow10(a.decimals - decimals), new(big.Int))
+    if remainder.Sign() != 0 {
+        return Amount{}, fmt.Errorf("Amount %s can't be represented with %d decimals", a.String(), decimals)
+    }
+    return new_amount(quotient, decimals)
+}
+
+// Returns a and b with the larger of their numbers of decimals.
+func common_scale (a Amount, b Amount) (Amount, Amount, error) {
+    decimals := a.decimals
+    if b.decimals > decimals {
+        decimals = b.decimals
+    }
+    a,err := a.Rescale(decimals)
+    if err != nil {
+        return Amount{}, Amount{}, err
+    }
+    b,err = b.Rescale(decimals)
+    if err != nil {
+        return Amount{}, Amount{}, err
+    }
+    return a, b, nil
+}
+
+func (a Amount) Add (b Amount) (Amount, error) {
+    a,b,err := common_scale(a, b)
+    if err != nil {
+        return Amount{}, err
+    }
+    return new_amount(new(big.Int).Add(a.int(), b.int()), a.decimals)
+}
+
+func (a Amount) Sub (b Amount) (Amount, error) {
+    a,b,err := common_scale(a, b)
+    if err != nil {
+        return Amount{}, err
+    }
+    return new_amount(new(big.Int).Sub(a.int(), b.int()), a.decimals)
+}
+
+// Returns a * numerator / denominator with the decimals of a, rounded towards zero, e.g. for computing a
+// percentage.  denominator must be positive.
+func (a Amount) MulDiv (numerator int64, denominator int64) (Amount, error) {
+    if denominator <= 0 {
+        return Amount{}, fmt.Errorf("Invalid denominator %d; expecting positive denominator", denominator)
+    }
+    product := new(big.Int).Mul(a.int(), big.NewInt(numerator))
+    return new_amount(product.Quo(product, big.NewInt(denominator)), a.decimals)
+}
+
+func (a Amount) Neg () Amount {
+    return Amount{units:new(big.Int).Neg(a.int()), decimals:a.decimals}
+}
+
+func (a Amount) Abs () Amount {
+    return Amount{units:new(big.Int).Abs(a.int()), decimals:a.decimals}
+}
+
+// Returns -1, 0 or +1 as a is less than, equal to or greater than b.
+func (a Amount) Cmp (b Amount) int {
+    decimals := a.decimals
+    if b.decimals > decimals {
+        decimals = b.decimals
+    }
+    a_units := new(big.Int).Mul(a.int(), pow10(decimals - a.decimals))
+    b_units := new(big.Int).Mul(b.int(), pow10(decimals - b.decimals))
+    return a_units.Cmp(b_units)
+}
+
+// Returns -1, 0 or +1 as a is negative, zero or positive.
+func (a Amount) Sign () int {
+    return a.int().Sign()
+}
+
+// Formats a with exactly its number of decimals, e.g. "12.50" or "-0.05".
+func (a Amount) String () string {
+    units := a.int()
+    digits := new(big.Int).Abs(units).String()
+    if a.decimals > 0 {
+        if len(digits) <= a.decimals {
+            digits = strings.Repeat("0", a.decimals - len(digits) + 1) + digits
+        }
+        digits = digits[:len(digits)-a.decimals] + "." + digits[len(digits)-a.decimals:]
+    }
+    if units.Sign() < 0 {
+        return "-" + digits
+    }
+    return digits
+}
+
+// An Amount is serialized as a JSON string, e.g. "12.50".
+func (a Amount) MarshalJSON () ([]byte, error) {
+    return json.Marshal(a.String())
+}
+
+// Accepts a JSON string or, for compatibility with integer balances, a JSON number.  The number of decimals is
+// that of the serialized value.
+func (a *Amount) UnmarshalJSON (data []byte) error {
+    s := string(data)
+    if len(data) > 0 && data[0] == '"' {
+        err := json.Unmarshal(data, &s)
+        if err != nil {
+            return err
+        }
+    }
+    amount,err := parse_exact(s)
+    if err != nil {
+        return err
+    }
+    *a = amount
+    return nil
+}
@@ -0,0 +1,104 @@
+package decimal
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+)
+
+func must_parse (t *testing.T, s string, decimals int) Amount {
+    amount, err := Parse(s, decimals)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return amount
+}
+
+func TestParseAndFormat (t *testing.T) {
+    cases := []struct {
+        input       string
+        decimals    int
+        expected    string
+    }{
+        {"0", 0, "0"},
+        {"100", 0, "100"},
+        {"100", 2, "100.00"},
+        {"12.5", 2, "12.50"},
+        {"0.05", 2, "0.05"},
+        {"-0.05", 2, "-0.05"},
+        {"007", 3, "7.000"},
+    }
+    for _,c := range cases {
+        amount := must_parse(t, c.input, c.decimals)
+        if amount.String() != c.expected {
+            t.Errorf("Parse(\"%s\", %d) formatted as \"%s\", expected \"%s\"", c.input, c.decimals, amount.String(), c.expected)
+        }
+    }
+
+    for _,bad := range []string{"", "1.", ".5", "+1", "1e3", " 1", "1,000", "0x10"} {
+        if _, err := Parse(bad, 2); err == nil {
+            t.Errorf("expected Parse(\"%s\", 2) to fail", bad)
+        }
+    }
+    if _, err := Parse("1.234", 2); err == nil || !strings.Contains(err.Error(), "more than 2 decimals") {
+        t.Errorf("expected too many decimals to be rejected, got %v", err)
+    }
+}
+
+func TestArithmeticIsOverflowChecked (t *testing.T) {
+    max := must_parse(t, strings.Repeat("9", MAX_DIGITS - 2) + ".99", 2)
+    if _, err := max.Add(must_parse(t, "0.01", 2)); err == nil || !strings.Contains(err.Error(), "overflow") {
+        t.Fatalf("expected overflow, got %v", err)
+    }
+    if _, err := Parse(strings.Repeat("9", MAX_DIGITS + 1), 0); err == nil {
+        t.Fatal("expected parsing too many digits to fail")
+    }
+
+    sum, err := must_parse(t, "12.50", 2).Add(must_parse(t, "0.5", 1))
+    if err != nil || sum.String() != "13.00" {
+        t.Fatalf("expected 13.00, got %v (error %v)", sum, err)
+    }
+    difference, err := must_parse(t, "1", 0).Sub(must_parse(t, "1.25", 2))
+    if err != nil || difference.String() != "-0.25" || difference.Sign() != -1 {
+        t.Fatalf("expected -0.25, got %v (error %v)", difference, err)
+    }
+    if difference.Neg().String() != "0.25" || difference.Abs().String() != "0.25" {
+        t.Fatalf("expected Neg and Abs of -0.25 to be 0.25, got %v and %v", difference.Neg(), difference.Abs())
+    }
+    fee, err := must_parse(t, "12.34", 2).MulDiv(25, 10000)
+    if err != nil || fee.String() != "0.03" {
+        t.Fatalf("expected 25 basis points of 12.34 to be 0.03, got %v (error %v)", fee, err)
+    }
+    if _, err := must_parse(t, "1", 0).MulDiv(1, 0); err == nil {
+        t.Fatal("expected MulDiv by zero to fail")
+    }
+    if must_parse(t, "1.50", 2).Cmp(must_parse(t, "1.5", 1)) != 0 {
+        t.Fatal("expected 1.50 == 1.5")
+    }
+    if _, err := must_parse(t, "1.25", 2).Rescale(1); err == nil {
+        t.Fatal("expected lossy Rescale to fail")
+    }
+}
+
+func TestJSON (t *testing.T) {
+    var value struct {
+        A   Amount
+        B   Amount
+    }
+    if err := json.Unmarshal([]byte(`{"A":"12.50","B":123}`), &value); err != nil {
+        t.Fatal(err)
+    }
+    if value.A.String() != "12.50" || value.A.Decimals() != 2 || value.B.String() != "123" {
+        t.Fatalf("unexpected amounts %v and %v", value.A, value.B)
+    }
+    bytes, err := json.Marshal(&value)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(bytes) != `{"A":"12.50","B":"123"}` {
+        t.Fatalf("unexpected JSON %s", string(bytes))
+    }
+    if err := json.Unmarshal([]byte(`{"A":"1e3"}`), &value); err == nil {
+        t.Fatal("expected a malformed amount to be rejected")
+    }
+}
@@ -0,0 +1,974 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/events"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+//
+// function registry
+//
+// Every Invoke function is registered in function_registry along with its handler and the schema of its params.
+// Invoke dispatches on the registry both for legacy calls, whose args are positional strings passed to the
+// handler as they are, and for calls of the "request" function, whose single arg is a RequestEnvelope naming a
+// function and giving its params as a JSON object.  The params of a request are validated against the schema of
+// the function and then passed to its handler positionally, and the outcome is returned as a ResponseEnvelope.
+//
+
+type ParamType string
+const (
+    STRING_PARAM    ParamType = "string"
+    // A decimal amount, given as a JSON string or number.
+    AMOUNT_PARAM    ParamType = "amount"
+    // An integer, given as a JSON string or number.
+    INTEGER_PARAM   ParamType = "integer"
+    // A JSON boolean, or a string accepted by strconv.ParseBool.
+    BOOL_PARAM      ParamType = "bool"
+    // An RFC 3339 time such as "2017-01-31T00:00:00Z".
+    TIME_PARAM      ParamType = "time"
+    // A duration accepted by time.ParseDuration, such as "24h".
+    DURATION_PARAM  ParamType = "duration"
+    // Any JSON value, or a string containing one.
+    JSON_PARAM      ParamType = "json"
+)
+
+type ParamSpec struct {
+    Name        string      `json:"Name"`
+    Type        ParamType   `json:"Type"`
+    // An optional param may be omitted, or given as null or an empty string, which is passed to the handler as
+    // an empty string if a later param is given and is not passed at all otherwise.
+    Optional    bool        `json:"Optional"`
+    // A required param which may be given as an empty string (e.g. to denote no limit).  Omitting it is the
+    // same as giving it as an empty string.
+    AllowEmpty  bool        `json:"AllowEmpty"`
+}
+
+type FunctionSpec struct {
+    Name            string
+    Description     string
+    // In the order in which the handler takes them as positional args.
+    Params          []ParamSpec
+    // The permission-checked function (a key of default_function_roles) whose required roles authorize callers,
+    // if any.
+    Permission      string
+    // Who is authorized to call the function, for describe_api.  If Permission is set, then this only names
+    // those authorized besides the admin and the holders of its required roles (see authorization_description),
+    // if any.
+    Authorization   string
+    // Whether the function changes the ledger, as opposed to being a query.
+    Mutates         bool
+    // The event type emitted upon success, if any.
+    Event           string
+    handler         func (t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) pb.Response
+}
+
+func required (name string, param_type ParamType) ParamSpec {
+    return ParamSpec{Name:name, Type:param_type}
+}
+
+func optional (name string, param_type ParamType) ParamSpec {
+    return ParamSpec{Name:name, Type:param_type, Optional:true}
+}
+
+// A required param which may be given as an empty string.
+func emptiable (name string, param_type ParamType) ParamSpec {
+    return ParamSpec{Name:name, Type:param_type, AllowEmpty:true}
+}
+
+var function_registry []FunctionSpec
+var function_registry_index map[string]*FunctionSpec
+
+// The registry is built by init rather than by an initializer expression so that handlers may refer to it.
+func init () {
+    function_registry = []FunctionSpec{
+        {
+            Name:"create_account",
+            Description:"Creates an account with the given name and initial balance.  A nonzero initial balance is minted, so also requires the authorization of mint for the asset.",
+            Params:[]ParamSpec{required("account_holder_name", STRING_PARAM), required("initial_balance", AMOUNT_PARAM), optional("owner_msp_id", STRING_PARAM), optional("owner_cert_pem", STRING_PARAM), optional("asset", STRING_PARAM), optional("metadata", JSON_PARAM)},
+            Permission:"create_account",
+            Mutates:true,
+            Event:events.ACCOUNT_CREATED,
+            handler:(*SimpleChaincode).create_account,
+        },
+        {
+            Name:"delete_account",
+            Description:"Deletes an account.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM), optional("sweep_to_account_name", STRING_PARAM)},
+            Permission:"delete_account",
+            Mutates:true,
+            Event:events.ACCOUNT_DELETED,
+            handler:(*SimpleChaincode).delete_account,
+        },
+        {
+            Name:"batch_transfer",
+            Description:"Transfers between several pairs of accounts, all or nothing.",
+            Params:[]ParamSpec{required("legs", JSON_PARAM)},
+            Permission:"transfer",
+            Authorization:"the holders of the accounts transferred from",
+            Mutates:true,
+            Event:events.BATCH_TRANSFERRED,
+            handler:(*SimpleChaincode).batch_transfer,
+        },
+        {
+            Name:"define_asset",
+            Description:"Defines an asset other than the default asset.",
+            Params:[]ParamSpec{required("symbol", STRING_PARAM), required("decimals", INTEGER_PARAM), required("supply_cap", AMOUNT_PARAM), optional("issuer_msp_id", STRING_PARAM), optional("issuer_cert_pem", STRING_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.ASSET_DEFINED,
+            handler:(*SimpleChaincode).define_asset,
+        },
+        {
+            Name:"query_assets",
+            Description:"Queries all assets other than the default asset.",
+            Permission:"query_assets",
+            handler:(*SimpleChaincode).query_assets,
+        },
+        {
+            Name:"mint",
+            Description:"Creates an amount in an account.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM), required("amount", AMOUNT_PARAM), optional("asset", STRING_PARAM)},
+            Permission:"mint",
+            Authorization:"the issuer of the asset",
+            Mutates:true,
+            Event:events.MINTED,
+            handler:func (t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) pb.Response {
+                return t.change_supply(stub, "mint", args)
+            },
+        },
+        {
+            Name:"burn",
+            Description:"Destroys an amount in an account.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM), required("amount", AMOUNT_PARAM), optional("asset", STRING_PARAM)},
+            Permission:"burn",
+            Authorization:"the issuer of the asset",
+            Mutates:true,
+            Event:events.BURNED,
+            handler:func (t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) pb.Response {
+                return t.change_supply(stub, "burn", args)
+            },
+        },
+        {
+            Name:"query_total_supply",
+            Description:"Queries the total supply of an asset.",
+            Params:[]ParamSpec{optional("asset", STRING_PARAM)},
+            Permission:"query_total_supply",
+            handler:(*SimpleChaincode).query_total_supply,
+        },
+        {
+            Name:"freeze_account",
+            Description:"Prevents the balances of an account from changing.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.ACCOUNT_FROZEN,
+            handler:func (t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) pb.Response {
+                return t.set_account_status(stub, "freeze_account", args)
+            },
+        },
+        {
+            Name:"unfreeze_account",
+            Description:"Allows the balances of a frozen account to change again.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.ACCOUNT_UNFROZEN,
+            handler:func (t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) pb.Response {
+                return t.set_account_status(stub, "unfreeze_account", args)
+            },
+        },
+        {
+            Name:"close_account",
+            Description:"Closes an account permanently, keeping it as a tombstone.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM), optional("sweep_to_account_name", STRING_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.ACCOUNT_CLOSED,
+            handler:(*SimpleChaincode).close_account,
+        },
+        {
+            Name:"transfer",
+            Description:"Transfers an amount from one account to another.",
+            Params:[]ParamSpec{required("from_account_name", STRING_PARAM), required("to_account_name", STRING_PARAM), required("amount", AMOUNT_PARAM), optional("asset", STRING_PARAM), optional("memo", STRING_PARAM), optional("client_ref_id", STRING_PARAM)},
+            Permission:"transfer",
+            Authorization:"the holder of from_account_name",
+            Mutates:true,
+            Event:events.TRANSFERRED,
+            handler:(*SimpleChaincode).transfer,
+        },
+        {
+            Name:"query_transfer_record",
+            Description:"Queries the record of a transfer, including its memo.",
+            Params:[]ParamSpec{required("tx_id", STRING_PARAM)},
+            Permission:"query_balance",
+            Authorization:"the sender and the holders of both accounts",
+            handler:(*SimpleChaincode).query_transfer_record,
+        },
+        {
+            Name:"approve",
+            Description:"Allows a spender to transfer up to an amount from an account.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM), required("spender_msp_id", STRING_PARAM), required("spender_cert_pem", STRING_PARAM), required("amount", AMOUNT_PARAM)},
+            Authorization:"the holder of account_name",
+            Mutates:true,
+            Event:events.APPROVED,
+            handler:(*SimpleChaincode).approve,
+        },
+        {
+            Name:"query_allowance",
+            Description:"Queries the amount a spender may still transfer from an account.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM), required("spender_msp_id", STRING_PARAM), required("spender_cert_pem", STRING_PARAM)},
+            Permission:"query_balance",
+            Authorization:"the holder of account_name and the spender",
+            handler:(*SimpleChaincode).query_allowance,
+        },
+        {
+            Name:"transfer_from",
+            Description:"Transfers an amount from an account as an approved spender.",
+            Params:[]ParamSpec{required("from_account_name", STRING_PARAM), required("to_account_name", STRING_PARAM), required("amount", AMOUNT_PARAM)},
+            Authorization:"spenders approved by the holder of from_account_name",
+            Mutates:true,
+            Event:events.TRANSFERRED,
+            handler:(*SimpleChaincode).transfer_from,
+        },
+        {
+            Name:"create_hold",
+            Description:"Reserves an amount in an account for a later transfer.",
+            Params:[]ParamSpec{required("from_account_name", STRING_PARAM), required("to_account_name", STRING_PARAM), required("amount", AMOUNT_PARAM), required("expiry", TIME_PARAM)},
+            Permission:"transfer",
+            Authorization:"the holder of from_account_name",
+            Mutates:true,
+            Event:events.HOLD_CREATED,
+            handler:(*SimpleChaincode).create_hold,
+        },
+        {
+            Name:"release_hold",
+            Description:"Makes the transfer reserved by a hold.",
+            Params:[]ParamSpec{required("from_account_name", STRING_PARAM), required("hold_id", STRING_PARAM)},
+            Permission:"transfer",
+            Authorization:"the holder of from_account_name",
+            Mutates:true,
+            Event:events.HOLD_RELEASED,
+            handler:(*SimpleChaincode).release_hold,
+        },
+        {
+            Name:"cancel_hold",
+            Description:"Frees the amount reserved by a hold without transferring it.",
+            Params:[]ParamSpec{required("from_account_name", STRING_PARAM), required("hold_id", STRING_PARAM)},
+            Permission:"transfer",
+            Authorization:"the holder of from_account_name",
+            Mutates:true,
+            Event:events.HOLD_CANCELLED,
+            handler:(*SimpleChaincode).cancel_hold,
+        },
+        {
+            Name:"expire_holds",
+            Description:"Frees the amounts reserved by the expired holds on an account.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM)},
+            Authorization:"anyone",
+            Mutates:true,
+            Event:events.HOLDS_EXPIRED,
+            handler:(*SimpleChaincode).expire_holds,
+        },
+        {
+            Name:"query_holds",
+            Description:"Queries the holds on an account.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM)},
+            Permission:"query_balance",
+            Authorization:"the holder of account_name",
+            handler:(*SimpleChaincode).query_holds,
+        },
+        {
+            Name:"set_transfer_limits",
+            Description:"Sets the ledger-wide transfer limits.",
+            Params:[]ParamSpec{emptiable("max_transfer", AMOUNT_PARAM), emptiable("daily_outflow", AMOUNT_PARAM), emptiable("min_balance", AMOUNT_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.TRANSFER_LIMITS_SET,
+            handler:(*SimpleChaincode).set_transfer_limits,
+        },
+        {
+            Name:"set_account_transfer_limits",
+            Description:"Overrides the transfer limits for an account.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM), emptiable("max_transfer", AMOUNT_PARAM), emptiable("daily_outflow", AMOUNT_PARAM), emptiable("min_balance", AMOUNT_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.TRANSFER_LIMITS_SET,
+            handler:(*SimpleChaincode).set_account_transfer_limits,
+        },
+        {
+            Name:"update_account_metadata",
+            Description:"Changes the metadata of an account.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM), required("metadata", JSON_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.ACCOUNT_METADATA_UPDATED,
+            handler:(*SimpleChaincode).update_account_metadata,
+        },
+        {
+            Name:"query_account",
+            Description:"Queries the account, its metadata and its last activity.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM)},
+            Permission:"query_balance",
+            Authorization:"the holder of account_name",
+            handler:(*SimpleChaincode).query_account,
+        },
+        {
+            Name:"set_kyc_transfer_limits",
+            Description:"Sets the transfer limits of accounts with a KYC level, overriding the ledger-wide ones.",
+            Params:[]ParamSpec{required("kyc_level", INTEGER_PARAM), emptiable("max_transfer", AMOUNT_PARAM), emptiable("daily_outflow", AMOUNT_PARAM), emptiable("min_balance", AMOUNT_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.TRANSFER_LIMITS_SET,
+            handler:(*SimpleChaincode).set_kyc_transfer_limits,
+        },
+        {
+            Name:"create_standing_order",
+            Description:"Creates a scheduled or recurring transfer.",
+            Params:[]ParamSpec{required("from_account_name", STRING_PARAM), required("to_account_name", STRING_PARAM), required("amount", AMOUNT_PARAM), required("start", TIME_PARAM), optional("interval", DURATION_PARAM), optional("end", TIME_PARAM)},
+            Permission:"transfer",
+            Authorization:"the holder of from_account_name",
+            Mutates:true,
+            Event:events.STANDING_ORDER_CREATED,
+            handler:(*SimpleChaincode).create_standing_order,
+        },
+        {
+            Name:"cancel_standing_order",
+            Description:"Cancels a standing order.",
+            Params:[]ParamSpec{required("from_account_name", STRING_PARAM), required("order_id", STRING_PARAM)},
+            Permission:"transfer",
+            Authorization:"the holder of from_account_name and the creator of the order",
+            Mutates:true,
+            Event:events.STANDING_ORDER_CANCELLED,
+            handler:(*SimpleChaincode).cancel_standing_order,
+        },
+        {
+            Name:"run_due_transfers",
+            Description:"Makes the standing order transfers that are due.",
+            Permission:"run_due_transfers",
+            Mutates:true,
+            Event:events.DUE_TRANSFERS_RUN,
+            handler:(*SimpleChaincode).run_due_transfers,
+        },
+        {
+            Name:"query_standing_orders",
+            Description:"Queries the standing orders from an account.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM)},
+            Permission:"query_balance",
+            Authorization:"the holder of account_name",
+            handler:(*SimpleChaincode).query_standing_orders,
+        },
+        {
+            Name:"query_standing_order_executions",
+            Description:"Queries the transfers attempted for a standing order.",
+            Params:[]ParamSpec{required("from_account_name", STRING_PARAM), required("order_id", STRING_PARAM)},
+            Permission:"query_balance",
+            Authorization:"the holder of from_account_name",
+            handler:(*SimpleChaincode).query_standing_order_executions,
+        },
+        {
+            Name:"set_fee_schedule",
+            Description:"Sets the transfer fees and the account they are credited to.",
+            Params:[]ParamSpec{required("flat_fee", AMOUNT_PARAM), required("basis_points", INTEGER_PARAM), emptiable("collector_account_name", STRING_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.FEE_SCHEDULE_SET,
+            handler:(*SimpleChaincode).set_fee_schedule,
+        },
+        {
+            Name:"query_fee_schedule",
+            Description:"Queries the transfer fees.",
+            Authorization:"anyone",
+            handler:(*SimpleChaincode).query_fee_schedule,
+        },
+        {
+            Name:"query_transfer_limits",
+            Description:"Queries the ledger-wide transfer limits or those of an account.",
+            Params:[]ParamSpec{optional("account_name", STRING_PARAM)},
+            Permission:"query_balance",
+            Authorization:"the holder of account_name",
+            handler:(*SimpleChaincode).query_transfer_limits,
+        },
+        {
+            Name:"query_balance",
+            Description:"Queries an account balance.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM), optional("asset", STRING_PARAM)},
+            Permission:"query_balance",
+            Authorization:"the holder of account_name",
+            handler:(*SimpleChaincode).query_balance,
+        },
+        {
+            Name:"query_account_names",
+            Description:"Queries all account names, or a page of them if a page_size is given.",
+            Params:[]ParamSpec{optional("page_size", INTEGER_PARAM), optional("bookmark", STRING_PARAM), optional("name_prefix", STRING_PARAM), optional("with_balances", BOOL_PARAM)},
+            Permission:"query_account_names",
+            handler:(*SimpleChaincode).query_account_names,
+        },
+        {
+            Name:"query_accounts_by_index",
+            Description:"Queries the accounts having a given key in an account index, i.e. a given Status or owner OwnerMspID, or a page of them if a page_size is given.",
+            Params:[]ParamSpec{required("index_name", STRING_PARAM), required("key", STRING_PARAM), optional("page_size", INTEGER_PARAM), optional("bookmark", STRING_PARAM)},
+            Permission:"query_account_names",
+            handler:(*SimpleChaincode).query_accounts_by_index,
+        },
+        {
+            Name:"check_account_index",
+            Description:"Checks that an account index has one entry for each account, and optionally rebuilds it.",
+            Params:[]ParamSpec{required("index_name", STRING_PARAM), required("repair", BOOL_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.INDEX_REPAIRED,
+            handler:(*SimpleChaincode).check_account_index,
+        },
+        {
+            Name:"query_account_history",
+            Description:"Queries the history of an account balance.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM), optional("page_size", INTEGER_PARAM), optional("bookmark", STRING_PARAM)},
+            Permission:"query_balance",
+            Authorization:"the holder of account_name",
+            handler:(*SimpleChaincode).query_account_history,
+        },
+        {
+            Name:"set_account_owner",
+            Description:"Binds an account to the identity of its holder.",
+            Params:[]ParamSpec{required("account_name", STRING_PARAM), required("owner_msp_id", STRING_PARAM), required("owner_cert_pem", STRING_PARAM)},
+            Permission:"set_account_owner",
+            Mutates:true,
+            Event:events.ACCOUNT_OWNER_SET,
+            handler:(*SimpleChaincode).set_account_owner,
+        },
+        {
+            Name:"grant_role",
+            Description:"Grants a role to a user.",
+            Params:[]ParamSpec{required("role", STRING_PARAM), required("msp_id", STRING_PARAM), required("cert_pem", STRING_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.ROLE_GRANTED,
+            handler:(*SimpleChaincode).grant_role,
+        },
+        {
+            Name:"revoke_role",
+            Description:"Revokes a role from a user.",
+            Params:[]ParamSpec{required("role", STRING_PARAM), required("msp_id", STRING_PARAM), required("cert_pem", STRING_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.ROLE_REVOKED,
+            handler:(*SimpleChaincode).revoke_role,
+        },
+        {
+            Name:"set_function_roles",
+            Description:"Sets the roles which are authorized to call a function.",
+            Params:[]ParamSpec{required("function", STRING_PARAM), required("roles", JSON_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.FUNCTION_ROLES_SET,
+            handler:(*SimpleChaincode).set_function_roles,
+        },
+        {
+            Name:"query_role_grants",
+            Description:"Queries which users have been granted which roles.",
+            Params:[]ParamSpec{optional("role", STRING_PARAM)},
+            Permission:"query_role_grants",
+            handler:(*SimpleChaincode).query_role_grants,
+        },
+        {
+            Name:"query_function_roles",
+            Description:"Queries the roles which are authorized to call each function.",
+            Permission:"query_function_roles",
+            handler:(*SimpleChaincode).query_function_roles,
+        },
+        {
+            Name:"propose_admin_change",
+            Description:"Proposes a new admin user.",
+            Params:[]ParamSpec{required("new_admin_msp_id", STRING_PARAM), required("new_admin_cert_pem", STRING_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.ADMIN_CHANGE_PROPOSED,
+            handler:(*SimpleChaincode).propose_admin_change,
+        },
+        {
+            Name:"approve_admin_change",
+            Description:"Approves a proposed admin change as a member of the admin council.",
+            Params:[]ParamSpec{required("proposal_id", STRING_PARAM)},
+            Authorization:"the members of the admin council",
+            Mutates:true,
+            Event:events.ADMIN_CHANGE_APPROVED,
+            handler:(*SimpleChaincode).approve_admin_change,
+        },
+        {
+            Name:"accept_admin_change",
+            Description:"Completes an admin change as the proposed new admin user.",
+            Params:[]ParamSpec{required("proposal_id", STRING_PARAM)},
+            Authorization:"the proposed new admin user",
+            Mutates:true,
+            Event:events.ADMIN_CHANGED,
+            handler:(*SimpleChaincode).accept_admin_change,
+        },
+        {
+            Name:"cancel_admin_change",
+            Description:"Withdraws a proposed admin change.",
+            Params:[]ParamSpec{required("proposal_id", STRING_PARAM)},
+            Authorization:"the admin and the proposer",
+            Mutates:true,
+            Event:events.ADMIN_CHANGE_CANCELLED,
+            handler:(*SimpleChaincode).cancel_admin_change,
+        },
+        {
+            Name:"set_admin_council",
+            Description:"Sets the admin council which must approve admin changes.",
+            Params:[]ParamSpec{required("threshold", INTEGER_PARAM), required("members", JSON_PARAM)},
+            Authorization:"the admin",
+            Mutates:true,
+            Event:events.ADMIN_COUNCIL_CHANGED,
+            handler:(*SimpleChaincode).set_admin_council,
+        },
+        {
+            Name:"query_admin",
+            Description:"Queries the admin user, admin council and pending admin change.",
+            Permission:"query_admin",
+            handler:(*SimpleChaincode).query_admin,
+        },
+        {
+            Name:"run_migrations",
+            Description:"Migrates the next batch of ledger data towards the current schema version.",
+            Params:[]ParamSpec{optional("max_rows", INTEGER_PARAM)},
+            Permission:"run_migrations",
+            Mutates:true,
+            Event:events.SCHEMA_MIGRATED,
+            handler:(*SimpleChaincode).run_migrations,
+        },
+        {
+            Name:"query_schema_version",
+            Description:"Queries the schema version of the ledger data and the migrations pending.",
+            Authorization:"anyone",
+            handler:(*SimpleChaincode).query_schema_version,
+        },
+        {
+            Name:"describe_api",
+            Description:"Describes every function, its params and who may call it.",
+            Authorization:"anyone",
+            handler:(*SimpleChaincode).describe_api,
+        },
+        {
+            Name:"query_transactor_identity",
+            Description:"Queries the identity of the transactor, as used in authorization checks.",
+            Authorization:"anyone",
+            handler:(*SimpleChaincode).query_transactor_identity,
+        },
+    }
+    function_registry_index = make(map[string]*FunctionSpec)
+    for i := range function_registry {
+        function_registry_index[function_registry[i].Name] = &function_registry[i]
+    }
+}
+
+// Functions which change the ledger can't be called while it is being migrated, other than run_migrations.
+func (spec *FunctionSpec) check_schema_is_current (stub shim.ChaincodeStubInterface) error {
+    if !spec.Mutates || spec.Name == "run_migrations" {
+        return nil
+    }
+    return check_schema_is_current(stub)
+}
+
+// Returns nil if there is no such function.
+func get_function_spec (function string) *FunctionSpec {
+    return function_registry_index[function]
+}
+
+func unknown_function_message (function string) string {
+    var names []string
+    for i := range function_registry {
+        names = append(names, fmt.Sprintf("'%s'", function_registry[i].Name))
+    }
+    sort.Strings(names)
+    return fmt.Sprintf("Unknown action '%s', check the first argument, must be one of %s, or '%s' with a request envelope", function, strings.Join(names, ", "), REQUEST_FUNCTION)
+}
+
+//
+// request and response envelopes
+//
+
+const REQUEST_FUNCTION = "request"
+const REQUEST_ENVELOPE_VERSION = 1
+
+var amount_param_regexp = regexp.MustCompile("^-?[0-9]+(\\.[0-9]+)?$")
+var integer_param_regexp = regexp.MustCompile("^-?[0-9]+$")
+
+// The single arg of the "request" function.  Example:
+//
+//     {"Version": 1, "Function": "transfer", "Params": {"from_account_name": "Alice", "to_account_name": "Bob", "amount": "10"}, "ClientRequestID": "req-1"}
+type RequestEnvelope struct {
+    Version         int                         `json:"Version"`
+    Function        string                      `json:"Function"`
+    Params          map[string]json.RawMessage  `json:"Params"`
+    // Echoed in the response, so that a client can match responses to requests.  Optional.
+    ClientRequestID string                      `json:"ClientRequestID,omitempty"`
+}
+
+// Stable, machine-readable codes for the ways in which a request can fail.
+type ErrorCode string
+const (
+    // The request envelope is malformed or has an unsupported version.
+    INVALID_REQUEST     ErrorCode = "INVALID_REQUEST"
+    UNKNOWN_FUNCTION    ErrorCode = "UNKNOWN_FUNCTION"
+    // The params don't match the function's schema, or the handler rejected them.
+    INVALID_PARAMS      ErrorCode = "INVALID_PARAMS"
+    // The transactor's identity could not be determined (see IdentityError).
+    INVALID_IDENTITY    ErrorCode = "INVALID_IDENTITY"
+    UNAUTHORIZED        ErrorCode = "UNAUTHORIZED"
+    NOT_FOUND           ErrorCode = "NOT_FOUND"
+    // The ledger data is being migrated (see run_migrations), so the function can't be called yet.
+    MIGRATION_PENDING   ErrorCode = "MIGRATION_PENDING"
+    // The available balance of the "from" account doesn't cover the transfer (and its fee).
+    INSUFFICIENT_BALANCE    ErrorCode = "INSUFFICIENT_BALANCE"
+    // An account involved is frozen or closed.
+    ACCOUNT_NOT_ACTIVE  ErrorCode = "ACCOUNT_NOT_ACTIVE"
+    // The transfer would exceed a transfer limit of the "from" account (see set_transfer_limits).
+    TRANSFER_LIMIT_EXCEEDED ErrorCode = "TRANSFER_LIMIT_EXCEEDED"
+    // The sender has already used the client_ref_id of the transfer.
+    DUPLICATE_CLIENT_REF_ID ErrorCode = "DUPLICATE_CLIENT_REF_ID"
+    // Any other failure of the handler.
+    CHAINCODE_ERROR     ErrorCode = "CHAINCODE_ERROR"
+)
+
+// An error carrying an ErrorCode.  The message starts with the code in brackets (see coded_error), so that
+// clients calling functions directly can match on it.  The code itself is carried out of band, since the message
+// may include arbitrary text such as account names: wrap_error keeps it when callers wrap the error, and
+// error_response puts it in the failed response, for invoke_request to report as the ErrorCode of the response
+// envelope.
+type CodedError struct {
+    Code    ErrorCode
+    Message string
+}
+
+func (e *CodedError) Error () string {
+    return e.Message
+}
+
+func coded_error (code ErrorCode, format string, args ...interface{}) *CodedError {
+    return &CodedError{Code:code, Message:fmt.Sprintf("[%s] %s", code, fmt.Sprintf(format, args...))}
+}
+
+// Like fmt.Errorf, for an error which wraps err, but carrying the code of err if it is a *CodedError.
+func wrap_error (err error, format string, args ...interface{}) error {
+    if coded,ok := err.(*CodedError); ok {
+        return &CodedError{Code:coded.Code, Message:fmt.Sprintf(format, args...)}
+    }
+    return fmt.Errorf(format, args...)
+}
+
+// Returns the code of err if it is a *CodedError, or CHAINCODE_ERROR otherwise.
+func error_code_of (err error) ErrorCode {
+    if coded,ok := err.(*CodedError); ok {
+        return coded.Code
+    }
+    return CHAINCODE_ERROR
+}
+
+// Returns a failed response with the message of err, and the code of err (see error_code_of) as its payload.
+func error_response (err error) pb.Response {
+    return pb.Response{Status:shim.ERROR, Message:err.Error(), Payload:[]byte(error_code_of(err))}
+}
+
+func coded_error_response (code ErrorCode, format string, args ...interface{}) pb.Response {
+    return error_response(coded_error(code, format, args...))
+}
+
+// The payload of a successful request, or the message of a failed one.
+type ResponseEnvelope struct {
+    Version         int             `json:"Version"`
+    ClientRequestID string          `json:"ClientRequestID,omitempty"`
+    // shim.OK or shim.ERROR, as in the pb.Response.
+    Status          int32           `json:"Status"`
+    ErrorCode       ErrorCode       `json:"ErrorCode,omitempty"`
+    Message         string          `json:"Message,omitempty"`
+    // The payload of the handler; a JSON payload is included as it is, and any other as a JSON string.
+    Data            json.RawMessage `json:"Data,omitempty"`
+}
+
+// Failed requests are returned with status shim.ERROR, so that their writes are not committed.
+func (envelope *ResponseEnvelope) response () pb.Response {
+    bytes,err := json.Marshal(envelope)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing response envelope failed because json.Marshal failed with error %v", err))
+    }
+    if envelope.Status != shim.OK {
+        return pb.Response{Status:shim.ERROR, Message:string(bytes)}
+    }
+    return shim.Success(bytes)
+}
+
+func request_error (client_request_id string, code ErrorCode, format string, args ...interface{}) pb.Response {
+    envelope := &ResponseEnvelope{Version:REQUEST_ENVELOPE_VERSION, ClientRequestID:client_request_id, Status:shim.ERROR, ErrorCode:code, Message:fmt.Sprintf(format, args...)}
+    return envelope.response()
+}
+
+// Converts the JSON value of a param to the string passed to the handler, checking that it has the param's type.
+func param_arg (param *ParamSpec, value json.RawMessage) (string, error) {
+    var s string
+    is_string := len(value) > 0 && value[0] == '"'
+    if is_string {
+        if err := json.Unmarshal(value, &s); err != nil {
+            return "", err
+        }
+    }
+    if is_string && s == "" {
+        return "", nil
+    }
+    switch param.Type {
+    case STRING_PARAM:
+        if !is_string {
+            return "", fmt.Errorf("expecting a string")
+        }
+    case AMOUNT_PARAM:
+        if !is_string {
+            s = string(value)
+        }
+        if !amount_param_regexp.MatchString(s) {
+            return "", fmt.Errorf("expecting a decimal amount such as \"12.34\"")
+        }
+    case INTEGER_PARAM:
+        if !is_string {
+            s = string(value)
+        }
+        if !integer_param_regexp.MatchString(s) {
+            return "", fmt.Errorf("expecting an integer")
+        }
+    case BOOL_PARAM:
+        if !is_string {
+            s = string(value)
+        }
+        if _,err := strconv.ParseBool(s); err != nil {
+            return "", fmt.Errorf("expecting true or false")
+        }
+    case TIME_PARAM:
+        if !is_string {
+            return "", fmt.Errorf("expecting an RFC 3339 time such as \"2017-01-31T00:00:00Z\"")
+        }
+        if _,err := time.Parse(time.RFC3339, s); err != nil {
+            return "", fmt.Errorf("expecting an RFC 3339 time such as \"2017-01-31T00:00:00Z\"")
+        }
+    case DURATION_PARAM:
+        if !is_string {
+            return "", fmt.Errorf("expecting a duration such as \"24h\"")
+        }
+        if _,err := time.ParseDuration(s); err != nil {
+            return "", fmt.Errorf("expecting a duration such as \"24h\"")
+        }
+    case JSON_PARAM:
+        if !is_string {
+            s = string(value)
+        }
+        var v interface{}
+        if err := json.Unmarshal([]byte(s), &v); err != nil {
+            return "", fmt.Errorf("expecting JSON; json.Unmarshal failed with error %v", err)
+        }
+    default:
+        return "", fmt.Errorf("unknown param type \"%s\"", param.Type)
+    }
+    return s, nil
+}
+
+// Validates the params of a request against the schema of its function, and returns the positional args to pass
+// to its handler.
+func request_args (spec *FunctionSpec, params map[string]json.RawMessage) ([]string, error) {
+    for name := range params {
+        found := false
+        for i := range spec.Params {
+            if spec.Params[i].Name == name {
+                found = true
+                break
+            }
+        }
+        if !found {
+            return nil, fmt.Errorf("Unknown param \"%s\" for function \"%s\"", name, spec.Name)
+        }
+    }
+
+    args := make([]string, len(spec.Params))
+    arg_count := 0
+    for i := range spec.Params {
+        param := &spec.Params[i]
+        value,is_given := params[param.Name]
+        if is_given && string(value) == "null" {
+            is_given = false
+        }
+        if is_given {
+            arg,err := param_arg(param, value)
+            if err != nil {
+                return nil, wrap_error(err, "Invalid param \"%s\" for function \"%s\"; %v", param.Name, spec.Name, err.Error())
+            }
+            args[i] = arg
+            is_given = arg != ""
+        }
+        if !is_given && !param.Optional && !param.AllowEmpty {
+            return nil, fmt.Errorf("Missing param \"%s\" for function \"%s\"", param.Name, spec.Name)
+        }
+        if is_given || !param.Optional {
+            arg_count = i+1
+        }
+    }
+    return args[:arg_count], nil
+}
+
+// Handles a call of the "request" function, whose single arg is a RequestEnvelope, returning a ResponseEnvelope.
+func (t *SimpleChaincode) invoke_request (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return request_error("", INVALID_REQUEST, "Incorrect number of arguments. Expecting 1; a JSON request envelope")
+    }
+    var request RequestEnvelope
+    err := json.Unmarshal([]byte(args[0]), &request)
+    if err != nil {
+        return request_error("", INVALID_REQUEST, "Malformed request envelope; json.Unmarshal failed with error %v", err)
+    }
+    if request.ClientRequestID != "" && !client_ref_id_regexp.MatchString(request.ClientRequestID) {
+        return request_error("", INVALID_REQUEST, "Invalid ClientRequestID \"%s\"; must be 1 to 64 letters, digits, '.', '_', ':' and '-'", request.ClientRequestID)
+    }
+    if request.Version != REQUEST_ENVELOPE_VERSION {
+        return request_error(request.ClientRequestID, INVALID_REQUEST, "Unsupported request envelope Version %d; expecting %d", request.Version, REQUEST_ENVELOPE_VERSION)
+    }
+
+    _,err = GetTransactorIdentity(stub)
+    if err != nil {
+        return request_error(request.ClientRequestID, INVALID_IDENTITY, "%v", err.Error())
+    }
+
+    spec := get_function_spec(request.Function)
+    if spec == nil {
+        return request_error(request.ClientRequestID, UNKNOWN_FUNCTION, "%s", unknown_function_message(request.Function))
+    }
+    handler_args,err := request_args(spec, request.Params)
+    if err != nil {
+        return request_error(request.ClientRequestID, INVALID_PARAMS, "%v", err.Error())
+    }
+    if err = spec.check_schema_is_current(stub); err != nil {
+        return request_error(request.ClientRequestID, MIGRATION_PENDING, "%v", err.Error())
+    }
+
+    response := spec.handler(t, stub, handler_args)
+    if response.Status != shim.OK {
+        // Failures not made by error_response have no code.
+        code := CHAINCODE_ERROR
+        if len(response.Payload) > 0 {
+            code = ErrorCode(response.Payload)
+        }
+        return request_error(request.ClientRequestID, code, "%s", response.Message)
+    }
+    envelope := &ResponseEnvelope{Version:REQUEST_ENVELOPE_VERSION, ClientRequestID:request.ClientRequestID, Status:shim.OK}
+    if len(response.Payload) > 0 {
+        var v interface{}
+        if json.Unmarshal(response.Payload, &v) == nil {
+            envelope.Data = json.RawMessage(response.Payload)
+        } else {
+            envelope.Data,err = json.Marshal(string(response.Payload))
+            if err != nil {
+                return shim.Error(fmt.Sprintf("Serializing payload failed because json.Marshal failed with error %v", err))
+            }
+        }
+    }
+    return envelope.response()
+}
+
+//
+// API description
+//
+
+// The description of a function given by describe_api.
+type FunctionDescription struct {
+    Name            string      `json:"Name"`
+    Description     string      `json:"Description"`
+    Params          []ParamSpec `json:"Params"`
+    Permission      string      `json:"Permission,omitempty"`
+    // The roles currently required by Permission (see set_function_roles).
+    RequiredRoles   []string    `json:"RequiredRoles"`
+    Authorization   string      `json:"Authorization"`
+    Mutates         bool        `json:"Mutates"`
+    Event           string      `json:"Event,omitempty"`
+}
+
+// The payload of describe_api.
+type APIDescription struct {
+    // The Version of RequestEnvelope and ResponseEnvelope.
+    RequestEnvelopeVersion  int                     `json:"RequestEnvelopeVersion"`
+    // The SchemaVersion of the event envelopes.
+    EventSchemaVersion      int                     `json:"EventSchemaVersion"`
+    Functions               []FunctionDescription   `json:"Functions"`
+}
+
+// Describes who is authorized to call the function, given the roles currently required by its Permission.
+func authorization_description (spec *FunctionSpec, required_roles []string) string {
+    if spec.Permission == "" {
+        return spec.Authorization
+    }
+    authorized := []string{"the admin"}
+    if len(required_roles) > 0 {
+        authorized = append(authorized, fmt.Sprintf("anyone with one of the roles %v", required_roles))
+    }
+    if spec.Authorization != "" {
+        authorized = append(authorized, spec.Authorization)
+    }
+    if len(authorized) == 1 {
+        return authorized[0]
+    }
+    if len(authorized) == 2 {
+        return authorized[0] + " and " + authorized[1]
+    }
+    return strings.Join(authorized[:len(authorized)-1], ", ") + ", and " + authorized[len(authorized)-1]
+}
+
+// Raw form of function which does no permissions checking.  The required roles are read from the ledger, so
+// that the description reflects set_function_roles.
+func describe_api_ (stub shim.ChaincodeStubInterface) (*APIDescription, error) {
+    description := &APIDescription{RequestEnvelopeVersion:REQUEST_ENVELOPE_VERSION, EventSchemaVersion:events.SCHEMA_VERSION, Functions:[]FunctionDescription{}}
+    for i := range function_registry {
+        spec := &function_registry[i]
+        function_description := FunctionDescription{
+            Name:           spec.Name,
+            Description:    spec.Description,
+            Params:         spec.Params,
+            Permission:     spec.Permission,
+            RequiredRoles:  []string{},
+            Mutates:        spec.Mutates,
+            Event:          spec.Event,
+        }
+        if function_description.Params == nil {
+            function_description.Params = []ParamSpec{}
+        }
+        if spec.Permission != "" {
+            roles,err := get_function_roles_(stub, spec.Permission)
+            if err != nil {
+                return nil, err
+            }
+            function_description.RequiredRoles = roles
+        }
+        function_description.Authorization = authorization_description(spec, function_description.RequiredRoles)
+        description.Functions = append(description.Functions, function_description)
+    }
+    return description, nil
+}
+
+// Describes every function that can be invoked, as generated from function_registry and the roles currently
+// required.  Anyone may call this, since it only describes the chaincode itself.
+func (t *SimpleChaincode) describe_api (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 arguments, got %v", args)
+    }
+
+    description,err := describe_api_(stub)
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(description)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing API description failed in describe_api because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
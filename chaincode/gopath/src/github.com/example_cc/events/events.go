@@ -0,0 +1,392 @@
+// Package events defines the chaincode events emitted by example_cc.  Every state-changing function sets
+// exactly one chaincode event, whose name is the event Type and whose payload is a JSON-serialized Envelope.
+// Off-chain consumers should use Decode, which checks the schema version and decodes the type-specific Data.
+package events
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+)
+
+// The version of the Envelope schema and of the Data types below.  This must be incremented whenever a change
+// is made that existing consumers could misinterpret.
+//
+// Version 2: balances and amounts are decimal strings (e.g. "12.50") instead of integers.
+const SCHEMA_VERSION = 2
+
+// Event types, which are also the chaincode event names.
+const (
+    ACCOUNT_CREATED         = "AccountCreated"
+    ACCOUNT_DELETED         = "AccountDeleted"
+    ACCOUNT_FROZEN          = "AccountFrozen"
+    ACCOUNT_UNFROZEN        = "AccountUnfrozen"
+    ACCOUNT_CLOSED          = "AccountClosed"
+    ACCOUNT_METADATA_UPDATED = "AccountMetadataUpdated"
+    ACCOUNT_OWNER_SET       = "AccountOwnerSet"
+    TRANSFERRED             = "Transferred"
+    BATCH_TRANSFERRED       = "BatchTransferred"
+    ASSET_DEFINED           = "AssetDefined"
+    MINTED                  = "Minted"
+    BURNED                  = "Burned"
+    APPROVED                = "Approved"
+    HOLD_CREATED            = "HoldCreated"
+    HOLD_RELEASED           = "HoldReleased"
+    HOLD_CANCELLED          = "HoldCancelled"
+    HOLDS_EXPIRED           = "HoldsExpired"
+    TRANSFER_LIMITS_SET     = "TransferLimitsSet"
+    FEE_SCHEDULE_SET        = "FeeScheduleSet"
+    STANDING_ORDER_CREATED  = "StandingOrderCreated"
+    STANDING_ORDER_CANCELLED = "StandingOrderCancelled"
+    DUE_TRANSFERS_RUN       = "DueTransfersRun"
+    ADMIN_CHANGE_PROPOSED   = "AdminChangeProposed"
+    ADMIN_CHANGE_APPROVED   = "AdminChangeApproved"
+    ADMIN_CHANGE_CANCELLED  = "AdminChangeCancelled"
+    ADMIN_CHANGED           = "AdminChanged"
+    ADMIN_COUNCIL_CHANGED   = "AdminCouncilChanged"
+    ROLE_GRANTED            = "RoleGranted"
+    ROLE_REVOKED            = "RoleRevoked"
+    FUNCTION_ROLES_SET      = "FunctionRolesSet"
+    SCHEMA_MIGRATED         = "SchemaMigrated"
+    INDEX_REPAIRED          = "IndexRepaired"
+)
+
+// This has the same fields as the chaincode's Identity, so the two are convertible.
+type Identity struct {
+    MspID   string  `json:"MspID"`
+    Subject string  `json:"Subject"`
+    Issuer  string  `json:"Issuer"`
+}
+
+type Envelope struct {
+    SchemaVersion   int             `json:"SchemaVersion"`
+    Type            string          `json:"Type"`
+    TxID            string          `json:"TxID"`
+    // The transaction timestamp, as given by the transaction proposal.
+    Timestamp       time.Time       `json:"Timestamp"`
+    Transactor      Identity        `json:"Transactor"`
+    // The JSON-serialized data type corresponding to Type.
+    Data            json.RawMessage `json:"Data"`
+}
+
+//
+// Data types
+//
+
+// In the data types below, an empty Asset denotes the default asset.
+
+type AccountCreated struct {
+    Account         string      `json:"Account"`
+    // The asset of InitialBalance.
+    Asset           string      `json:"Asset,omitempty"`
+    // A decimal string, as are all amounts below.
+    InitialBalance  string      `json:"InitialBalance"`
+    // Nil if the account was created without a bound owner.
+    Owner           *Identity   `json:"Owner"`
+    // Nil if the account was created without metadata.
+    Metadata        *AccountMetadata `json:"Metadata,omitempty"`
+}
+
+// Data for ACCOUNT_METADATA_UPDATED, which has the complete metadata after the update.
+type AccountMetadata struct {
+    Account         string      `json:"Account"`
+    DisplayName     string      `json:"DisplayName"`
+    OrgMspID        string      `json:"OrgMspID"`
+    KYCLevel        int         `json:"KYCLevel"`
+    Attributes      map[string]string `json:"Attributes"`
+}
+
+// Data for ACCOUNT_DELETED and ACCOUNT_CLOSED.
+type AccountDeleted struct {
+    Account         string      `json:"Account"`
+    // The balance that the account held when it was deleted.
+    FinalBalance    string      `json:"FinalBalance"`
+    // The balances that the account held in assets other than the default asset, by asset symbol.
+    FinalAssetBalances  map[string]string   `json:"FinalAssetBalances,omitempty"`
+    // The account into which the final balances were moved, or empty if they were all zero.
+    SweptTo         string      `json:"SweptTo,omitempty"`
+}
+
+// Data for ACCOUNT_FROZEN and ACCOUNT_UNFROZEN.
+type AccountStatusChanged struct {
+    Account         string      `json:"Account"`
+    // The status afterward.
+    Status          string      `json:"Status"`
+}
+
+type AccountOwnerSet struct {
+    Account         string      `json:"Account"`
+    // Nil if the account had no bound owner.
+    OldOwner        *Identity   `json:"OldOwner"`
+    Owner           Identity    `json:"Owner"`
+}
+
+type Transferred struct {
+    FromAccount     string      `json:"FromAccount"`
+    ToAccount       string      `json:"ToAccount"`
+    Asset           string      `json:"Asset,omitempty"`
+    Amount          string      `json:"Amount"`
+    // The spender who made the transfer using their allowance (see APPROVED), or nil if it wasn't made by
+    // transfer_from.
+    Spender         *Identity   `json:"Spender,omitempty"`
+    // The memo and client reference ID given by the sender, if any.
+    Memo            string      `json:"Memo,omitempty"`
+    ClientRefID     string      `json:"ClientRefID,omitempty"`
+    // The fee paid by FromAccount on top of Amount and the account it was credited to, if the transfer wasn't
+    // free.
+    Fee             string      `json:"Fee,omitempty"`
+    FeeCollector    string      `json:"FeeCollector,omitempty"`
+}
+
+// The transfers of a batch_transfer, in the order applied.
+type BatchTransferred struct {
+    Transfers       []Transferred   `json:"Transfers"`
+}
+
+type AssetDefined struct {
+    Symbol          string      `json:"Symbol"`
+    Decimals        int         `json:"Decimals"`
+    // Nil if the asset has no issuer.
+    Issuer          *Identity   `json:"Issuer"`
+    // Empty if the supply is uncapped.
+    SupplyCap       string      `json:"SupplyCap,omitempty"`
+}
+
+// Data for MINTED and BURNED.
+type SupplyChanged struct {
+    Account         string      `json:"Account"`
+    Asset           string      `json:"Asset,omitempty"`
+    Amount          string      `json:"Amount"`
+    // The total supply of the asset afterward.
+    Supply          string      `json:"Supply"`
+}
+
+// The allowance of Spender for the default asset of Account, replacing any previous allowance.
+type Approved struct {
+    Account         string      `json:"Account"`
+    Spender         Identity    `json:"Spender"`
+    Amount          string      `json:"Amount"`
+}
+
+// Data for HOLD_CREATED, HOLD_RELEASED and HOLD_CANCELLED.  Holds are of the default asset.
+type Hold struct {
+    // The ID of the create_hold transaction.
+    HoldID          string      `json:"HoldID"`
+    FromAccount     string      `json:"FromAccount"`
+    ToAccount       string      `json:"ToAccount"`
+    Amount          string      `json:"Amount"`
+    Expiry          time.Time   `json:"Expiry"`
+    // Only for HOLD_RELEASED: the fee paid by FromAccount on top of Amount and the account it was credited to, if
+    // the transfer wasn't free.
+    Fee             string      `json:"Fee,omitempty"`
+    FeeCollector    string      `json:"FeeCollector,omitempty"`
+}
+
+type HoldsExpired struct {
+    Holds           []Hold      `json:"Holds"`
+}
+
+// The ledger-wide transfer limits or, if Account is nonempty or KYCLevel is non-nil, the limits overriding them
+// for Account or for accounts with KYCLevel.  An empty limit is no limit, or for overrides, no override.
+type TransferLimitsSet struct {
+    Account         string      `json:"Account,omitempty"`
+    KYCLevel        *int        `json:"KYCLevel,omitempty"`
+    MaxTransfer     string      `json:"MaxTransfer"`
+    DailyOutflow    string      `json:"DailyOutflow"`
+    MinBalance      string      `json:"MinBalance"`
+}
+
+type FeeScheduleSet struct {
+    FlatFee         string      `json:"FlatFee"`
+    BasisPoints     int64       `json:"BasisPoints"`
+    // Empty if there are no fees.
+    Collector       string      `json:"Collector"`
+}
+
+// Data for STANDING_ORDER_CREATED and STANDING_ORDER_CANCELLED.  Standing orders are of the default asset.
+type StandingOrder struct {
+    // The ID of the create_standing_order transaction.
+    OrderID         string      `json:"OrderID"`
+    FromAccount     string      `json:"FromAccount"`
+    ToAccount       string      `json:"ToAccount"`
+    Amount          string      `json:"Amount"`
+    Start           time.Time   `json:"Start"`
+    // A Go duration (e.g. "24h"), or empty if the order makes a single transfer.
+    Interval        string      `json:"Interval,omitempty"`
+    // Nil if the order doesn't end.
+    End             *time.Time  `json:"End,omitempty"`
+}
+
+// One transfer attempted by run_due_transfers for a standing order.
+type StandingOrderExecution struct {
+    OrderID         string      `json:"OrderID"`
+    // 1 for the first transfer of the order, and so on.
+    Sequence        int         `json:"Sequence"`
+    Due             time.Time   `json:"Due"`
+    FromAccount     string      `json:"FromAccount"`
+    ToAccount       string      `json:"ToAccount"`
+    Amount          string      `json:"Amount"`
+    Fee             string      `json:"Fee,omitempty"`
+    FeeCollector    string      `json:"FeeCollector,omitempty"`
+    // Why the transfer failed, or empty if it was made.
+    Error           string      `json:"Error,omitempty"`
+}
+
+type DueTransfersRun struct {
+    Executions      []StandingOrderExecution    `json:"Executions"`
+}
+
+// Data for ADMIN_CHANGE_PROPOSED, ADMIN_CHANGE_APPROVED and ADMIN_CHANGE_CANCELLED.
+type AdminChangeProposal struct {
+    ProposalID      string      `json:"ProposalID"`
+    NewAdmin        Identity    `json:"NewAdmin"`
+    ProposedBy      Identity    `json:"ProposedBy"`
+    Approvals       []Identity  `json:"Approvals"`
+}
+
+type AdminChanged struct {
+    ProposalID      string      `json:"ProposalID"`
+    OldAdmin        Identity    `json:"OldAdmin"`
+    NewAdmin        Identity    `json:"NewAdmin"`
+}
+
+type AdminCouncilChanged struct {
+    Members         []Identity  `json:"Members"`
+    Threshold       int         `json:"Threshold"`
+}
+
+// Data for ROLE_GRANTED and ROLE_REVOKED.
+type RoleGrant struct {
+    Role            string      `json:"Role"`
+    Identity        Identity    `json:"Identity"`
+}
+
+// The roles of which a transactor must hold at least one in order to call Function; empty if only the admin may.
+type FunctionRolesSet struct {
+    Function        string      `json:"Function"`
+    Roles           []string    `json:"Roles"`
+}
+
+type SchemaMigrated struct {
+    // The schema version of the ledger data afterward.
+    Version         int         `json:"Version"`
+    // The schema version expected by the chaincode, which the ledger data is being migrated to.
+    CurrentVersion  int         `json:"CurrentVersion"`
+    // The number of rows migrated by the transaction.
+    RowsMigrated    int         `json:"RowsMigrated"`
+}
+
+type IndexRepaired struct {
+    Table           string      `json:"Table"`
+    Index           string      `json:"Index"`
+    // The number of entries put for rows which had none.
+    MissingEntries  int         `json:"MissingEntries"`
+    // The number of entries deleted because their rows were gone or had different index keys.
+    StaleEntries    int         `json:"StaleEntries"`
+}
+
+// Returns a pointer to a new zero value of the data type of the given event type, or nil if it is unknown.
+func new_data (event_type string) interface{} {
+    switch event_type {
+    case ACCOUNT_CREATED:
+        return &AccountCreated{}
+    case ACCOUNT_DELETED, ACCOUNT_CLOSED:
+        return &AccountDeleted{}
+    case ACCOUNT_FROZEN, ACCOUNT_UNFROZEN:
+        return &AccountStatusChanged{}
+    case ACCOUNT_METADATA_UPDATED:
+        return &AccountMetadata{}
+    case ACCOUNT_OWNER_SET:
+        return &AccountOwnerSet{}
+    case TRANSFERRED:
+        return &Transferred{}
+    case BATCH_TRANSFERRED:
+        return &BatchTransferred{}
+    case ASSET_DEFINED:
+        return &AssetDefined{}
+    case MINTED, BURNED:
+        return &SupplyChanged{}
+    case APPROVED:
+        return &Approved{}
+    case HOLD_CREATED, HOLD_RELEASED, HOLD_CANCELLED:
+        return &Hold{}
+    case HOLDS_EXPIRED:
+        return &HoldsExpired{}
+    case TRANSFER_LIMITS_SET:
+        return &TransferLimitsSet{}
+    case FEE_SCHEDULE_SET:
+        return &FeeScheduleSet{}
+    case STANDING_ORDER_CREATED, STANDING_ORDER_CANCELLED:
+        return &StandingOrder{}
+    case DUE_TRANSFERS_RUN:
+        return &DueTransfersRun{}
+    case ADMIN_CHANGE_PROPOSED, ADMIN_CHANGE_APPROVED, ADMIN_CHANGE_CANCELLED:
+        return &AdminChangeProposal{}
+    case ADMIN_CHANGED:
+        return &AdminChanged{}
+    case ADMIN_COUNCIL_CHANGED:
+        return &AdminCouncilChanged{}
+    case ROLE_GRANTED, ROLE_REVOKED:
+        return &RoleGrant{}
+    case FUNCTION_ROLES_SET:
+        return &FunctionRolesSet{}
+    case SCHEMA_MIGRATED:
+        return &SchemaMigrated{}
+    case INDEX_REPAIRED:
+        return &IndexRepaired{}
+    default:
+        return nil
+    }
+}
+
+// Returns whether event_type is one of the event types above.
+func IsKnownType (event_type string) bool {
+    return new_data(event_type) != nil
+}
+
+// Produces the chaincode event payload for the given event.  data must be of the data type corresponding to
+// event_type (or a pointer to it).
+func Encode (event_type string, tx_id string, timestamp time.Time, transactor Identity, data interface{}) ([]byte, error) {
+    if new_data(event_type) == nil {
+        return nil, fmt.Errorf("Unknown event type \"%s\"", event_type)
+    }
+    data_bytes, err := json.Marshal(data)
+    if err != nil {
+        return nil, fmt.Errorf("Could not encode %s event data; json.Marshal failed with error %v", event_type, err)
+    }
+    envelope := Envelope{
+        SchemaVersion:  SCHEMA_VERSION,
+        Type:           event_type,
+        TxID:           tx_id,
+        Timestamp:      timestamp.UTC(),
+        Transactor:     transactor,
+        Data:           data_bytes,
+    }
+    payload, err := json.Marshal(&envelope)
+    if err != nil {
+        return nil, fmt.Errorf("Could not encode %s event; json.Marshal failed with error %v", event_type, err)
+    }
+    return payload, nil
+}
+
+// Decodes a chaincode event payload into its envelope and its data, the latter being a pointer to the data type
+// corresponding to the envelope's Type (e.g. *Transferred for TRANSFERRED).
+func Decode (payload []byte) (*Envelope, interface{}, error) {
+    var envelope Envelope
+    err := json.Unmarshal(payload, &envelope)
+    if err != nil {
+        return nil, nil, fmt.Errorf("Could not decode event; json.Unmarshal failed with error %v", err)
+    }
+    if envelope.SchemaVersion != SCHEMA_VERSION {
+        return nil, nil, fmt.Errorf("Unsupported event SchemaVersion %d; expected %d", envelope.SchemaVersion, SCHEMA_VERSION)
+    }
+    data := new_data(envelope.Type)
+    if data == nil {
+        return nil, nil, fmt.Errorf("Unknown event type \"%s\"", envelope.Type)
+    }
+    err = json.Unmarshal(envelope.Data, data)
+    if err != nil {
+        return nil, nil, fmt.Errorf("Could not decode %s event data; json.Unmarshal failed with error %v", envelope.Type, err)
+    }
+    return &envelope, data, nil
+}
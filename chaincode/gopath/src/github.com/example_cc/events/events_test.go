@@ -0,0 +1,40 @@
+package events
+
+import (
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestEncodeDecodeRoundTrip (t *testing.T) {
+    timestamp := time.Date(2017, time.March, 4, 5, 6, 7, 8, time.UTC)
+    transactor := Identity{MspID:"Org0MSP", Subject:"CN=Admin", Issuer:"CN=ca"}
+    data := &Transferred{FromAccount:"Alice", ToAccount:"Bob", Amount:"12.50"}
+
+    payload, err := Encode(TRANSFERRED, "tx0", timestamp, transactor, data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    envelope, decoded_data, err := Decode(payload)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if envelope.SchemaVersion != SCHEMA_VERSION || envelope.Type != TRANSFERRED || envelope.TxID != "tx0" || !envelope.Timestamp.Equal(timestamp) || envelope.Transactor != transactor {
+        t.Errorf("decoded envelope %+v does not match what was encoded", envelope)
+    }
+    if !reflect.DeepEqual(decoded_data, data) {
+        t.Errorf("decoded data %+v does not match encoded data %+v", decoded_data, data)
+    }
+}
+
+func TestDecodeRejectsUnknownVersionAndType (t *testing.T) {
+    if _, _, err := Decode([]byte(`{"SchemaVersion": 999, "Type": "Transferred", "Data": {}}`)); err == nil {
+        t.Error("expected an unsupported SchemaVersion to be rejected")
+    }
+    if _, _, err := Decode([]byte(`{"SchemaVersion": 2, "Type": "NoSuchEvent", "Data": {}}`)); err == nil {
+        t.Error("expected an unknown event type to be rejected")
+    }
+    if _, err := Encode("NoSuchEvent", "tx0", time.Now(), Identity{}, struct{}{}); err == nil {
+        t.Error("expected encoding an unknown event type to fail")
+    }
+}
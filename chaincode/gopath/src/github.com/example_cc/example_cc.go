@@ -22,6 +22,7 @@ package main
 
 import (
     "crypto/x509"
+    "crypto/x509/pkix"
     "encoding/json"
     "encoding/pem"
     "fmt"
@@ -29,40 +30,187 @@ import (
     pb "github.com/hyperledger/fabric/protos/peer"
     "strconv"
     "strings"
+    "time"
     // NOTE: This is temporarily vendored INSIDE THE github.com/example_cc DIR!
     "github.com/example_cc/golang/protobuf/proto"
+    "github.com/example_cc/decimal"
+    "github.com/example_cc/events"
     "github.com/example_cc/util"
     "github.com/hyperledger/fabric/core/chaincode/shim"
 )
 
-// This code came from advice from Gari Singh
-func GetCreatorCert (stub shim.ChaincodeStubInterface) (*x509.Certificate, error) {
+//
+// transactor identity resolution
+//
+
+// Stable, machine-readable codes for the ways in which resolving the transactor's identity can fail.
+// These are included in the shim.Error message (see IdentityError.Error) so that clients can match on them.
+type IdentityErrorCode string
+const (
+    CREATOR_UNAVAILABLE     IdentityErrorCode = "CREATOR_UNAVAILABLE"
+    CREATOR_EMPTY           IdentityErrorCode = "CREATOR_EMPTY"
+    CREATOR_MALFORMED       IdentityErrorCode = "CREATOR_MALFORMED"
+    CREATOR_NOT_PEM         IdentityErrorCode = "CREATOR_NOT_PEM"
+    CREATOR_NOT_CERTIFICATE IdentityErrorCode = "CREATOR_NOT_CERTIFICATE"
+    CREATOR_CERT_MALFORMED  IdentityErrorCode = "CREATOR_CERT_MALFORMED"
+)
+
+type IdentityError struct {
+    Code    IdentityErrorCode
+    Message string
+}
+
+func (e *IdentityError) Error () string {
+    return fmt.Sprintf("[%s] Could not determine transactor identity; %s", e.Code, e.Message)
+}
+
+func identity_error (code IdentityErrorCode, format string, args ...interface{}) *IdentityError {
+    return &IdentityError{Code:code, Message:fmt.Sprintf(format, args...)}
+}
+
+// Returns the MSP ID and certificate of the transaction creator.  If err is not nil, then it is an *IdentityError.
+func get_creator (stub shim.ChaincodeStubInterface) (msp_id string, cert *x509.Certificate, err error) {
     creator, err := stub.GetCreator()
     if err != nil {
-        return nil, err
+        return "", nil, identity_error(CREATOR_UNAVAILABLE, "stub.GetCreator failed with error %v", err)
+    }
+    if len(creator) == 0 {
+        return "", nil, identity_error(CREATOR_EMPTY, "transaction creator is empty")
     }
     id := &mspprotos.SerializedIdentity{}
     err = proto.Unmarshal(creator, id)
     if err != nil {
-        return nil, err
+        return "", nil, identity_error(CREATOR_MALFORMED, "creator is not a SerializedIdentity; proto.Unmarshal failed with error %v", err)
     }
     block, _ := pem.Decode(id.IdBytes)
-    cert, err := x509.ParseCertificate(block.Bytes)
+    if block == nil {
+        return "", nil, identity_error(CREATOR_NOT_PEM, "IdBytes of creator (MSP \"%s\") is not PEM-encoded", id.Mspid)
+    }
+    if block.Type != "CERTIFICATE" {
+        return "", nil, identity_error(CREATOR_NOT_CERTIFICATE, "IdBytes of creator (MSP \"%s\") is a PEM block of type \"%s\", expected \"CERTIFICATE\"", id.Mspid, block.Type)
+    }
+    cert, err = x509.ParseCertificate(block.Bytes)
+    if err != nil {
+        return "", nil, identity_error(CREATOR_CERT_MALFORMED, "x509.ParseCertificate failed for creator (MSP \"%s\") with error %v", id.Mspid, err)
+    }
+    return id.Mspid, cert, nil
+}
+
+// This code came from advice from Gari Singh.  If err is not nil, then it is an *IdentityError.
+func GetCreatorCert (stub shim.ChaincodeStubInterface) (*x509.Certificate, error) {
+    _, cert, err := get_creator(stub)
     return cert, err
 }
 
-func GetTransactorCommonName (stub shim.ChaincodeStubInterface) string {
+// If err is not nil, then it is an *IdentityError.
+func GetTransactorCommonName (stub shim.ChaincodeStubInterface) (string, error) {
     cert, err := GetCreatorCert(stub)
-    // I'm just so sick of handling errors.
     if err != nil {
-        panic(err)
+        return "", err
     }
-    return cert.Subject.CommonName
+    return cert.Subject.CommonName, nil
+}
+
+// If err is not nil, then it is an *IdentityError.
+func GetTransactorIdentity (stub shim.ChaincodeStubInterface) (*Identity, error) {
+    msp_id, cert, err := get_creator(stub)
+    if err != nil {
+        return nil, err
+    }
+    return NewIdentity(msp_id, cert), nil
+}
+
+// An Identity is what authorization checks compare.  A common name alone is not sufficient, since e.g. a
+// user named "Admin" can be enrolled in any organization, so the MSP ID and the full subject and issuer
+// distinguished names are all part of the identity.  The certificate itself (and so its serial number and
+// key) is deliberately not part of it, so that an identity survives re-enrollment.
+type Identity struct {
+    MspID   string  `json:"MspID"`
+    Subject string  `json:"Subject"`
+    Issuer  string  `json:"Issuer"`
+}
+
+func NewIdentity (msp_id string, cert *x509.Certificate) *Identity {
+    return &Identity{
+        MspID:      msp_id,
+        Subject:    DistinguishedName(cert.Subject),
+        Issuer:     DistinguishedName(cert.Issuer),
+    }
+}
+
+// Parses a PEM-encoded X.509 certificate, as e.g. returned by fabric-ca upon enrollment, into an Identity.
+func IdentityFromCertificatePEM (msp_id string, cert_pem []byte) (*Identity, error) {
+    if msp_id == "" {
+        return nil, fmt.Errorf("MSP ID must not be empty")
+    }
+    block, _ := pem.Decode(cert_pem)
+    if block == nil || block.Type != "CERTIFICATE" {
+        return nil, fmt.Errorf("Expected a PEM-encoded certificate")
+    }
+    cert, err := x509.ParseCertificate(block.Bytes)
+    if err != nil {
+        return nil, fmt.Errorf("x509.ParseCertificate failed with error %v", err)
+    }
+    return NewIdentity(msp_id, cert), nil
+}
+
+// Checks that an Identity that didn't come from a certificate, e.g. one unmarshaled from JSON, is complete.
+func (identity *Identity) Validate () error {
+    if identity.MspID == "" || identity.Subject == "" || identity.Issuer == "" {
+        return fmt.Errorf("Identity %v must have nonempty MspID, Subject and Issuer", identity)
+    }
+    return nil
+}
+
+func (identity *Identity) String () string {
+    return fmt.Sprintf("\"%s\" of MSP \"%s\"", identity.Subject, identity.MspID)
+}
+
+var distinguished_name_attribute_type_names = map[string]string{
+    "2.5.4.3":  "CN",
+    "2.5.4.5":  "SERIALNUMBER",
+    "2.5.4.6":  "C",
+    "2.5.4.7":  "L",
+    "2.5.4.8":  "ST",
+    "2.5.4.9":  "STREET",
+    "2.5.4.10": "O",
+    "2.5.4.11": "OU",
+    "2.5.4.17": "POSTALCODE",
+}
+
+// Formats a distinguished name in the style of RFC 2253 (most-specific attribute first), including every
+// attribute present in the certificate.  This is done by hand rather than with pkix.Name.String because
+// the latter isn't available in the Go version used by fabric-ccenv.
+func DistinguishedName (name pkix.Name) string {
+    components := make([]string, len(name.Names))
+    for i,attribute := range name.Names {
+        type_name,ok := distinguished_name_attribute_type_names[attribute.Type.String()]
+        if !ok {
+            type_name = attribute.Type.String()
+        }
+        components[len(name.Names)-1-i] = type_name + "=" + escape_distinguished_name_value(fmt.Sprint(attribute.Value))
+    }
+    return strings.Join(components, ",")
+}
+
+func escape_distinguished_name_value (value string) string {
+    escaped := make([]rune, 0, len(value))
+    for i,r := range value {
+        switch {
+        case strings.ContainsRune(",+\"\\<>;=", r),
+             r == ' ' && (i == 0 || i == len(value)-1),
+             r == '#' && i == 0:
+            escaped = append(escaped, '\\', r)
+        default:
+            escaped = append(escaped, r)
+        }
+    }
+    return string(escaped)
 }
 
 func ValidateUserNameFormat (user_name string) error {
     if strings.Contains(user_name, ":") {
-        return fmt.Errorf("Invalid user name \"%s\"; may not contain the char ':'", user_name)
+        return coded_error(INVALID_PARAMS, "Invalid user name \"%s\"; may not contain the char ':'", user_name)
     }
     return nil
 }
@@ -87,22 +235,28 @@ type SimpleChaincode struct {
 // Config related functions
 //
 
+// Each kind of CONFIG_TABLE row has its own util.Table, whose row keys start with the kind of row.
 const CONFIG_TABLE = "ConfigTable"
 
-// The admin user is the unique privileged user, defined by the transactor for the call to Init, that is allowed
-// to invoke all chaincode methods.
+// The admin user is the unique privileged user, defined by the transactor for the call to Init which instantiated
+// the chaincode (but not for those made by upgrades), that is allowed to invoke all chaincode methods, and that
+// grants roles to other users (see roles.go).
 type Admin struct {
-    Name string `json:"Name"`
+    Identity Identity `json:"Identity"`
+    // Only in Admin rows written before Admin had an Identity, which identified the admin by common name alone.
+    Name     string   `json:"Name,omitempty"`
 }
 
+var admin_table = util.NewTable(CONFIG_TABLE, Admin{}, util.FixedRowKeys("Admin"), nil)
+
 func set_admin (stub shim.ChaincodeStubInterface, admin *Admin) error {
     var old_admin Admin
-    row_was_found,err := util.InsertTableRow(stub, CONFIG_TABLE, []string{"Admin"}, admin, util.DONT_FAIL_UPON_OVERWRITE, &old_admin)
+    row_was_found,err := admin_table.Upsert(stub, admin, &old_admin)
     if err != nil {
-        return fmt.Errorf("Error setting %s Admin value to %v; error was %v", CONFIG_TABLE, admin, err.Error())
+        return wrap_error(err, "Error setting %s Admin value to %v; error was %v", CONFIG_TABLE, admin, err.Error())
     }
     if row_was_found && *admin != old_admin {
-        fmt.Print("WARNING: Setting Admin to %v, which is different than previous value of %v", admin, old_admin)
+        fmt.Printf("WARNING: Setting Admin to %v, which is different than previous value of %v\n", admin, old_admin)
     }
     return nil // success
 }
@@ -110,30 +264,152 @@ func set_admin (stub shim.ChaincodeStubInterface, admin *Admin) error {
 // If err is not nil, then admin is nil, and vice versa.
 func get_admin (stub shim.ChaincodeStubInterface) (*Admin, error) {
     var admin Admin
-    row_was_found,err := util.GetTableRow(stub, CONFIG_TABLE, []string{"Admin"}, &admin, util.FAIL_IF_MISSING)
-    if err != nil {
-        return nil,fmt.Errorf("Could not retrieve Admin; error was %v", err.Error())
-    }
-    if !row_was_found {
+    err := admin_table.Get(stub, admin_table.RowKeys(&admin), &admin)
+    if util.IsNotFound(err) {
         return nil,fmt.Errorf("Admin entry in %s not found", CONFIG_TABLE)
     }
+    if err != nil {
+        return nil,wrap_error(err, "Could not retrieve Admin; error was %v", err.Error())
+    }
     return &admin,nil
 }
 
+// Settings that apply to the whole ledger.  These are set by Init.
+type LedgerSettings struct {
+    // The number of decimals of every balance and amount, e.g. 2 for "12.50".
+    Decimals    int     `json:"Decimals"`
+}
+
+var ledger_settings_table = util.NewTable(CONFIG_TABLE, LedgerSettings{}, util.FixedRowKeys("LedgerSettings"), nil)
+
+// Ledgers created before LedgerSettings existed have integer balances.
+var default_ledger_settings = LedgerSettings{Decimals:0}
+
+// Sets the ledger settings.  The number of decimals can't be changed once set, since existing balances and
+// client amounts would then be misinterpreted.
+func set_ledger_settings (stub shim.ChaincodeStubInterface, settings *LedgerSettings) error {
+    if _,err := decimal.Zero(0).Rescale(settings.Decimals); err != nil {
+        return err
+    }
+    var old_settings LedgerSettings
+    row_was_found,err := ledger_settings_table.Upsert(stub, settings, &old_settings)
+    if err != nil {
+        return wrap_error(err, "Error setting %s LedgerSettings value to %v; error was %v", CONFIG_TABLE, settings, err.Error())
+    }
+    if row_was_found && old_settings.Decimals != settings.Decimals {
+        return fmt.Errorf("Can't change the number of decimals from %d to %d", old_settings.Decimals, settings.Decimals)
+    }
+    return nil // success
+}
+
+func get_ledger_settings (stub shim.ChaincodeStubInterface) (*LedgerSettings, error) {
+    settings := default_ledger_settings
+    err := ledger_settings_table.Get(stub, ledger_settings_table.RowKeys(&settings), &settings)
+    if err != nil && !util.IsNotFound(err) {
+        return nil, wrap_error(err, "Could not retrieve LedgerSettings; error was %v", err.Error())
+    }
+    return &settings, nil
+}
+
+func get_ledger_decimals (stub shim.ChaincodeStubInterface) (int, error) {
+    settings,err := get_ledger_settings(stub)
+    if err != nil {
+        return 0, err
+    }
+    return settings.Decimals, nil
+}
+
+// Parses an amount given as a chaincode arg, which may have at most the ledger's number of decimals.
+func parse_amount (stub shim.ChaincodeStubInterface, s string) (decimal.Amount, error) {
+    decimals,err := get_ledger_decimals(stub)
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+    return decimal.Parse(s, decimals)
+}
+
 //
 // transactor determining functions
 //
 
-func transactor_is (stub shim.ChaincodeStubInterface, common_name string) bool {
-    return GetTransactorCommonName(stub) == common_name
+// An error is returned only if the transactor's identity could not be determined.
+func transactor_is (stub shim.ChaincodeStubInterface, identity *Identity) (bool, error) {
+    transactor,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return false, err
+    }
+    return *transactor == *identity, nil
 }
 
-func transactor_is_admin (stub shim.ChaincodeStubInterface) bool {
+// An error is returned only if the transactor's identity could not be determined; if the admin
+// can't be retrieved, then the transactor is not considered to be the admin.
+func transactor_is_admin (stub shim.ChaincodeStubInterface) (bool, error) {
+    transactor,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return false, err
+    }
     admin,err := get_admin(stub)
     if err != nil {
-        return false
+        return false, nil
     }
-    return GetTransactorCommonName(stub) == admin.Name
+    return *transactor == admin.Identity, nil
+}
+
+// An error is returned only if the transactor's identity could not be determined.  A nonexistent account
+// or one with no bound Owner (see set_account_owner) is owned by nobody.
+func transactor_is_account_owner (stub shim.ChaincodeStubInterface, account_name string) (bool, error) {
+    account,err := get_account_(stub, account_name)
+    if err != nil || account.Owner == nil {
+        // Still resolve the transactor, so that a bad creator is reported as such.
+        _,err = GetTransactorIdentity(stub)
+        return false, err
+    }
+    return transactor_is(stub, account.Owner)
+}
+
+//
+// chaincode events
+//
+
+func get_tx_time (stub shim.ChaincodeStubInterface) (time.Time, error) {
+    timestamp,err := stub.GetTxTimestamp()
+    if err != nil {
+        return time.Time{}, fmt.Errorf("stub.GetTxTimestamp failed with error %v", err)
+    }
+    if timestamp == nil {
+        return time.Time{}, fmt.Errorf("stub.GetTxTimestamp returned no timestamp")
+    }
+    return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC(), nil
+}
+
+// Sets the chaincode event for this transaction; see the events package for the event types and their data.
+// Fabric keeps only one event per transaction, so each function should call this at most once.
+func emit_event (stub shim.ChaincodeStubInterface, event_type string, data interface{}) error {
+    transactor,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return err
+    }
+    tx_time,err := get_tx_time(stub)
+    if err != nil {
+        return wrap_error(err, "Could not emit %s event; %v", event_type, err.Error())
+    }
+    payload,err := events.Encode(event_type, stub.GetTxID(), tx_time, events.Identity(*transactor), data)
+    if err != nil {
+        return err
+    }
+    err = stub.SetEvent(event_type, payload)
+    if err != nil {
+        return fmt.Errorf("Could not emit %s event; stub.SetEvent failed with error %v", event_type, err)
+    }
+    return nil
+}
+
+func event_identities (identities []Identity) []events.Identity {
+    event_identities := make([]events.Identity, len(identities))
+    for i,identity := range identities {
+        event_identities[i] = events.Identity(identity)
+    }
+    return event_identities
 }
 
 //
@@ -142,105 +418,335 @@ func transactor_is_admin (stub shim.ChaincodeStubInterface) bool {
 
 const ACCOUNT_TABLE = "AccountTable"
 
+// Owner is nil for accounts created before ownership was bound to an Identity (when the holder was
+// whoever had the common name Name) and for accounts created without specifying an owner.  Such accounts
+// can only be operated on by the admin until an owner is bound using set_account_owner.
 type Account struct {
-    Name    string  `json:"Name"`
-    Balance int     `json:"Balance"`
+    Name    string          `json:"Name"`
+    Balance decimal.Amount  `json:"Balance"`
+    Owner   *Identity       `json:"Owner,omitempty"`
+    // One of ACCOUNT_ACTIVE, ACCOUNT_FROZEN or ACCOUNT_CLOSED.
+    Status  string          `json:"Status"`
+    // The part of Balance reserved by holds (see Hold), which can't be spent until they are resolved.
+    Held    decimal.Amount  `json:"Held"`
+}
+
+// Account statuses.  Balances of frozen and closed accounts can't change.  A closed account is kept as a
+// tombstone, so that its name can't be reused, and can't be reopened.
+const (
+    ACCOUNT_ACTIVE  = "active"
+    ACCOUNT_FROZEN  = "frozen"
+    ACCOUNT_CLOSED  = "closed"
+)
+
+// Accounts stored before statuses existed are active.  Balances of accounts stored before the ledger's number
+// of decimals was set may have fewer decimals, so they are brought to the ledger's number of decimals.
+func normalize_account_ (account *Account, decimals int) error {
+    balance,err := account.Balance.Rescale(decimals)
+    if err != nil {
+        return wrap_error(err, "Balance of account \"%s\" is invalid; %v", account.Name, err.Error())
+    }
+    account.Balance = balance
+    held,err := account.Held.Rescale(decimals)
+    if err != nil {
+        return wrap_error(err, "Held amount of account \"%s\" is invalid; %v", account.Name, err.Error())
+    }
+    account.Held = held
+    if account.Status == "" {
+        account.Status = ACCOUNT_ACTIVE
+    }
+    return nil
+}
+
+// Returns the part of Balance that isn't held.
+func (account *Account) available () (decimal.Amount, error) {
+    return account.Balance.Sub(account.Held)
+}
+
+// Returns nil if the balances of the account may change.
+func check_account_is_active (account *Account) error {
+    if account.Status != ACCOUNT_ACTIVE {
+        return coded_error(ACCOUNT_NOT_ACTIVE, "Account \"%s\" is %s", account.Name, account.Status)
+    }
+    return nil
 }
 
 func row_keys_of_Account (account *Account) []string {
     return []string{account.Name}
 }
 
+var account_table = util.NewTable(ACCOUNT_TABLE, Account{}, func (row interface{}) []string { return row_keys_of_Account(row.(*Account)) }, nil)
+
 // Raw form of function which does no permissions checking
 func create_account_ (stub shim.ChaincodeStubInterface, account *Account) error {
-    var old_account Account
-    row_was_found,err := util.InsertTableRow(stub, ACCOUNT_TABLE, row_keys_of_Account(account), account, util.FAIL_BEFORE_OVERWRITE, &old_account)
+    err := account_table.Insert(stub, account)
     if err != nil {
         return err
     }
-    if row_was_found {
-        return fmt.Errorf("Could not create account %v because an account with that Name already exists", *account)
-    }
-    return nil // success
+    return put_account_activity_(stub, account.Name)
 }
 
 // Raw form of function which does no permissions checking
 func overwrite_account_ (stub shim.ChaincodeStubInterface, account *Account) error {
-    _,err := util.InsertTableRow(stub, ACCOUNT_TABLE, row_keys_of_Account(account), account, util.FAIL_UNLESS_OVERWRITE, nil)
-    return err
+    err := account_table.Update(stub, account)
+    if err != nil {
+        return err
+    }
+    return put_account_activity_(stub, account.Name)
 }
 
-// Raw form of function which does no permissions checking
-func delete_account_ (stub shim.ChaincodeStubInterface, account_name string) error {
-    _,err := util.DeleteTableRow(stub, ACCOUNT_TABLE, []string{account_name}, nil, util.FAIL_IF_MISSING)
-    return err
+// Raw form of function which does no permissions checking.  Returns the deleted account.  Its metadata is
+// deleted along with it.
+func delete_account_ (stub shim.ChaincodeStubInterface, account_name string) (*Account, error) {
+    var account Account
+    err := account_table.Delete(stub, []string{account_name}, &account)
+    if err != nil {
+        return nil, err
+    }
+    err = delete_account_metadata_(stub, account_name)
+    if err != nil {
+        return nil, err
+    }
+    return &account, nil
 }
 
 // Raw form of function which does no permissions checking
 func get_account_ (stub shim.ChaincodeStubInterface, account_name string) (*Account, error) {
     var account Account
-    row_was_found,err := util.GetTableRow(stub, ACCOUNT_TABLE, []string{account_name}, &account, util.FAIL_IF_MISSING)
+    err := account_table.Get(stub, []string{account_name}, &account)
+    if util.IsNotFound(err) {
+        return nil,coded_error(NOT_FOUND, "Could not retrieve account named \"%s\"; error was %v", account_name, err.Error())
+    }
     if err != nil {
-        return nil,fmt.Errorf("Could not retrieve account named \"%s\"; error was %v", account_name, err.Error())
+        return nil,wrap_error(err, "Could not retrieve account named \"%s\"; error was %v", account_name, err.Error())
+    }
+    decimals,err := get_ledger_decimals(stub)
+    if err != nil {
+        return nil, err
     }
-    if !row_was_found {
-        return nil,fmt.Errorf("Account named \"%s\" does not exist", account_name)
+    err = normalize_account_(&account, decimals)
+    if err != nil {
+        return nil, err
     }
     return &account,nil
 }
 
-// Raw form of function which does no permissions checking
-func transfer_ (stub shim.ChaincodeStubInterface, from_account_name string, to_account_name string, amount int) error {
-    if amount < 0 {
-        return fmt.Errorf("Can't transfer a negative amount (%d)", amount)
+// Moves amount from from_balance to to_balance in memory only; the balances are unchanged if an error is
+// returned.  A transfer within the same account changes nothing (and the balances may then be the same copy).
+func move_amount_ (from_balance *decimal.Amount, to_balance *decimal.Amount, same_account bool, amount decimal.Amount) error {
+    if amount.Sign() < 0 {
+        return fmt.Errorf("Can't transfer a negative amount (%v)", amount)
+    }
+    if from_balance.Cmp(amount) < 0 {
+        return coded_error(INSUFFICIENT_BALANCE, "Can't transfer; \"from\" account balance (%v) is less than transfer amount (%v)", *from_balance, amount)
     }
-    from_account,err := get_account_(stub, from_account_name)
+    if same_account {
+        return nil
+    }
+
+    new_from_balance,err := from_balance.Sub(amount)
     if err != nil {
-        return fmt.Errorf("Error in retrieving \"from\" account \"%s\"; %v", from_account_name, err.Error())
+        return err
     }
-    to_account,err := get_account_(stub, to_account_name)
+    new_to_balance,err := to_balance.Add(amount)
     if err != nil {
-        return fmt.Errorf("Error in retrieving \"to\" account \"%s\"; %v", to_account_name, err.Error())
+        return wrap_error(err, "Can't transfer %v; %v", amount, err.Error())
     }
-    if from_account.Balance < amount {
-        return fmt.Errorf("Can't transfer; \"from\" account balance (%d) is less than transfer amount (%d)", from_account.Balance, amount)
+    *from_balance = new_from_balance
+    *to_balance = new_to_balance
+    return nil
+}
+
+// Moves amount of the default asset from from_account to to_account in memory only.
+func move_balance_ (from_account *Account, to_account *Account, amount decimal.Amount) error {
+    if err := check_account_is_active(from_account); err != nil {
+        return err
+    }
+    if err := check_account_is_active(to_account); err != nil {
+        return err
     }
+    if from_account.Held.Sign() != 0 {
+        available,err := from_account.available()
+        if err != nil {
+            return err
+        }
+        if available.Cmp(amount) < 0 {
+            return coded_error(INSUFFICIENT_BALANCE, "Can't transfer; \"from\" account available balance (%v, as %v is held) is less than transfer amount (%v)", available, from_account.Held, amount)
+        }
+    }
+    return move_amount_(&from_account.Balance, &to_account.Balance, from_account.Name == to_account.Name, amount)
+}
+
+// Accounts read from the ledger, so that several transfers can be made in memory and then written together.
+// GetState doesn't see this transaction's writes, so the accounts must be read only once.
+type account_cache struct {
+    stub        shim.ChaincodeStubInterface
+    accounts    map[string]*Account
+    touched     []*Account
+}
 
-    from_account.Balance -= amount
-    to_account.Balance += amount
+func new_account_cache (stub shim.ChaincodeStubInterface) *account_cache {
+    return &account_cache{stub:stub, accounts:make(map[string]*Account)}
+}
 
-    err = overwrite_account_(stub, from_account)
-    if err != nil {
-        return fmt.Errorf("Could not transfer from account %v; error was %v", *from_account, err.Error())
+func (cache *account_cache) get (account_name string) (*Account, error) {
+    if account,ok := cache.accounts[account_name]; ok {
+        return account, nil
     }
-
-    err = overwrite_account_(stub, to_account)
+    account,err := get_account_(cache.stub, account_name)
     if err != nil {
-        return fmt.Errorf("Could not transfer to account %v; error was %v", *to_account, err.Error())
+        return nil, err
     }
+    cache.accounts[account_name] = account
+    cache.touched = append(cache.touched, account)
+    return account, nil
+}
 
+// Writes every account that was read.
+func (cache *account_cache) write () error {
+    for _,account := range cache.touched {
+        err := overwrite_account_(cache.stub, account)
+        if err != nil {
+            return wrap_error(err, "Could not update account %v; error was %v", *account, err.Error())
+        }
+    }
     return nil
 }
 
-func get_account_names_ (stub shim.ChaincodeStubInterface) ([]string, error) {
-    row_json_bytes_channel,err := util.GetTableRows(stub, ACCOUNT_TABLE, []string{}) // empty row_keys to get all entries
+// Raw form of function which does no permissions checking.  Makes a transfer of the default asset, along with
+// its fee (see FeeSchedule), in memory only, checking it against and recording it in tracker.  The fee charged
+// is returned, or nil if the transfer was free.  The balances are unchanged if an error is returned.
+func move_with_fee_ (stub shim.ChaincodeStubInterface, cache *account_cache, tracker *outflow_tracker, from_account_name string, to_account_name string, amount decimal.Amount) (*TransferFee, error) {
+    if amount.Sign() < 0 {
+        return nil, fmt.Errorf("Can't transfer a negative amount (%v)", amount)
+    }
+    from_account,err := cache.get(from_account_name)
+    if err != nil {
+        return nil, wrap_error(err, "Error in retrieving \"from\" account \"%s\"; %v", from_account_name, err.Error())
+    }
+    to_account,err := cache.get(to_account_name)
     if err != nil {
-        return nil, fmt.Errorf("Could not get account names; %v", err.Error())
+        return nil, wrap_error(err, "Error in retrieving \"to\" account \"%s\"; %v", to_account_name, err.Error())
+    }
+    fee,err := get_transfer_fee_(stub, from_account_name, to_account_name, amount)
+    if err != nil {
+        return nil, err
+    }
+    total := amount
+    collector_account := to_account
+    if fee != nil {
+        total,err = amount.Add(fee.Amount)
+        if err != nil {
+            return nil, err
+        }
+        collector_account,err = cache.get(fee.Collector)
+        if err != nil {
+            return nil, wrap_error(err, "Error in retrieving fee collector account \"%s\"; %v", fee.Collector, err.Error())
+        }
     }
 
-    var account_names []string
-    var account Account
-    for row_json_bytes := range(row_json_bytes_channel) {
-        err = json.Unmarshal(row_json_bytes, &account)
+    err = tracker.check(from_account, to_account_name, total)
+    if err != nil {
+        return nil, err
+    }
+    // Restored if the fee can't be paid, since the amount has been moved by then.
+    from_balance,to_balance := from_account.Balance,to_account.Balance
+    err = move_balance_(from_account, to_account, amount)
+    if err != nil {
+        return nil, err
+    }
+    if fee != nil {
+        err = move_balance_(from_account, collector_account, fee.Amount)
         if err != nil {
-            return nil, fmt.Errorf("Could not get account names; json.Unmarshal of \"%s\" failed with error %v", string(row_json_bytes), err)
+            from_account.Balance,to_account.Balance = from_balance,to_balance
+            return nil, wrap_error(err, "Can't pay the transfer fee of %v; %v", fee.Amount, err.Error())
         }
+    }
+    err = tracker.record(from_account_name, to_account_name, total)
+    if err != nil {
+        return nil, err
+    }
+    return fee, nil
+}
+
+// Raw form of function which does no permissions checking.  The fee charged (see FeeSchedule) is returned, or
+// nil if the transfer was free.
+func transfer_ (stub shim.ChaincodeStubInterface, from_account_name string, to_account_name string, amount decimal.Amount) (*TransferFee, error) {
+    cache := new_account_cache(stub)
+    tracker := new_outflow_tracker(stub)
+    fee,err := move_with_fee_(stub, cache, tracker, from_account_name, to_account_name, amount)
+    if err != nil {
+        return nil, err
+    }
+    err = cache.write()
+    if err != nil {
+        return nil, wrap_error(err, "Could not transfer; %v", err.Error())
+    }
+    return fee, tracker.write()
+}
+
+func get_account_names_ (stub shim.ChaincodeStubInterface) ([]string, error) {
+    var accounts []Account
+    err := account_table.Scan(stub, []string{}, &accounts) // empty row_keys to get all entries
+    if err != nil {
+        return nil, wrap_error(err, "Could not get account names; %v", err.Error())
+    }
 
+    var account_names []string
+    for _,account := range accounts {
         account_names = append(account_names, account.Name)
     }
     return account_names, nil
 }
 
+const (
+    MAX_ACCOUNT_NAMES_PAGE_SIZE     = 1000
+)
+
+// Bookmark is empty if there are no further accounts; otherwise it is passed to query_account_names to get the
+// next page.  Accounts is only present if balances were requested.
+type AccountNamesPage struct {
+    Names       []string    `json:"Names"`
+    Accounts    []Account   `json:"Accounts,omitempty"`
+    Count       int         `json:"Count"`
+    Bookmark    string      `json:"Bookmark"`
+}
+
+// Returns up to page_size accounts whose names start with name_prefix, in name order, starting at the account
+// named bookmark (or the next one after it) if bookmark is not empty.
+func get_account_names_page_ (stub shim.ChaincodeStubInterface, name_prefix string, bookmark string, page_size int, with_balances bool) (*AccountNamesPage, error) {
+    var start_row_keys []string
+    if bookmark != "" {
+        start_row_keys = row_keys_of_Account(&Account{Name:bookmark})
+    }
+    var accounts []Account
+    next_row_keys,err := account_table.ScanPage(stub, []string{}, name_prefix, start_row_keys, page_size, &accounts)
+    if err != nil {
+        return nil, wrap_error(err, "Could not get account names; %v", err.Error())
+    }
+
+    decimals,err := get_ledger_decimals(stub)
+    if err != nil {
+        return nil, err
+    }
+    page := &AccountNamesPage{Names:[]string{}}
+    for _,account := range accounts {
+        page.Names = append(page.Names, account.Name)
+        if with_balances {
+            err = normalize_account_(&account, decimals)
+            if err != nil {
+                return nil, err
+            }
+            page.Accounts = append(page.Accounts, account)
+        }
+    }
+    page.Count = len(page.Names)
+    if len(next_row_keys) > 0 {
+        page.Bookmark = next_row_keys[0]
+    }
+    return page, nil
+}
+
 //
 // chaincode API functions
 //
@@ -248,15 +754,79 @@ func get_account_names_ (stub shim.ChaincodeStubInterface) ([]string, error) {
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response  {
     fmt.Println("########### example_cc Init ###########")
     _, args := stub.GetFunctionAndParameters()
-    if len(args) != 0 {
-        return shim.Error("Incorrect number of arguments. Expecting 0")
+    if len(args) > 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 or 1; optionally the number of decimals of balances")
     }
 
-    fmt.Printf("within Init : GetTransactorCommonName(stub): %v\n", GetTransactorCommonName(stub))
+    transactor,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    fmt.Printf("within Init : GetTransactorIdentity(stub): %v\n", transactor)
 
-    err := set_admin(stub, &Admin{Name:GetTransactorCommonName(stub)})
+    // Init is called both when the chaincode is first instantiated and when it is upgraded.  Upon an upgrade,
+    // the admin is kept, and the ledger data is left at its schema version for run_migrations to migrate.
+    is_upgrade,err := admin_table.Exists(stub, admin_table.RowKeys(&Admin{}))
     if err != nil {
-        return shim.Error(fmt.Sprintf("Init failed; %v", err.Error()))
+        return error_response(wrap_error(err, "Init failed; %v", err.Error()))
+    }
+    if is_upgrade {
+        // Ledgers written before Admin had an Identity have an Admin row {"Name":...} which identifies nobody,
+        // so the admin it names must make the upgrade and is bound to their full identity; otherwise no one could
+        // run_migrations.  Only the common name can be checked, which is all that the legacy row identified.
+        admin,err := get_admin(stub)
+        if err != nil {
+            return error_response(wrap_error(err, "Init failed; %v", err.Error()))
+        }
+        if admin.Identity == (Identity{}) {
+            common_name,err := GetTransactorCommonName(stub)
+            if err != nil {
+                return error_response(err)
+            }
+            if admin.Name == "" || common_name != admin.Name {
+                return coded_error_response(UNAUTHORIZED, "Init failed; the ledger's admin \"%s\" has no Identity, so only they may upgrade the chaincode, not %v", admin.Name, transactor)
+            }
+            fmt.Printf("Upgrade: Admin \"%s\" has no Identity, so it is set to the transactor %v of MSP \"%s\"\n", admin.Name, transactor, transactor.MspID)
+            err = set_admin(stub, &Admin{Identity:*transactor})
+            if err != nil {
+                return error_response(wrap_error(err, "Init failed; %v", err.Error()))
+            }
+        }
+        schema,err := get_schema_version_(stub)
+        if err != nil {
+            return error_response(wrap_error(err, "Init failed; %v", err.Error()))
+        }
+        if schema.Version > CURRENT_SCHEMA_VERSION {
+            return shim.Error(fmt.Sprintf("Init failed; the ledger data has schema version %d, which is newer than this chaincode's schema version %d", schema.Version, CURRENT_SCHEMA_VERSION))
+        }
+        if schema.Version < CURRENT_SCHEMA_VERSION {
+            fmt.Printf("Upgrade: ledger data has schema version %d, and must be migrated to %d by run_migrations\n", schema.Version, CURRENT_SCHEMA_VERSION)
+        }
+    } else {
+        err = set_admin(stub, &Admin{Identity:*transactor})
+        if err != nil {
+            return error_response(wrap_error(err, "Init failed; %v", err.Error()))
+        }
+        err = set_schema_version_(stub, &SchemaVersion{Version:CURRENT_SCHEMA_VERSION})
+        if err != nil {
+            return error_response(wrap_error(err, "Init failed; %v", err.Error()))
+        }
+    }
+
+    // Without the decimals arg, the existing settings (if any) are kept.
+    settings,err := get_ledger_settings(stub)
+    if err != nil {
+        return error_response(wrap_error(err, "Init failed; %v", err.Error()))
+    }
+    if len(args) == 1 {
+        settings.Decimals,err = strconv.Atoi(args[0])
+        if err != nil {
+            return coded_error_response(INVALID_PARAMS, "Malformed decimals \"%s\"; expecting integer between 0 and %d", args[0], decimal.MAX_DECIMALS)
+        }
+    }
+    err = set_ledger_settings(stub, settings)
+    if err != nil {
+        return error_response(wrap_error(err, "Init failed; %v", err.Error()))
     }
 
     return shim.Success(nil)
@@ -266,123 +836,333 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
     fmt.Println("########### example_cc Invoke ###########")
     function, args := stub.GetFunctionAndParameters()
 
-    fmt.Printf("within Invoke : GetTransactorCommonName(stub): %v\n", GetTransactorCommonName(stub))
-
-    if function == "create_account" {
-        // Creates an account with the given name and initial balance.
-        return t.create_account(stub, args)
-    }
-    if function == "delete_account" {
-        // Deletes an account.
-        return t.delete_account(stub, args)
+    // A request envelope is validated and answered by invoke_request, which reports a bad creator itself.
+    if function == REQUEST_FUNCTION {
+        return t.invoke_request(stub, args)
     }
-    if function == "transfer" {
-        // Transfers an amount from one account to another.
-        return t.transfer(stub, args)
+
+    // Resolve the transactor up front so that a bad creator is reported uniformly for every function.
+    transactor,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return error_response(err)
     }
-    if function == "query_balance" {
-        // Queries an account balance.
-        return t.query_balance(stub, args)
+    fmt.Printf("within Invoke : GetTransactorIdentity(stub): %v\n", transactor)
+
+    spec := get_function_spec(function)
+    if spec == nil {
+        return shim.Error(unknown_function_message(function))
     }
-    if function == "query_account_names" {
-        // Queries all account names.
-        return t.query_account_names(stub, args)
+    if err = spec.check_schema_is_current(stub); err != nil {
+        return error_response(err)
     }
-    return shim.Error(fmt.Sprintf("Unknown action '%s', check the first argument, must be one of 'create_account', 'delete', 'query_balance', or 'transfer'", function))
+    return spec.handler(t, stub, args)
 }
 
+// The optional owner_msp_id and owner_cert_pem args bind the account to the identity of its holder (see
+// Identity); without them, the account can only be operated on by the admin until set_account_owner is called.
+// Args are account_holder_name, initial_balance, optionally owner_msp_id and owner_cert_pem, optionally the
+// symbol of the asset of initial_balance (which defaults to the default asset), and optionally the account's
+// metadata (see AccountMetadataUpdate).  A nonzero initial_balance is minted, so it also requires the authorization
+// of mint for the asset.
 func (t *SimpleChaincode) create_account (stub shim.ChaincodeStubInterface, args []string) pb.Response {
-    if len(args) != 2 {
-        return shim.Error("Incorrect number of arguments.  Expecting 2; account_holder_name and initial_balance")
+    if len(args) < 2 || len(args) > 6 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments.  Expecting 2 to 6; account_holder_name, initial_balance, optionally owner_msp_id and owner_cert_pem, optionally asset, and optionally metadata")
     }
 
-    if !transactor_is_admin(stub) {
-        return shim.Error(fmt.Sprintf("Could not create account; transactor \"%s\" is not the registered admin user", GetTransactorCommonName(stub)))
+    err := check_permission(stub, "create_account", "create_account")
+    if err != nil {
+        return error_response(err)
     }
 
     // Parse and validate the args.
     account_holder_name := args[0]
-    initial_balance, err := strconv.Atoi(args[1])
+    asset_symbol := DEFAULT_ASSET
+    if len(args) == 3 || len(args) == 5 {
+        asset_symbol = args[len(args)-1]
+    }
+    // Metadata can only be given with all the other args.  Empty owner args denote no owner and an empty asset
+    // denotes the default asset, so that a request envelope can omit them.
+    var metadata_update *AccountMetadataUpdate
+    if len(args) == 6 {
+        asset_symbol = args[4]
+        metadata_update,err = parse_account_metadata_update(args[5])
+        if err != nil {
+            return error_response(err)
+        }
+    }
+    initial_balance, err := parse_asset_amount(stub, asset_symbol, args[1])
     if err != nil {
-        return shim.Error(fmt.Sprintf("Malformed initial_balance string \"%s\"; expecting nonnegative integer", args[1]))
+        return coded_error_response(INVALID_PARAMS, "Malformed initial_balance string \"%s\"; expecting nonnegative amount; %v", args[1], err.Error())
+    }
+    if initial_balance.Sign() < 0 {
+        return coded_error_response(INVALID_PARAMS, "Invalid initial_balance %v; expecting nonnegative amount", initial_balance)
+    }
+    // A nonzero initial balance mints it, so needs the same authorization as mint.
+    var asset *Asset
+    if asset_symbol != DEFAULT_ASSET {
+        asset,err = get_asset_(stub, asset_symbol)
+        if err != nil {
+            return error_response(err)
+        }
+    }
+    if initial_balance.Sign() > 0 {
+        err = check_supply_permission(stub, "mint", asset)
+        if err != nil {
+            return error_response(wrap_error(err, "Could not create account \"%s\" with an initial_balance; %v", account_holder_name, err.Error()))
+        }
+    }
+    var owner *Identity
+    if len(args) >= 4 && (args[2] != "" || args[3] != "") {
+        owner,err = IdentityFromCertificatePEM(args[2], []byte(args[3]))
+        if err != nil {
+            return coded_error_response(INVALID_PARAMS, "Invalid owner for account \"%s\"; %v", account_holder_name, err.Error())
+        }
+    }
+
+    account := &Account{Name:account_holder_name, Balance:initial_balance, Owner:owner, Status:ACCOUNT_ACTIVE}
+    if asset_symbol == DEFAULT_ASSET {
+        _,err = add_to_total_supply_(stub, initial_balance)
+        if err != nil {
+            return error_response(wrap_error(err, "Could not create account \"%s\"; %v", account_holder_name, err.Error()))
+        }
+    } else {
+        // The initial balance is issued in the asset, and the account has none of the default asset.
+        account.Balance,err = parse_amount(stub, "0")
+        if err != nil {
+            return error_response(err)
+        }
+        err = issue_(asset, initial_balance)
+        if err != nil {
+            return error_response(wrap_error(err, "Could not create account \"%s\"; %v", account_holder_name, err.Error()))
+        }
+        err = overwrite_asset_(stub, asset)
+        if err != nil {
+            return error_response(err)
+        }
+        err = put_asset_balance_(stub, &AssetBalance{Account:account_holder_name, Asset:asset_symbol, Balance:initial_balance})
+        if err != nil {
+            return error_response(err)
+        }
     }
-    if initial_balance < 0 {
-        return shim.Error(fmt.Sprintf("Invalid initial_balance %v; expecting nonnegative integer", initial_balance))
+    err = create_account_(stub, account)
+    if err != nil {
+        return error_response(err)
     }
 
-    err = create_account_(stub, &Account{Name:account_holder_name, Balance:initial_balance})
+    tx_time,err := get_tx_time(stub)
     if err != nil {
-        return shim.Error(err.Error())
+        return error_response(err)
+    }
+    metadata := &AccountMetadata{Account:account_holder_name, Attributes:make(map[string]string), CreatedTxID:stub.GetTxID(), CreatedAt:&tx_time}
+    if owner != nil {
+        metadata.OrgMspID = owner.MspID
+    } else {
+        creator,err := GetTransactorIdentity(stub)
+        if err != nil {
+            return error_response(err)
+        }
+        metadata.OrgMspID = creator.MspID
+    }
+    if metadata_update != nil {
+        err = metadata_update.apply_to(metadata)
+        if err != nil {
+            return error_response(err)
+        }
+    }
+    err = put_account_metadata_(stub, metadata)
+    if err != nil {
+        return error_response(err)
+    }
+
+    event_data := &events.AccountCreated{Account:account_holder_name, Asset:asset_symbol, InitialBalance:initial_balance.String(), Owner:(*events.Identity)(owner)}
+    if metadata_update != nil {
+        event_data.Metadata = metadata.event_data()
+    }
+    err = emit_event(stub, events.ACCOUNT_CREATED, event_data)
+    if err != nil {
+        return error_response(err)
     }
 
     return shim.Success(nil)
 }
 
+// Args are from_account_name, to_account_name, amount, and optionally the asset symbol (which defaults to the
+// default asset, also denoted by an empty asset), memo and client_ref_id, which are recorded in the
+// TransferRecord of the transfer.  A client_ref_id can only be used once by each transactor (see
+// TransferRecord), so that retrying a transfer can't make it twice.
 func (t *SimpleChaincode) transfer (stub shim.ChaincodeStubInterface, args []string) pb.Response {
-    if len(args) != 3 {
-        return shim.Error("Incorrect number of arguments. Expecting 3; 2 names and 1 value")
+    if len(args) < 3 || len(args) > 6 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 3 to 6; 2 names, 1 value, and optionally asset, memo and client_ref_id")
     }
 
     from_account_name := args[0]
     to_account_name := args[1]
-    amount, err := strconv.Atoi(args[2])
+    asset_symbol := DEFAULT_ASSET
+    if len(args) >= 4 {
+        asset_symbol = args[3]
+    }
+    memo := ""
+    if len(args) >= 5 {
+        memo = args[4]
+        if len(memo) > MAX_MEMO_LENGTH {
+            return coded_error_response(INVALID_PARAMS, "Invalid memo; it is longer than %d bytes", MAX_MEMO_LENGTH)
+        }
+    }
+    client_ref_id := ""
+    if len(args) == 6 && args[5] != "" {
+        client_ref_id = args[5]
+        if err := ValidateClientRefID(client_ref_id); err != nil {
+            return error_response(err)
+        }
+    }
+    amount, err := parse_asset_amount(stub, asset_symbol, args[2])
     if err != nil {
-        return shim.Error(fmt.Sprintf("Invalid transaction amount \"%s\", expecting a integer value", args[2]))
+        return coded_error_response(INVALID_PARAMS, "Invalid transaction amount \"%s\"; %v", args[2], err.Error())
     }
 
-    // Admin is allowed to transfer, and the account holder is allowed to transfer.
-    if !transactor_is_admin(stub) && !transactor_is(stub, from_account_name) {
-        return shim.Error(fmt.Sprintf("User \"%s\" is not authorized to transfer from account \"%s\"", GetTransactorCommonName(stub), from_account_name))
+    // The account holder is allowed to transfer, as is anyone with permission to transfer (e.g. Admin).
+    is_holder,err := transactor_is_account_owner(stub, from_account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_holder {
+        err = check_permission(stub, "transfer", fmt.Sprintf("transfer from account \"%s\"", from_account_name))
+        if err != nil {
+            return error_response(err)
+        }
     }
 
-    err = transfer_(stub, from_account_name, to_account_name, amount)
+    sender,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    tx_time,err := get_tx_time(stub)
     if err != nil {
-        return shim.Error(err.Error())
+        return error_response(err)
+    }
+    record := &TransferRecord{
+        TxID:           stub.GetTxID(),
+        Timestamp:      tx_time,
+        Sender:         *sender,
+        FromAccount:    from_account_name,
+        ToAccount:      to_account_name,
+        Asset:          asset_symbol,
+        Amount:         amount,
+        Memo:           memo,
+        ClientRefID:    client_ref_id,
+    }
+    err = create_transfer_record_(stub, record)
+    if err != nil {
+        return error_response(err)
+    }
+
+    result := &TransferResult{FromAccount:from_account_name, ToAccount:to_account_name, Asset:asset_symbol, Amount:amount}
+    if asset_symbol == DEFAULT_ASSET {
+        result.Fee,err = transfer_(stub, from_account_name, to_account_name, amount)
+    } else {
+        err = transfer_asset_by_name_(stub, from_account_name, to_account_name, asset_symbol, amount)
+    }
+    if err != nil {
+        return error_response(err)
+    }
+
+    event_data := result.event_data()
+    event_data.Memo = memo
+    event_data.ClientRefID = client_ref_id
+    err = emit_event(stub, events.TRANSFERRED, event_data)
+    if err != nil {
+        return error_response(err)
     }
 
-    return shim.Success(nil);
+    bytes,err := json.Marshal(result)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing transfer result failed in transfer because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes);
 }
 
-// Deletes the account of the named user.
+// Deletes the account of the named user.  An account holding a non-zero balance (in any asset) can only be
+// deleted if the name of an account to sweep its balances into is given as the second arg, in which case the
+// transactor must also be authorized to transfer from the account.
 func (t *SimpleChaincode) delete_account (stub shim.ChaincodeStubInterface, args []string) pb.Response {
-    if len(args) != 1 {
-        return shim.Error("Incorrect number of arguments. Expecting 1")
+    if len(args) != 1 && len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1 or 2; account_name, and optionally sweep_to_account_name")
     }
 
-    // only Admin is allowed to delete accounts
-    if !transactor_is_admin(stub) {
-        return shim.Error("Only admin user is not authorized to delete_account")
+    err := check_permission(stub, "delete_account", "delete_account")
+    if err != nil {
+        return error_response(err)
     }
 
     account_name := args[0]
-    err := delete_account_(stub, account_name)
+    account,err := get_account_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if account.Status == ACCOUNT_CLOSED {
+        return shim.Error(fmt.Sprintf("Could not delete account \"%s\" because it is closed; closed accounts are kept so that their names can't be reused", account_name))
+    }
+    sweep_to_account_name := ""
+    if len(args) == 2 {
+        sweep_to_account_name = args[1]
+    }
+    event,err := empty_account_(stub, account, sweep_to_account_name)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not delete account \"%s\"; %v", account_name, err.Error()))
+    }
+
+    _,err = delete_account_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+
+    err = emit_event(stub, events.ACCOUNT_DELETED, event)
     if err != nil {
-        return shim.Error(err.Error())
+        return error_response(err)
     }
 
     return shim.Success(nil)
 }
 
-// Query the balance of an account with specified username.
+// Query the balance of an account with specified username.  If an asset symbol is given as the second arg,
+// then the AssetBalance of the account in that asset is returned instead of the Account.
 func (t *SimpleChaincode) query_balance (stub shim.ChaincodeStubInterface, args []string) pb.Response {
-    if len(args) != 1 {
-        return shim.Error("Incorrect number of arguments. Expecting name of the person to query")
+    if len(args) != 1 && len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting name of the person to query, and optionally asset")
     }
 
     account_name := args[0]
 
-    // Admin is allowed to query_balance, and the account holder is allowed to query_balance.
-    if !transactor_is_admin(stub) && !transactor_is(stub, account_name) {
-        return shim.Error(fmt.Sprintf("User \"%s\" is not authorized to query account \"%s\"", GetTransactorCommonName(stub), account_name))
+    // The account holder is allowed to query_balance, as is anyone with permission to (e.g. Admin).
+    is_holder,err := transactor_is_account_owner(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_holder {
+        err = check_permission(stub, "query_balance", fmt.Sprintf("query account \"%s\"", account_name))
+        if err != nil {
+            return error_response(err)
+        }
     }
 
     account,err := get_account_(stub, account_name)
     if err != nil {
-        return shim.Error(fmt.Sprintf("Could not query_balance for account \"%s\"; error was %v", account_name, err))
+        return error_response(wrap_error(err, "Could not query_balance for account \"%s\"; error was %v", account_name, err))
+    }
+    var result interface{} = account
+    if len(args) == 2 && args[1] != DEFAULT_ASSET {
+        asset,err := get_asset_(stub, args[1])
+        if err != nil {
+            return error_response(wrap_error(err, "Could not query_balance for account \"%s\"; error was %v", account_name, err))
+        }
+        result,err = get_asset_balance_(stub, account_name, asset)
+        if err != nil {
+            return error_response(wrap_error(err, "Could not query_balance for account \"%s\"; error was %v", account_name, err))
+        }
     }
 
-    // Serialize Account struct as JSON
-    bytes,err := json.Marshal(account)
+    // Serialize Account (or AssetBalance) struct as JSON
+    bytes,err := json.Marshal(result)
     if err != nil {
         return shim.Error(fmt.Sprintf("Serializing account failed in query_balance because json.Marshal failed with error %v", err))
     }
@@ -390,20 +1170,26 @@ func (t *SimpleChaincode) query_balance (stub shim.ChaincodeStubInterface, args
     return shim.Success(bytes)
 }
 
-// Query all account names
+// Query all account names.  With no args, returns a JSON array of all account names.  Otherwise args are
+// page_size and optionally bookmark (as returned in the previous page), name_prefix and with_balances ("true"
+// or "false"), and an AccountNamesPage is returned.
 func (t *SimpleChaincode) query_account_names (stub shim.ChaincodeStubInterface, args []string) pb.Response {
-    if len(args) != 0 {
-        return shim.Error(fmt.Sprintf("Incorrect number of arguments. Expecting 0 arguments, got %v", args))
+    if len(args) > 4 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 to 4 arguments (page_size, bookmark, name_prefix, with_balances), got %v", args)
+    }
+
+    err := check_permission(stub, "query_account_names", "query_account_names")
+    if err != nil {
+        return error_response(err)
     }
 
-    // only Admin is allowed to query_account_names
-    if !transactor_is_admin(stub) {
-        return shim.Error("Only admin user is authorized to query_account_names")
+    if len(args) > 0 {
+        return query_account_names_page(stub, args)
     }
 
     account_names,err := get_account_names_(stub)
     if err != nil {
-        return shim.Error(fmt.Sprintf("Could not query_account_names due to error %v", err.Error()))
+        return error_response(wrap_error(err, "Could not query_account_names due to error %v", err.Error()))
     }
 
     var bytes []byte
@@ -420,6 +1206,99 @@ func (t *SimpleChaincode) query_account_names (stub shim.ChaincodeStubInterface,
     return shim.Success(bytes)
 }
 
+func query_account_names_page (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    page_size,err := strconv.Atoi(args[0])
+    if err != nil || page_size < 1 || page_size > MAX_ACCOUNT_NAMES_PAGE_SIZE {
+        return coded_error_response(INVALID_PARAMS, "Invalid page_size \"%s\"; expecting integer between 1 and %d", args[0], MAX_ACCOUNT_NAMES_PAGE_SIZE)
+    }
+    bookmark := ""
+    if len(args) >= 2 {
+        bookmark = args[1]
+    }
+    name_prefix := ""
+    if len(args) >= 3 {
+        name_prefix = args[2]
+    }
+    with_balances := false
+    if len(args) == 4 {
+        with_balances,err = strconv.ParseBool(args[3])
+        if err != nil {
+            return coded_error_response(INVALID_PARAMS, "Invalid with_balances \"%s\"; expecting \"true\" or \"false\"", args[3])
+        }
+    }
+
+    page,err := get_account_names_page_(stub, name_prefix, bookmark, page_size, with_balances)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not query_account_names due to error %v", err.Error()))
+    }
+
+    bytes,err := json.Marshal(page)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing account names failed in query_account_names because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// Binds an existing account to the identity of its holder.  This is the migration path for accounts created
+// before ownership was bound to an Identity, whose holder was implied by the account name.
+func (t *SimpleChaincode) set_account_owner (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 3; account_name, owner_msp_id and owner_cert_pem")
+    }
+
+    err := check_permission(stub, "set_account_owner", "set_account_owner")
+    if err != nil {
+        return error_response(err)
+    }
+
+    account_name := args[0]
+    owner,err := IdentityFromCertificatePEM(args[1], []byte(args[2]))
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid owner for account \"%s\"; %v", account_name, err.Error())
+    }
+
+    account,err := get_account_(stub, account_name)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not set_account_owner for account \"%s\"; error was %v", account_name, err))
+    }
+    event_data := &events.AccountOwnerSet{Account:account_name, Owner:events.Identity(*owner)}
+    if account.Owner != nil {
+        old_owner := events.Identity(*account.Owner)
+        event_data.OldOwner = &old_owner
+    }
+    account.Owner = owner
+    err = overwrite_account_(stub, account)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not set_account_owner for account \"%s\"; error was %v", account_name, err))
+    }
+
+    err = emit_event(stub, events.ACCOUNT_OWNER_SET, event_data)
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Query the identity of the transactor.  A prospective account holder can use this to learn the identity
+// that the admin should bind their account to.
+func (t *SimpleChaincode) query_transactor_identity (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 arguments, got %v", args)
+    }
+
+    transactor,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(transactor)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing identity failed in query_transactor_identity because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
 func main() {
     err := shim.Start(new(SimpleChaincode))
     if err != nil {
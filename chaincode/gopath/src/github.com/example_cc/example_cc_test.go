@@ -0,0 +1,510 @@
+package main
+
+import (
+    "encoding/json"
+    "encoding/pem"
+    "fmt"
+    "github.com/example_cc/events"
+    "github.com/example_cc/memstub"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "strings"
+    "testing"
+)
+
+func new_chaincode () shim.Chaincode {
+    return new(SimpleChaincode)
+}
+
+// Each file in testdata/scenarios is a scripted sequence of Init/Invoke calls made as various
+// identities; see memstub.Scenario for the format.
+func TestScenarios (t *testing.T) {
+    memstub.RunScenarioFiles(t, "testdata/scenarios/*.json", new_chaincode)
+}
+
+func serialize_identity (t *testing.T, msp_id string, id_bytes []byte) []byte {
+    serialized, err := memstub.SerializeIdentity(msp_id, id_bytes)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return serialized
+}
+
+// A malformed creator must produce an error response carrying a stable code rather than a panic.
+func TestMalformedCreators (t *testing.T) {
+    msp, err := memstub.NewMSP("Org0MSP")
+    if err != nil {
+        t.Fatal(err)
+    }
+    admin, err := msp.NewIdentity("Admin")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    not_a_certificate := pem.EncodeToMemory(&pem.Block{Type:"PUBLIC KEY", Bytes:[]byte("not a key either")})
+    garbage_certificate := pem.EncodeToMemory(&pem.Block{Type:"CERTIFICATE", Bytes:[]byte("not DER")})
+    cases := []struct {
+        name            string
+        creator         *memstub.Identity
+        expected_code   IdentityErrorCode
+    }{
+        {"empty creator",                 nil,                                                                                  CREATOR_EMPTY},
+        {"garbage proto bytes",           &memstub.Identity{Serialized:[]byte{0x0a, 0xff, 0x01}},                               CREATOR_MALFORMED},
+        {"non-PEM IdBytes",               &memstub.Identity{Serialized:serialize_identity(t, "Org0MSP", []byte("Admin"))},      CREATOR_NOT_PEM},
+        {"non-certificate PEM block",     &memstub.Identity{Serialized:serialize_identity(t, "Org0MSP", not_a_certificate)},    CREATOR_NOT_CERTIFICATE},
+        {"malformed certificate",         &memstub.Identity{Serialized:serialize_identity(t, "Org0MSP", garbage_certificate)},  CREATOR_CERT_MALFORMED},
+    }
+    for _,c := range cases {
+        c := c
+        t.Run(c.name, func(t *testing.T) {
+            expected_prefix := fmt.Sprintf("[%s] ", c.expected_code)
+            stub := memstub.NewMemStub("malformed_creators", new_chaincode())
+
+            response := stub.MockInit("init_bad", c.creator, memstub.StringArgs("init"))
+            if response.Status != shim.ERROR || !strings.HasPrefix(response.Message, expected_prefix) {
+                t.Errorf("Init: expected error with prefix \"%s\", got status %d and message \"%s\"", expected_prefix, response.Status, response.Message)
+            }
+
+            response = stub.MockInit("init", admin, memstub.StringArgs("init"))
+            if response.Status != shim.OK {
+                t.Fatalf("Init as admin failed; %s", response.Message)
+            }
+            for _,args := range [][]string{
+                {"create_account", "Alice", "10"},
+                {"transfer", "Alice", "Bob", "1"},
+                {"delete_account", "Alice"},
+                {"query_balance", "Alice"},
+                {"query_account_names"},
+            } {
+                response = stub.MockInvoke("invoke", c.creator, memstub.StringArgs(args...))
+                if response.Status != shim.ERROR || !strings.HasPrefix(response.Message, expected_prefix) {
+                    t.Errorf("%s: expected error with prefix \"%s\", got status %d and message \"%s\"", args[0], expected_prefix, response.Status, response.Message)
+                }
+            }
+        })
+    }
+}
+
+// The function registry is what describe_api reports, so it must agree with the permission checks and events.
+func TestFunctionRegistry (t *testing.T) {
+    names := make(map[string]bool)
+    permissions := make(map[string]bool)
+    for _,spec := range function_registry {
+        if names[spec.Name] {
+            t.Errorf("%s: registered more than once", spec.Name)
+        }
+        names[spec.Name] = true
+        if spec.handler == nil || spec.Description == "" || (spec.Authorization == "" && spec.Permission == "") {
+            t.Errorf("%s: missing handler, Description or Authorization", spec.Name)
+        }
+        if spec.Permission != "" {
+            if _,ok := default_function_roles[spec.Permission]; !ok {
+                t.Errorf("%s: Permission \"%s\" is not permission-checked", spec.Name, spec.Permission)
+            }
+            permissions[spec.Permission] = true
+        }
+        if spec.Event != "" && !events.IsKnownType(spec.Event) {
+            t.Errorf("%s: unknown Event \"%s\"", spec.Name, spec.Event)
+        }
+        if spec.Event != "" && !spec.Mutates {
+            t.Errorf("%s: emits an event but doesn't mutate", spec.Name)
+        }
+        if spec.Mutates && spec.Event == "" {
+            t.Errorf("%s: mutates but emits no event", spec.Name)
+        }
+    }
+    for function := range default_function_roles {
+        if !permissions[function] {
+            t.Errorf("%s: permission-checked but no registered function has it as its Permission", function)
+        }
+    }
+}
+
+// describe_api reports the roles currently required by each function, rather than the defaults.
+func TestDescribeAPIRequiredRoles (t *testing.T) {
+    msp, err := memstub.NewMSP("Org0MSP")
+    if err != nil {
+        t.Fatal(err)
+    }
+    admin, err := msp.NewIdentity("Admin")
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub := memstub.NewMemStub("describe_api_required_roles", new_chaincode())
+    transfer_description := func () *FunctionDescription {
+        response := stub.MockInvoke("tx_describe_api", admin, memstub.StringArgs("describe_api"))
+        if response.Status != shim.OK {
+            t.Fatalf("describe_api failed; %s", response.Message)
+        }
+        var description APIDescription
+        if err := json.Unmarshal(response.Payload, &description); err != nil {
+            t.Fatal(err)
+        }
+        for i := range description.Functions {
+            if description.Functions[i].Name == "transfer" {
+                return &description.Functions[i]
+            }
+        }
+        t.Fatalf("describe_api doesn't describe transfer")
+        return nil
+    }
+
+    response := stub.MockInit("init", admin, memstub.StringArgs("init"))
+    if response.Status != shim.OK {
+        t.Fatalf("Init failed; %s", response.Message)
+    }
+    description := transfer_description()
+    if fmt.Sprint(description.RequiredRoles) != fmt.Sprint([]string{TREASURER_ROLE}) || description.Authorization != "the admin, anyone with one of the roles [treasurer], and the holder of from_account_name" {
+        t.Errorf("expected transfer to require the default roles, got %+v", description)
+    }
+
+    for _,c := range []struct {
+        roles                   string
+        expected_authorization  string
+    }{
+        {`["auditor","keeper"]`, "the admin, anyone with one of the roles [auditor keeper], and the holder of from_account_name"},
+        {`[]`,                   "the admin and the holder of from_account_name"},
+    } {
+        response = stub.MockInvoke("tx_set_function_roles", admin, memstub.StringArgs("set_function_roles", "transfer", c.roles))
+        if response.Status != shim.OK {
+            t.Fatalf("set_function_roles failed; %s", response.Message)
+        }
+        description = transfer_description()
+        roles, err := json.Marshal(description.RequiredRoles)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if string(roles) != c.roles || description.Authorization != c.expected_authorization {
+            t.Errorf("expected transfer to require the roles %s, got %+v", c.roles, description)
+        }
+    }
+}
+
+// A ledger instantiated before SchemaVersion existed, with Account rows stored before Status and Held existed,
+// is migrated by run_migrations after an upgrade, and can't be changed until the migration is complete.
+func TestSchemaMigration (t *testing.T) {
+    msp, err := memstub.NewMSP("Org0MSP")
+    if err != nil {
+        t.Fatal(err)
+    }
+    admin, err := msp.NewIdentity("Admin")
+    if err != nil {
+        t.Fatal(err)
+    }
+    upgrader, err := msp.NewIdentity("Upgrader")
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub := memstub.NewMemStub("schema_migration", new_chaincode())
+    invoke := func (creator *memstub.Identity, args ...string) pb.Response {
+        return stub.MockInvoke(fmt.Sprintf("tx_%s", args[0]), creator, memstub.StringArgs(args...))
+    }
+    schema_status := func () *SchemaStatus {
+        response := invoke(admin, "query_schema_version")
+        if response.Status != shim.OK {
+            t.Fatalf("query_schema_version failed; %s", response.Message)
+        }
+        var status SchemaStatus
+        if err := json.Unmarshal(response.Payload, &status); err != nil {
+            t.Fatal(err)
+        }
+        return &status
+    }
+
+    response := stub.MockInit("init", admin, memstub.StringArgs("init"))
+    if response.Status != shim.OK {
+        t.Fatalf("Init failed; %s", response.Message)
+    }
+    if status := schema_status(); status.Version != CURRENT_SCHEMA_VERSION || len(status.PendingMigrations) != 0 {
+        t.Fatalf("expected a new ledger to have the current schema version, got %+v", status)
+    }
+
+    // Turn the ledger into one written by an older version of the chaincode.
+    schema_key, err := util.TableRowKey(stub, CONFIG_TABLE, []string{"SchemaVersion"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.PutCommittedState(schema_key, nil)
+    for _,name := range []string{"Alice", "Bob", "Carol"} {
+        account_key, err := util.TableRowKey(stub, ACCOUNT_TABLE, []string{name})
+        if err != nil {
+            t.Fatal(err)
+        }
+        stub.PutCommittedState(account_key, []byte(fmt.Sprintf(`{"Name":"%s","Balance":10}`, name)))
+    }
+
+    // The upgrade keeps the admin, even though it is made by another identity.
+    response = stub.MockInit("upgrade", upgrader, memstub.StringArgs("init"))
+    if response.Status != shim.OK {
+        t.Fatalf("Init for upgrade failed; %s", response.Message)
+    }
+    if status := schema_status(); status.Version != 1 || len(status.PendingMigrations) != CURRENT_SCHEMA_VERSION-1 {
+        t.Fatalf("expected an upgraded ledger to have schema version 1 and every migration pending, got %+v", status)
+    }
+    response = invoke(admin, "transfer", "Alice", "Bob", "1")
+    if response.Status != shim.ERROR || !strings.Contains(response.Message, "Ledger data is being migrated from schema version 1") {
+        t.Fatalf("expected transfer to be refused during the migration, got status %d and message \"%s\"", response.Status, response.Message)
+    }
+    response = invoke(upgrader, "run_migrations", "2")
+    if response.Status != shim.ERROR || !strings.Contains(response.Message, "is not authorized to run_migrations") {
+        t.Fatalf("expected run_migrations by a non-admin to be refused, got status %d and message \"%s\"", response.Status, response.Message)
+    }
+
+    response = invoke(admin, "run_migrations", "2")
+    if response.Status != shim.OK {
+        t.Fatalf("run_migrations failed; %s", response.Message)
+    }
+    if status := schema_status(); status.Version != 1 || status.RowsMigrated != 2 || len(status.Bookmark) != 1 || status.Bookmark[0] != "Carol" {
+        t.Fatalf("expected the migration to stop at Carol after 2 rows, got %+v", status)
+    }
+    response = invoke(admin, "run_migrations", "2")
+    if response.Status != shim.OK {
+        t.Fatalf("run_migrations failed; %s", response.Message)
+    }
+    if status := schema_status(); status.Version != 2 || status.RowsMigrated != 0 || len(status.Bookmark) != 0 {
+        t.Fatalf("expected the migration to schema version 2 to be complete, got %+v", status)
+    }
+    // The later migrations are run in default-sized batches; each call completes one of them.
+    for i := 2; i < CURRENT_SCHEMA_VERSION; i++ {
+        response = invoke(admin, "run_migrations")
+        if response.Status != shim.OK {
+            t.Fatalf("run_migrations failed; %s", response.Message)
+        }
+    }
+    if status := schema_status(); status.Version != CURRENT_SCHEMA_VERSION || len(status.PendingMigrations) != 0 {
+        t.Fatalf("expected the migration to be complete, got %+v", status)
+    }
+    for _,name := range []string{"Alice", "Bob", "Carol"} {
+        account_key, err := util.TableRowKey(stub, ACCOUNT_TABLE, []string{name})
+        if err != nil {
+            t.Fatal(err)
+        }
+        var account map[string]interface{}
+        if err := json.Unmarshal(stub.CommittedState(account_key), &account); err != nil {
+            t.Fatal(err)
+        }
+        if account["Status"] != ACCOUNT_ACTIVE || account["Held"] != "0" {
+            t.Errorf("expected account %s to be migrated, got %v", name, account)
+        }
+    }
+    response = invoke(admin, "query_accounts_by_index", "Status", ACCOUNT_ACTIVE)
+    if response.Status != shim.OK {
+        t.Fatalf("query_accounts_by_index failed; %s", response.Message)
+    }
+    var accounts []Account
+    if err := json.Unmarshal(response.Payload, &accounts); err != nil {
+        t.Fatal(err)
+    }
+    if len(accounts) != 3 {
+        t.Errorf("expected the migration to index the 3 accounts by status, got %s", string(response.Payload))
+    }
+
+    response = invoke(admin, "transfer", "Alice", "Bob", "1")
+    if response.Status != shim.OK {
+        t.Fatalf("transfer after the migration failed; %s", response.Message)
+    }
+}
+
+// A ledger written by the baseline chaincode has an Admin row without an Identity, so the transactor of the
+// upgrade becomes the admin and can migrate the ledger.
+func TestLegacyAdminUpgrade (t *testing.T) {
+    msp, err := memstub.NewMSP("Org0MSP")
+    if err != nil {
+        t.Fatal(err)
+    }
+    upgrader, err := msp.NewIdentity("Admin")
+    if err != nil {
+        t.Fatal(err)
+    }
+    other, err := msp.NewIdentity("Other")
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub := memstub.NewMemStub("legacy_admin_upgrade", new_chaincode())
+    invoke := func (creator *memstub.Identity, args ...string) pb.Response {
+        return stub.MockInvoke(fmt.Sprintf("tx_%s", args[0]), creator, memstub.StringArgs(args...))
+    }
+
+    admin_key, err := util.TableRowKey(stub, CONFIG_TABLE, []string{"Admin"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.PutCommittedState(admin_key, []byte(`{"Name":"Admin"}`))
+    for _,name := range []string{"Alice", "Bob"} {
+        account_key, err := util.TableRowKey(stub, ACCOUNT_TABLE, []string{name})
+        if err != nil {
+            t.Fatal(err)
+        }
+        stub.PutCommittedState(account_key, []byte(fmt.Sprintf(`{"Name":"%s","Balance":10}`, name)))
+    }
+
+    // An upgrader whose common name isn't that of the legacy admin can't take over as admin.
+    response := stub.MockInit("upgrade_by_other", other, memstub.StringArgs("init"))
+    if response.Status != shim.ERROR || !strings.Contains(response.Message, "the ledger's admin \"Admin\" has no Identity, so only they may upgrade the chaincode") {
+        t.Fatalf("expected Init for upgrade by a non-admin to be refused, got status %d and message \"%s\"", response.Status, response.Message)
+    }
+    response = stub.MockInit("upgrade", upgrader, memstub.StringArgs("init"))
+    if response.Status != shim.OK {
+        t.Fatalf("Init for upgrade failed; %s", response.Message)
+    }
+    response = invoke(other, "run_migrations")
+    if response.Status != shim.ERROR || !strings.Contains(response.Message, "is not authorized to run_migrations") {
+        t.Fatalf("expected run_migrations by a non-admin to be refused, got status %d and message \"%s\"", response.Status, response.Message)
+    }
+    for i := 1; i < CURRENT_SCHEMA_VERSION; i++ {
+        response = invoke(upgrader, "run_migrations")
+        if response.Status != shim.OK {
+            t.Fatalf("run_migrations by the upgrader failed; %s", response.Message)
+        }
+    }
+    response = invoke(upgrader, "transfer", "Alice", "Bob", "1")
+    if response.Status != shim.OK {
+        t.Fatalf("transfer after the migration failed; %s", response.Message)
+    }
+}
+
+// check_account_index finds index entries left missing or stale, e.g. by a row written twice in a transaction,
+// and rebuilds the index when asked to.
+func TestAccountIndexRepair (t *testing.T) {
+    msp, err := memstub.NewMSP("Org0MSP")
+    if err != nil {
+        t.Fatal(err)
+    }
+    admin, err := msp.NewIdentity("Admin")
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub := memstub.NewMemStub("account_index_repair", new_chaincode())
+    invoke := func (args ...string) pb.Response {
+        response := stub.MockInvoke(fmt.Sprintf("tx_%s", args[0]), admin, memstub.StringArgs(args...))
+        if response.Status != shim.OK {
+            t.Fatalf("%s failed; %s", args[0], response.Message)
+        }
+        return response
+    }
+    check := func (repair string) *util.IndexCheckReport {
+        var report util.IndexCheckReport
+        if err := json.Unmarshal(invoke("check_account_index", "Status", repair).Payload, &report); err != nil {
+            t.Fatal(err)
+        }
+        return &report
+    }
+
+    response := stub.MockInit("init", admin, memstub.StringArgs("init"))
+    if response.Status != shim.OK {
+        t.Fatalf("Init failed; %s", response.Message)
+    }
+    invoke("create_account", "Alice", "10")
+    invoke("create_account", "Bob", "10")
+
+    // Replace Alice's entry with one which claims she is frozen.
+    entry_key, err := stub.CreateCompositeKey(ACCOUNT_TABLE + "~Status", []string{ACCOUNT_ACTIVE, "Alice"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.PutCommittedState(entry_key, nil)
+    stale_entry_key, err := stub.CreateCompositeKey(ACCOUNT_TABLE + "~Status", []string{ACCOUNT_FROZEN, "Alice"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.PutCommittedState(stale_entry_key, []byte(`["Alice"]`))
+
+    if payload := string(invoke("query_accounts_by_index", "Status", ACCOUNT_FROZEN).Payload); payload != "[]" {
+        t.Errorf("expected the stale entry to be skipped, got %s", payload)
+    }
+    report := check("false")
+    if report.RowsChecked != 2 || len(report.MissingEntries) != 1 || report.MissingEntries[0][0] != "Alice" || len(report.StaleEntries) != 1 || report.StaleEntries[0][0] != "Alice" {
+        t.Fatalf("expected one missing and one stale entry for Alice, got %+v", report)
+    }
+    if report = check("false"); len(report.MissingEntries) != 1 || len(report.StaleEntries) != 1 {
+        t.Fatalf("expected a check without repair to change nothing, got %+v", report)
+    }
+    check("true")
+    if report = check("false"); len(report.MissingEntries) != 0 || len(report.StaleEntries) != 0 {
+        t.Fatalf("expected the repair to rebuild the index, got %+v", report)
+    }
+    if stub.CommittedState(stale_entry_key) != nil || stub.CommittedState(entry_key) == nil {
+        t.Errorf("expected the repair to replace the stale entry with the missing one")
+    }
+}
+
+// Adapts a function to shim.Chaincode, so that util code can be run in memstub transactions.
+type chaincode_func func (stub shim.ChaincodeStubInterface) pb.Response
+
+func (f chaincode_func) Init (stub shim.ChaincodeStubInterface) pb.Response {
+    return f(stub)
+}
+
+func (f chaincode_func) Invoke (stub shim.ChaincodeStubInterface) pb.Response {
+    return f(stub)
+}
+
+// util.Table reports missing and existing rows with its sentinel errors and refuses rows of the wrong type.
+func TestTable (t *testing.T) {
+    var step func (stub shim.ChaincodeStubInterface) error
+    stub := memstub.NewMemStub("table", chaincode_func(func (stub shim.ChaincodeStubInterface) pb.Response {
+        if err := step(stub); err != nil {
+            return shim.Error(err.Error())
+        }
+        return shim.Success(nil)
+    }))
+    // Each step is its own transaction, since a transaction doesn't see its own writes.
+    run := func (f func (stub shim.ChaincodeStubInterface) error) {
+        step = f
+        if response := stub.MockInvoke("tx", nil, nil); response.Status != shim.OK {
+            t.Fatal(response.Message)
+        }
+    }
+    alice := &Account{Name:"Alice", Status:ACCOUNT_ACTIVE}
+
+    run(func (stub shim.ChaincodeStubInterface) error {
+        if err := account_table.Update(stub, alice); !util.IsNotFound(err) {
+            t.Errorf("expected Update of a missing row to fail with ErrNotFound, got %v", err)
+        }
+        if err := account_table.Insert(stub, &Asset{Symbol:"GOLD"}); err == nil || util.IsNotFound(err) || util.IsAlreadyExists(err) {
+            t.Errorf("expected Insert of a row of the wrong type to fail, got %v", err)
+        }
+        return account_table.Insert(stub, alice)
+    })
+    run(func (stub shim.ChaincodeStubInterface) error {
+        if err := account_table.Insert(stub, alice); !util.IsAlreadyExists(err) || !strings.Contains(err.Error(), "row existed already") {
+            t.Errorf("expected Insert of an existing row to fail with ErrAlreadyExists, got %v", err)
+        }
+        var account Account
+        if err := account_table.Get(stub, []string{"Bob"}, &account); !util.IsNotFound(err) || !strings.Contains(err.Error(), "row with keys [Bob] does not exist") {
+            t.Errorf("expected Get of a missing row to fail with ErrNotFound, got %v", err)
+        }
+        if err := account_table.Get(stub, []string{"Alice"}, &account); err != nil || account.Name != "Alice" {
+            t.Errorf("expected Get to read Alice, got %+v and error %v", account, err)
+        }
+        var old_account Account
+        row_was_found, err := account_table.Upsert(stub, &Account{Name:"Alice", Status:ACCOUNT_FROZEN}, &old_account)
+        if err != nil || !row_was_found || old_account.Status != ACCOUNT_ACTIVE {
+            t.Errorf("expected Upsert to replace the active Alice, got %v, %+v and error %v", row_was_found, old_account, err)
+        }
+        _, err = account_table.Upsert(stub, &Account{Name:"Bob", Status:ACCOUNT_ACTIVE}, nil)
+        return err
+    })
+    run(func (stub shim.ChaincodeStubInterface) error {
+        var accounts []Account
+        if err := account_table.Scan(stub, []string{}, &accounts); err != nil || len(accounts) != 2 || accounts[0].Status != ACCOUNT_FROZEN || accounts[1].Name != "Bob" {
+            t.Errorf("expected Scan to give the frozen Alice and Bob, got %+v and error %v", accounts, err)
+        }
+        var assets []Asset
+        if err := account_table.Scan(stub, []string{}, &assets); err == nil {
+            t.Errorf("expected Scan into a slice of the wrong type to fail")
+        }
+        var deleted Account
+        if err := account_table.Delete(stub, []string{"Alice"}, &deleted); err != nil || deleted.Name != "Alice" {
+            t.Errorf("expected Delete to read the deleted Alice, got %+v and error %v", deleted, err)
+        }
+        return nil
+    })
+    run(func (stub shim.ChaincodeStubInterface) error {
+        if err := account_table.Delete(stub, []string{"Alice"}, nil); !util.IsNotFound(err) {
+            t.Errorf("expected Delete of a missing row to fail with ErrNotFound, got %v", err)
+        }
+        return nil
+    })
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/decimal"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "strconv"
+)
+
+//
+// transfer fees
+//
+// Each transfer of the default asset made by move_with_fee_ (i.e. by transfer, transfer_from, batch_transfer,
+// release_hold and standing orders) is charged the fee given by the FeeSchedule row in CONFIG_TABLE.  The fee
+// is paid by the "from" account on top of the amount transferred, is credited to the fee collector account,
+// and counts towards the transfer limits.  Transfers from the fee collector and transfers within one account
+// are free.  The fee collector can't be frozen, closed or deleted, so that it can always be credited.
+//
+
+const MAX_FEE_BASIS_POINTS = 10000
+
+type FeeSchedule struct {
+    // Charged on every transfer.
+    FlatFee     decimal.Amount  `json:"FlatFee"`
+    // Charged per 10000 units transferred, rounded down to the ledger's number of decimals.
+    BasisPoints int64           `json:"BasisPoints"`
+    // The account credited with the fees; empty if there are no fees.
+    Collector   string          `json:"Collector"`
+}
+
+// The fee charged on a transfer.
+type TransferFee struct {
+    Amount      decimal.Amount  `json:"Amount"`
+    Collector   string          `json:"Collector"`
+}
+
+// The payload of transfer and transfer_from.
+type TransferResult struct {
+    FromAccount string          `json:"FromAccount"`
+    ToAccount   string          `json:"ToAccount"`
+    Asset       string          `json:"Asset,omitempty"`
+    Amount      decimal.Amount  `json:"Amount"`
+    // Nil if the transfer was free.
+    Fee         *TransferFee    `json:"Fee,omitempty"`
+}
+
+func (result *TransferResult) event_data () *events.Transferred {
+    event_data := &events.Transferred{FromAccount:result.FromAccount, ToAccount:result.ToAccount, Asset:result.Asset, Amount:result.Amount.String()}
+    if result.Fee != nil {
+        event_data.Fee = result.Fee.Amount.String()
+        event_data.FeeCollector = result.Fee.Collector
+    }
+    return event_data
+}
+
+var fee_schedule_table = util.NewTable(CONFIG_TABLE, FeeSchedule{}, util.FixedRowKeys("FeeSchedule"), nil)
+
+// Raw form of function which does no permissions checking.  A ledger whose schedule was never set has no fees.
+func get_fee_schedule_ (stub shim.ChaincodeStubInterface) (*FeeSchedule, error) {
+    decimals,err := get_ledger_decimals(stub)
+    if err != nil {
+        return nil, err
+    }
+    schedule := FeeSchedule{FlatFee:decimal.Zero(decimals)}
+    err = fee_schedule_table.Get(stub, fee_schedule_table.RowKeys(&schedule), &schedule)
+    if err != nil && !util.IsNotFound(err) {
+        return nil, wrap_error(err, "Could not retrieve FeeSchedule; error was %v", err.Error())
+    }
+    return &schedule, nil
+}
+
+// Raw form of function which does no permissions checking
+func set_fee_schedule_ (stub shim.ChaincodeStubInterface, schedule *FeeSchedule) error {
+    _,err := fee_schedule_table.Upsert(stub, schedule, nil)
+    if err != nil {
+        return wrap_error(err, "Error setting %s FeeSchedule value to %v; error was %v", CONFIG_TABLE, schedule, err.Error())
+    }
+    return nil
+}
+
+// Raw form of function which does no permissions checking.  Returns the fee for transferring amount from the
+// account named from_account_name to that named to_account_name, or nil if the transfer is free.
+func get_transfer_fee_ (stub shim.ChaincodeStubInterface, from_account_name string, to_account_name string, amount decimal.Amount) (*TransferFee, error) {
+    schedule,err := get_fee_schedule_(stub)
+    if err != nil {
+        return nil, err
+    }
+    if schedule.Collector == "" || from_account_name == schedule.Collector || from_account_name == to_account_name {
+        return nil, nil
+    }
+    fee,err := amount.MulDiv(schedule.BasisPoints, MAX_FEE_BASIS_POINTS)
+    if err != nil {
+        return nil, err
+    }
+    fee,err = fee.Add(schedule.FlatFee)
+    if err != nil {
+        return nil, err
+    }
+    if fee.Sign() == 0 {
+        return nil, nil
+    }
+    return &TransferFee{Amount:fee, Collector:schedule.Collector}, nil
+}
+
+// Returns an error if the account can't be deleted, closed or frozen because it is the fee collector.
+func check_account_is_not_fee_collector (stub shim.ChaincodeStubInterface, account_name string) error {
+    schedule,err := get_fee_schedule_(stub)
+    if err != nil {
+        return err
+    }
+    if schedule.Collector == account_name {
+        return fmt.Errorf("it is the fee collector; another fee collector must be set first")
+    }
+    return nil
+}
+
+//
+// fee related chaincode API functions
+//
+
+// Sets the fee schedule.  Args are flat_fee, basis_points (an integer between 0 and 10000) and
+// collector_account_name, which may only be empty if both fees are zero.
+func (t *SimpleChaincode) set_fee_schedule (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 3; flat_fee, basis_points and collector_account_name")
+    }
+
+    // only Admin is allowed to set_fee_schedule
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to set_fee_schedule")
+    }
+
+    flat_fee,err := parse_amount(stub, args[0])
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid flat_fee \"%s\"; %v", args[0], err.Error())
+    }
+    if flat_fee.Sign() < 0 {
+        return coded_error_response(INVALID_PARAMS, "Invalid flat_fee %v; expecting non-negative amount", flat_fee)
+    }
+    basis_points,err := strconv.ParseInt(args[1], 10, 64)
+    if err != nil || basis_points < 0 || basis_points > MAX_FEE_BASIS_POINTS {
+        return coded_error_response(INVALID_PARAMS, "Invalid basis_points \"%s\"; expecting integer between 0 and %d", args[1], MAX_FEE_BASIS_POINTS)
+    }
+    collector_account_name := args[2]
+    if collector_account_name == "" {
+        if flat_fee.Sign() != 0 || basis_points != 0 {
+            return shim.Error("A collector_account_name is required unless both fees are zero")
+        }
+    } else {
+        collector_account,err := get_account_(stub, collector_account_name)
+        if err != nil {
+            return error_response(wrap_error(err, "Error in retrieving fee collector account \"%s\"; %v", collector_account_name, err.Error()))
+        }
+        err = check_account_is_active(collector_account)
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    schedule := &FeeSchedule{FlatFee:flat_fee, BasisPoints:basis_points, Collector:collector_account_name}
+    err = set_fee_schedule_(stub, schedule)
+    if err != nil {
+        return error_response(err)
+    }
+
+    err = emit_event(stub, events.FEE_SCHEDULE_SET, &events.FeeScheduleSet{FlatFee:flat_fee.String(), BasisPoints:basis_points, Collector:collector_account_name})
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Query the fee schedule.  Anyone may query it, since it determines what transfers cost.
+func (t *SimpleChaincode) query_fee_schedule (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 arguments, got %v", args)
+    }
+
+    schedule,err := get_fee_schedule_(stub)
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(schedule)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing fee schedule failed in query_fee_schedule because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/decimal"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "strconv"
+    "time"
+)
+
+//
+// account history
+//
+
+const (
+    DEFAULT_HISTORY_PAGE_SIZE   = 100
+    MAX_HISTORY_PAGE_SIZE       = 1000
+)
+
+// One modification of an account row.  For a deletion, Balance is 0.
+type AccountHistoryEntry struct {
+    TxID        string          `json:"TxID"`
+    Timestamp   time.Time       `json:"Timestamp"`
+    Balance     decimal.Amount  `json:"Balance"`
+    IsDelete    bool            `json:"IsDelete"`
+}
+
+// Bookmark is empty if there are no further entries; otherwise it is passed to query_account_history to get
+// the next page.
+type AccountHistoryPage struct {
+    Entries     []AccountHistoryEntry   `json:"Entries"`
+    Bookmark    string                  `json:"Bookmark"`
+}
+
+// Returns up to page_size history entries of the named account, oldest first, starting after skipping the
+// first skip entries.  The history includes modifications made before the account was deleted, if it was.
+func get_account_history_ (stub shim.ChaincodeStubInterface, account_name string, skip int, page_size int) (*AccountHistoryPage, error) {
+    key,err := util.TableRowKey(stub, ACCOUNT_TABLE, row_keys_of_Account(&Account{Name:account_name}))
+    if err != nil {
+        return nil, err
+    }
+    history_iterator,err := stub.GetHistoryForKey(key)
+    if err != nil {
+        return nil, fmt.Errorf("stub.GetHistoryForKey failed with error %v", err)
+    }
+    defer history_iterator.Close()
+
+    decimals,err := get_ledger_decimals(stub)
+    if err != nil {
+        return nil, err
+    }
+
+    page := &AccountHistoryPage{Entries:[]AccountHistoryEntry{}}
+    for index := 0; history_iterator.HasNext(); index++ {
+        modification,err := history_iterator.Next()
+        if err != nil {
+            return nil, fmt.Errorf("Iterating over history failed with error %v", err)
+        }
+        if index < skip {
+            continue
+        }
+        if len(page.Entries) == page_size {
+            page.Bookmark = strconv.Itoa(index)
+            break
+        }
+
+        entry := AccountHistoryEntry{TxID:modification.TxId, Balance:decimal.Zero(decimals), IsDelete:modification.IsDelete}
+        if modification.Timestamp != nil {
+            entry.Timestamp = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC()
+        }
+        if !modification.IsDelete {
+            var account Account
+            err = json.Unmarshal(modification.Value, &account)
+            if err != nil {
+                return nil, fmt.Errorf("json.Unmarshal of \"%s\" failed with error %v", string(modification.Value), err)
+            }
+            err = normalize_account_(&account, decimals)
+            if err != nil {
+                return nil, err
+            }
+            entry.Balance = account.Balance
+        }
+        page.Entries = append(page.Entries, entry)
+    }
+    return page, nil
+}
+
+// Query the history of an account's balance.  Args are account_name and optionally page_size and bookmark
+// (as returned in the previous page); an empty page_size denotes the default.  This has the same authorization
+// as query_balance.
+func (t *SimpleChaincode) query_account_history (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) < 1 || len(args) > 3 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1 to 3; account_name, and optionally page_size and bookmark")
+    }
+
+    account_name := args[0]
+    page_size := DEFAULT_HISTORY_PAGE_SIZE
+    if len(args) >= 2 && args[1] != "" {
+        var err error
+        page_size,err = strconv.Atoi(args[1])
+        if err != nil || page_size < 1 || page_size > MAX_HISTORY_PAGE_SIZE {
+            return coded_error_response(INVALID_PARAMS, "Invalid page_size \"%s\"; expecting integer between 1 and %d", args[1], MAX_HISTORY_PAGE_SIZE)
+        }
+    }
+    skip := 0
+    if len(args) == 3 && args[2] != "" {
+        var err error
+        skip,err = strconv.Atoi(args[2])
+        if err != nil || skip < 0 {
+            return coded_error_response(INVALID_PARAMS, "Invalid bookmark \"%s\"", args[2])
+        }
+    }
+
+    // The account holder is allowed to query_account_history, as is anyone with permission to query_balance.
+    is_holder,err := transactor_is_account_owner(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_holder {
+        err = check_permission(stub, "query_balance", fmt.Sprintf("query history of account \"%s\"", account_name))
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    page,err := get_account_history_(stub, account_name, skip, page_size)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not query_account_history for account \"%s\"; error was %v", account_name, err.Error()))
+    }
+
+    bytes,err := json.Marshal(page)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing account history failed in query_account_history because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/decimal"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "time"
+)
+
+//
+// holds
+//
+// A hold reserves an amount of the default asset in the "from" account for a transfer to the "to" account, to
+// be settled later.  While the hold exists, the amount counts towards Account.Held, so it can't be spent
+// otherwise.  A hold is resolved by release_hold, which makes the transfer, or by cancel_hold or (once it has
+// expired) expire_holds, which return the amount to the available balance.  Only unresolved holds are stored,
+// as Hold rows keyed by the "from" account; the events record the rest.
+//
+
+const HOLD_TABLE = "HoldTable"
+
+type Hold struct {
+    // The ID of the create_hold transaction.
+    HoldID      string          `json:"HoldID"`
+    FromAccount string          `json:"FromAccount"`
+    ToAccount   string          `json:"ToAccount"`
+    Amount      decimal.Amount  `json:"Amount"`
+    // The hold can't be released at or after this time.
+    Expiry      time.Time       `json:"Expiry"`
+}
+
+func row_keys_of_Hold (hold *Hold) []string {
+    return []string{hold.FromAccount, hold.HoldID}
+}
+
+var hold_table = util.NewTable(HOLD_TABLE, Hold{}, func (row interface{}) []string { return row_keys_of_Hold(row.(*Hold)) }, nil)
+
+func (hold *Hold) event_data () events.Hold {
+    return events.Hold{HoldID:hold.HoldID, FromAccount:hold.FromAccount, ToAccount:hold.ToAccount, Amount:hold.Amount.String(), Expiry:hold.Expiry}
+}
+
+// Raw form of function which does no permissions checking
+func get_hold_ (stub shim.ChaincodeStubInterface, account_name string, hold_id string) (*Hold, error) {
+    hold := Hold{FromAccount:account_name, HoldID:hold_id}
+    err := hold_table.Get(stub, row_keys_of_Hold(&hold), &hold)
+    if util.IsNotFound(err) {
+        return nil, coded_error(NOT_FOUND, "Account \"%s\" has no hold \"%s\"; it may have been resolved already", account_name, hold_id)
+    }
+    if err != nil {
+        return nil, wrap_error(err, "Could not retrieve hold \"%s\" of account \"%s\"; error was %v", hold_id, account_name, err.Error())
+    }
+    return &hold, nil
+}
+
+// Raw form of function which does no permissions checking.  Returns every unresolved hold on the account.
+func get_holds_ (stub shim.ChaincodeStubInterface, account_name string) ([]Hold, error) {
+    var holds []Hold
+    err := hold_table.Scan(stub, []string{account_name}, &holds)
+    if err != nil {
+        return nil, wrap_error(err, "Could not get holds of account \"%s\"; %v", account_name, err.Error())
+    }
+    return holds, nil
+}
+
+// Raw form of function which does no permissions checking.  Deletes the hold and returns its amount to the
+// available balance of the "from" account, without writing the account.
+func resolve_hold_ (stub shim.ChaincodeStubInterface, account *Account, hold *Hold) error {
+    held,err := account.Held.Sub(hold.Amount)
+    if err != nil {
+        return err
+    }
+    if held.Sign() < 0 {
+        return fmt.Errorf("Held amount of account \"%s\" (%v) is less than the amount of hold \"%s\" (%v)", account.Name, account.Held, hold.HoldID, hold.Amount)
+    }
+    account.Held = held
+    return hold_table.Delete(stub, row_keys_of_Hold(hold), nil)
+}
+
+//
+// hold related chaincode API functions
+//
+
+// Reserves an amount in an account for a transfer to another account.  Args are from_account_name,
+// to_account_name, amount and expiry (an RFC 3339 time, e.g. "2017-01-31T00:00:00Z").  This has the same
+// authorization as transfer.  The payload is the created Hold, whose HoldID identifies it in release_hold and
+// cancel_hold.
+func (t *SimpleChaincode) create_hold (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 4 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 4; 2 names, 1 value and expiry")
+    }
+
+    from_account_name := args[0]
+    to_account_name := args[1]
+    amount,err := parse_amount(stub, args[2])
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid hold amount \"%s\"; %v", args[2], err.Error())
+    }
+    if amount.Sign() <= 0 {
+        return coded_error_response(INVALID_PARAMS, "Invalid hold amount %v; expecting positive amount", amount)
+    }
+    expiry,err := time.Parse(time.RFC3339, args[3])
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid expiry \"%s\"; expecting an RFC 3339 time such as \"2017-01-31T00:00:00Z\"", args[3])
+    }
+    tx_time,err := get_tx_time(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !expiry.After(tx_time) {
+        return coded_error_response(INVALID_PARAMS, "Invalid expiry %v; it must be later than the transaction time %v", expiry.UTC().Format(time.RFC3339), tx_time.Format(time.RFC3339))
+    }
+
+    // The account holder is allowed to create_hold, as is anyone with permission to transfer (e.g. Admin).
+    is_holder,err := transactor_is_account_owner(stub, from_account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_holder {
+        err = check_permission(stub, "transfer", fmt.Sprintf("transfer from account \"%s\"", from_account_name))
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    if from_account_name == to_account_name {
+        return shim.Error(fmt.Sprintf("Can't hold an amount of account \"%s\" for itself", from_account_name))
+    }
+    from_account,err := get_account_(stub, from_account_name)
+    if err != nil {
+        return error_response(wrap_error(err, "Error in retrieving \"from\" account \"%s\"; %v", from_account_name, err.Error()))
+    }
+    to_account,err := get_account_(stub, to_account_name)
+    if err != nil {
+        return error_response(wrap_error(err, "Error in retrieving \"to\" account \"%s\"; %v", to_account_name, err.Error()))
+    }
+    for _,account := range []*Account{from_account, to_account} {
+        err = check_account_is_active(account)
+        if err != nil {
+            return error_response(err)
+        }
+    }
+    available,err := from_account.available()
+    if err != nil {
+        return error_response(err)
+    }
+    if available.Cmp(amount) < 0 {
+        return shim.Error(fmt.Sprintf("Can't hold %v; the available balance of account \"%s\" is only %v", amount, from_account_name, available))
+    }
+    from_account.Held,err = from_account.Held.Add(amount)
+    if err != nil {
+        return error_response(err)
+    }
+    err = overwrite_account_(stub, from_account)
+    if err != nil {
+        return error_response(err)
+    }
+
+    hold := &Hold{HoldID:stub.GetTxID(), FromAccount:from_account_name, ToAccount:to_account_name, Amount:amount, Expiry:expiry.UTC()}
+    err = hold_table.Insert(stub, hold)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not create hold \"%s\"; error was %v", hold.HoldID, err.Error()))
+    }
+
+    event_data := hold.event_data()
+    err = emit_event(stub, events.HOLD_CREATED, &event_data)
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(hold)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing hold failed in create_hold because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// Settles an unexpired hold by transferring its amount.  Args are from_account_name and hold_id.  This has the
+// same authorization as transfer, and the transfer is subject to the transfer limits and fee when it is made.
+func (t *SimpleChaincode) release_hold (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 2; from_account_name and hold_id")
+    }
+
+    from_account_name := args[0]
+    hold_id := args[1]
+
+    // The account holder is allowed to release_hold, as is anyone with permission to transfer (e.g. Admin).
+    is_holder,err := transactor_is_account_owner(stub, from_account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_holder {
+        err = check_permission(stub, "transfer", fmt.Sprintf("transfer from account \"%s\"", from_account_name))
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    hold,err := get_hold_(stub, from_account_name, hold_id)
+    if err != nil {
+        return error_response(err)
+    }
+    tx_time,err := get_tx_time(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !tx_time.Before(hold.Expiry) {
+        return shim.Error(fmt.Sprintf("Hold \"%s\" expired at %v; it can only be cancelled or expired", hold_id, hold.Expiry.Format(time.RFC3339)))
+    }
+
+    // The hold is resolved first, so that its amount is available for the transfer and its fee.
+    cache := new_account_cache(stub)
+    from_account,err := cache.get(from_account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    err = resolve_hold_(stub, from_account, hold)
+    if err != nil {
+        return error_response(err)
+    }
+    tracker := new_outflow_tracker(stub)
+    fee,err := move_with_fee_(stub, cache, tracker, from_account_name, hold.ToAccount, hold.Amount)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not release hold \"%s\"; %v", hold_id, err.Error()))
+    }
+    err = cache.write()
+    if err != nil {
+        return error_response(err)
+    }
+    err = tracker.write()
+    if err != nil {
+        return error_response(err)
+    }
+
+    event_data := hold.event_data()
+    if fee != nil {
+        event_data.Fee = fee.Amount.String()
+        event_data.FeeCollector = fee.Collector
+    }
+    err = emit_event(stub, events.HOLD_RELEASED, &event_data)
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Resolves a hold without transferring its amount.  Args are from_account_name and hold_id.  Since the hold
+// protects the "to" account, the holder of the "to" account is allowed to cancel_hold, as is anyone with
+// permission to transfer (e.g. Admin), but the holder of the "from" account is not.
+func (t *SimpleChaincode) cancel_hold (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 2; from_account_name and hold_id")
+    }
+
+    from_account_name := args[0]
+    hold_id := args[1]
+    hold,err := get_hold_(stub, from_account_name, hold_id)
+    if err != nil {
+        return error_response(err)
+    }
+
+    is_payee,err := transactor_is_account_owner(stub, hold.ToAccount)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_payee {
+        err = check_permission(stub, "transfer", fmt.Sprintf("cancel hold \"%s\" of account \"%s\"", hold_id, from_account_name))
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    from_account,err := get_account_(stub, from_account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    err = resolve_hold_(stub, from_account, hold)
+    if err != nil {
+        return error_response(err)
+    }
+    err = overwrite_account_(stub, from_account)
+    if err != nil {
+        return error_response(err)
+    }
+
+    event_data := hold.event_data()
+    err = emit_event(stub, events.HOLD_CANCELLED, &event_data)
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Resolves every expired hold on an account without transferring its amount.  The single arg is account_name.
+// Anyone may call this, since it only resolves holds that can no longer be released.  The payload is a JSON
+// array of the expired holds.
+func (t *SimpleChaincode) expire_holds (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1; account_name")
+    }
+
+    account_name := args[0]
+    account,err := get_account_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    holds,err := get_holds_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    tx_time,err := get_tx_time(stub)
+    if err != nil {
+        return error_response(err)
+    }
+
+    expired_holds := []Hold{}
+    event_data := &events.HoldsExpired{Holds:[]events.Hold{}}
+    for i := range holds {
+        hold := &holds[i]
+        if tx_time.Before(hold.Expiry) {
+            continue
+        }
+        err = resolve_hold_(stub, account, hold)
+        if err != nil {
+            return error_response(err)
+        }
+        expired_holds = append(expired_holds, *hold)
+        event_data.Holds = append(event_data.Holds, hold.event_data())
+    }
+
+    if len(expired_holds) > 0 {
+        err = overwrite_account_(stub, account)
+        if err != nil {
+            return error_response(err)
+        }
+        err = emit_event(stub, events.HOLDS_EXPIRED, event_data)
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    bytes,err := json.Marshal(expired_holds)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing holds failed in expire_holds because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// Query the unresolved holds on an account, including expired ones that haven't been expired by expire_holds.
+// The single arg is account_name.  This has the same authorization as query_balance.
+func (t *SimpleChaincode) query_holds (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1; account_name")
+    }
+
+    account_name := args[0]
+
+    // The account holder is allowed to query_holds, as is anyone with permission to query_balance.
+    is_holder,err := transactor_is_account_owner(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_holder {
+        err = check_permission(stub, "query_balance", fmt.Sprintf("query holds of account \"%s\"", account_name))
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    holds,err := get_holds_(stub, account_name)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not query_holds for account \"%s\"; error was %v", account_name, err.Error()))
+    }
+
+    bytes,err := json.Marshal(holds)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing holds failed in query_holds because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+    "fmt"
+    "github.com/example_cc/events"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//
+// account lifecycle
+//
+
+// Raw form of function which does no permissions checking beyond that of sweep_account_.  Brings every
+// balance of the account to zero, either by sweeping them into the account named sweep_to_account_name or, if
+// that is empty, by requiring that they are zero already, and deletes the account's asset balance rows, the
+// allowances it granted, its transfer limits and the standing orders from it.  The Account row itself is left
+// for the caller to delete or overwrite.  Returns the data for the event recording the removal of the account.
+func empty_account_ (stub shim.ChaincodeStubInterface, account *Account, sweep_to_account_name string) (*events.AccountDeleted, error) {
+    err := check_account_is_not_fee_collector(stub, account.Name)
+    if err != nil {
+        return nil, err
+    }
+    if account.Held.Sign() != 0 {
+        return nil, fmt.Errorf("%v of its balance is held; its holds must be released, cancelled or expired first", account.Held)
+    }
+    asset_balances,err := get_asset_balances_(stub, account.Name)
+    if err != nil {
+        return nil, err
+    }
+
+    event := &events.AccountDeleted{Account:account.Name, FinalBalance:account.Balance.String(), SweptTo:sweep_to_account_name}
+    if len(asset_balances) > 0 {
+        event.FinalAssetBalances = make(map[string]string)
+        for _,asset_balance := range asset_balances {
+            event.FinalAssetBalances[asset_balance.Asset] = asset_balance.Balance.String()
+        }
+    }
+
+    if sweep_to_account_name != "" {
+        err = sweep_account_(stub, account, asset_balances, sweep_to_account_name)
+        if err != nil {
+            return nil, err
+        }
+    } else {
+        if account.Balance.Sign() != 0 {
+            return nil, fmt.Errorf("it has a non-zero balance of %v; a sweep_to_account_name is required", account.Balance)
+        }
+        for _,asset_balance := range asset_balances {
+            if asset_balance.Balance.Sign() != 0 {
+                return nil, fmt.Errorf("it has a non-zero balance of %v %s; a sweep_to_account_name is required", asset_balance.Balance, asset_balance.Asset)
+            }
+        }
+    }
+
+    for i := range asset_balances {
+        err = delete_asset_balance_(stub, &asset_balances[i])
+        if err != nil {
+            return nil, err
+        }
+    }
+    err = delete_allowances_(stub, account.Name)
+    if err != nil {
+        return nil, err
+    }
+    err = delete_transfer_limits_(stub, account.Name)
+    if err != nil {
+        return nil, err
+    }
+    err = delete_standing_orders_(stub, account.Name)
+    if err != nil {
+        return nil, err
+    }
+    return event, nil
+}
+
+//
+// account lifecycle related chaincode API functions
+//
+
+// Implements freeze_account and unfreeze_account, whose single arg is account_name.
+func (t *SimpleChaincode) set_account_status (stub shim.ChaincodeStubInterface, function string, args []string) pb.Response {
+    if len(args) != 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1; account_name")
+    }
+
+    // only Admin is allowed to freeze_account and unfreeze_account
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to %s", function)
+    }
+
+    account_name := args[0]
+    account,err := get_account_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+
+    old_status,new_status,event_type := ACCOUNT_ACTIVE,ACCOUNT_FROZEN,events.ACCOUNT_FROZEN
+    if function == "unfreeze_account" {
+        old_status,new_status,event_type = ACCOUNT_FROZEN,ACCOUNT_ACTIVE,events.ACCOUNT_UNFROZEN
+    }
+    if account.Status != old_status {
+        return shim.Error(fmt.Sprintf("Could not %s \"%s\" because it is %s", function, account_name, account.Status))
+    }
+    // A frozen fee collector couldn't be credited with fees, which would block every transfer that is charged one.
+    if new_status == ACCOUNT_FROZEN {
+        err = check_account_is_not_fee_collector(stub, account_name)
+        if err != nil {
+            return error_response(wrap_error(err, "Could not %s \"%s\" because %v", function, account_name, err.Error()))
+        }
+    }
+    account.Status = new_status
+    err = overwrite_account_(stub, account)
+    if err != nil {
+        return error_response(err)
+    }
+
+    err = emit_event(stub, event_type, &events.AccountStatusChanged{Account:account_name, Status:new_status})
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Closes an account permanently.  Args are account_name and optionally sweep_to_account_name, as for
+// delete_account.  Unlike a deleted account, a closed account is kept (with zero balances), so that its name
+// can't be reused by create_account.  A frozen account must be unfrozen before its balances can be swept.
+func (t *SimpleChaincode) close_account (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 && len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1 or 2; account_name, and optionally sweep_to_account_name")
+    }
+
+    // only Admin is allowed to close_account
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to close_account")
+    }
+
+    account_name := args[0]
+    account,err := get_account_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if account.Status == ACCOUNT_CLOSED {
+        return shim.Error(fmt.Sprintf("Could not close account \"%s\" because it is closed already", account_name))
+    }
+    sweep_to_account_name := ""
+    if len(args) == 2 {
+        sweep_to_account_name = args[1]
+    }
+    event,err := empty_account_(stub, account, sweep_to_account_name)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not close account \"%s\"; %v", account_name, err.Error()))
+    }
+
+    account.Status = ACCOUNT_CLOSED
+    err = overwrite_account_(stub, account)
+    if err != nil {
+        return error_response(err)
+    }
+
+    err = emit_event(stub, events.ACCOUNT_CLOSED, event)
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
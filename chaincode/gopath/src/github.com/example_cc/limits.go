@@ -0,0 +1,540 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/decimal"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "strconv"
+)
+
+//
+// transfer limits
+//
+// Transfers of the default asset out of an account are subject to TransferLimits: the ledger-wide limits (the
+// TransferLimits row in CONFIG_TABLE), each of which can be overridden for the accounts with a KYC level (see
+// AccountMetadata) by a KYCTransferLimits row, and for an account by an AccountTransferLimits row, which takes
+// precedence.  The outflow of an account on the current day (in UTC, according to the
+// transaction timestamp) is tracked by its DailyOutflow row.  Sweeping an account (see delete_account and
+// close_account) is not subject to the limits.  The limits are amounts of the default asset, so an account which
+// has any can't transfer other assets (see check_asset_transfer_limits_).
+//
+
+// A nil limit is no limit.
+type TransferLimits struct {
+    // The maximum amount of a single transfer.
+    MaxTransfer     *decimal.Amount `json:"MaxTransfer,omitempty"`
+    // The maximum total amount transferred out of an account per day.
+    DailyOutflow    *decimal.Amount `json:"DailyOutflow,omitempty"`
+    // The minimum balance that a transfer must leave in the account.
+    MinBalance      *decimal.Amount `json:"MinBalance,omitempty"`
+}
+
+// Returns the limits with each limit that is set in overrides replaced by that of overrides.
+func (limits TransferLimits) overridden_by (overrides *TransferLimits) TransferLimits {
+    if overrides.MaxTransfer != nil {
+        limits.MaxTransfer = overrides.MaxTransfer
+    }
+    if overrides.DailyOutflow != nil {
+        limits.DailyOutflow = overrides.DailyOutflow
+    }
+    if overrides.MinBalance != nil {
+        limits.MinBalance = overrides.MinBalance
+    }
+    return limits
+}
+
+func (limits *TransferLimits) is_empty () bool {
+    return limits.MaxTransfer == nil && limits.DailyOutflow == nil && limits.MinBalance == nil
+}
+
+// A KYC level is given for the overrides of a KYC level.
+func (limits *TransferLimits) event_data (account_name string, kyc_level *int) *events.TransferLimitsSet {
+    limit_string := func (limit *decimal.Amount) string {
+        if limit == nil {
+            return ""
+        }
+        return limit.String()
+    }
+    return &events.TransferLimitsSet{
+        Account:        account_name,
+        KYCLevel:       kyc_level,
+        MaxTransfer:    limit_string(limits.MaxTransfer),
+        DailyOutflow:   limit_string(limits.DailyOutflow),
+        MinBalance:     limit_string(limits.MinBalance),
+    }
+}
+
+var transfer_limits_table = util.NewTable(CONFIG_TABLE, TransferLimits{}, util.FixedRowKeys("TransferLimits"), nil)
+
+type AccountTransferLimits struct {
+    Account     string          `json:"Account"`
+    Overrides   TransferLimits  `json:"Overrides"`
+}
+
+func row_keys_of_AccountTransferLimits (account_limits *AccountTransferLimits) []string {
+    return []string{"AccountTransferLimits", account_limits.Account}
+}
+
+var account_transfer_limits_table = util.NewTable(CONFIG_TABLE, AccountTransferLimits{}, func (row interface{}) []string { return row_keys_of_AccountTransferLimits(row.(*AccountTransferLimits)) }, nil)
+
+type KYCTransferLimits struct {
+    KYCLevel    int             `json:"KYCLevel"`
+    Overrides   TransferLimits  `json:"Overrides"`
+}
+
+func row_keys_of_KYCTransferLimits (kyc_limits *KYCTransferLimits) []string {
+    return []string{"KYCTransferLimits", strconv.Itoa(kyc_limits.KYCLevel)}
+}
+
+var kyc_transfer_limits_table = util.NewTable(CONFIG_TABLE, KYCTransferLimits{}, func (row interface{}) []string { return row_keys_of_KYCTransferLimits(row.(*KYCTransferLimits)) }, nil)
+
+type DailyOutflow struct {
+    Account     string          `json:"Account"`
+    // The UTC date, as "2006-01-02", to which Outflow pertains.
+    Day         string          `json:"Day"`
+    Outflow     decimal.Amount  `json:"Outflow"`
+}
+
+func row_keys_of_DailyOutflow (outflow *DailyOutflow) []string {
+    return []string{"DailyOutflow", outflow.Account}
+}
+
+var daily_outflow_table = util.NewTable(CONFIG_TABLE, DailyOutflow{}, func (row interface{}) []string { return row_keys_of_DailyOutflow(row.(*DailyOutflow)) }, nil)
+
+// Raw form of function which does no permissions checking
+func get_transfer_limits_ (stub shim.ChaincodeStubInterface) (*TransferLimits, error) {
+    var limits TransferLimits
+    err := transfer_limits_table.Get(stub, transfer_limits_table.RowKeys(&limits), &limits)
+    if err != nil && !util.IsNotFound(err) {
+        return nil, wrap_error(err, "Could not retrieve TransferLimits; error was %v", err.Error())
+    }
+    return &limits, nil
+}
+
+// Raw form of function which does no permissions checking
+func set_transfer_limits_ (stub shim.ChaincodeStubInterface, limits *TransferLimits) error {
+    _,err := transfer_limits_table.Upsert(stub, limits, nil)
+    if err != nil {
+        return wrap_error(err, "Error setting %s TransferLimits value to %v; error was %v", CONFIG_TABLE, limits, err.Error())
+    }
+    return nil
+}
+
+// Raw form of function which does no permissions checking.  An account without overrides has empty Overrides.
+func get_account_transfer_limits_ (stub shim.ChaincodeStubInterface, account_name string) (*AccountTransferLimits, error) {
+    account_limits := AccountTransferLimits{Account:account_name}
+    err := account_transfer_limits_table.Get(stub, row_keys_of_AccountTransferLimits(&account_limits), &account_limits)
+    if err != nil && !util.IsNotFound(err) {
+        return nil, wrap_error(err, "Could not retrieve transfer limits of account \"%s\"; error was %v", account_name, err.Error())
+    }
+    return &account_limits, nil
+}
+
+// Raw form of function which does no permissions checking.  Empty Overrides are stored by deleting the row.
+func put_account_transfer_limits_ (stub shim.ChaincodeStubInterface, account_limits *AccountTransferLimits) error {
+    if account_limits.Overrides.is_empty() {
+        err := account_transfer_limits_table.Delete(stub, row_keys_of_AccountTransferLimits(account_limits), nil)
+        if util.IsNotFound(err) {
+            return nil
+        }
+        return err
+    }
+    _,err := account_transfer_limits_table.Upsert(stub, account_limits, nil)
+    return err
+}
+
+// Raw form of function which does no permissions checking.  A KYC level without overrides has empty Overrides.
+func get_kyc_transfer_limits_ (stub shim.ChaincodeStubInterface, kyc_level int) (*KYCTransferLimits, error) {
+    kyc_limits := KYCTransferLimits{KYCLevel:kyc_level}
+    err := kyc_transfer_limits_table.Get(stub, row_keys_of_KYCTransferLimits(&kyc_limits), &kyc_limits)
+    if err != nil && !util.IsNotFound(err) {
+        return nil, wrap_error(err, "Could not retrieve transfer limits of KYC level %d; error was %v", kyc_level, err.Error())
+    }
+    return &kyc_limits, nil
+}
+
+// Raw form of function which does no permissions checking.  Empty Overrides are stored by deleting the row.
+func put_kyc_transfer_limits_ (stub shim.ChaincodeStubInterface, kyc_limits *KYCTransferLimits) error {
+    if kyc_limits.Overrides.is_empty() {
+        err := kyc_transfer_limits_table.Delete(stub, row_keys_of_KYCTransferLimits(kyc_limits), nil)
+        if util.IsNotFound(err) {
+            return nil
+        }
+        return err
+    }
+    _,err := kyc_transfer_limits_table.Upsert(stub, kyc_limits, nil)
+    return err
+}
+
+// Raw form of function which does no permissions checking.  Returns the limits that apply to the account.
+func get_effective_transfer_limits_ (stub shim.ChaincodeStubInterface, account_name string) (TransferLimits, error) {
+    limits,err := get_transfer_limits_(stub)
+    if err != nil {
+        return TransferLimits{}, err
+    }
+    metadata,err := get_account_metadata_(stub, account_name)
+    if err != nil {
+        return TransferLimits{}, err
+    }
+    kyc_limits,err := get_kyc_transfer_limits_(stub, metadata.KYCLevel)
+    if err != nil {
+        return TransferLimits{}, err
+    }
+    account_limits,err := get_account_transfer_limits_(stub, account_name)
+    if err != nil {
+        return TransferLimits{}, err
+    }
+    return limits.overridden_by(&kyc_limits.Overrides).overridden_by(&account_limits.Overrides), nil
+}
+
+// Raw form of function which does no permissions checking.  Returns the outflow of the account on the day of
+// the transaction, which is zero if there was none yet.
+func get_daily_outflow_ (stub shim.ChaincodeStubInterface, account_name string) (*DailyOutflow, error) {
+    tx_time,err := get_tx_time(stub)
+    if err != nil {
+        return nil, err
+    }
+    decimals,err := get_ledger_decimals(stub)
+    if err != nil {
+        return nil, err
+    }
+    day := tx_time.Format("2006-01-02")
+    outflow := DailyOutflow{Account:account_name}
+    err = daily_outflow_table.Get(stub, row_keys_of_DailyOutflow(&outflow), &outflow)
+    if err != nil && !util.IsNotFound(err) {
+        return nil, wrap_error(err, "Could not retrieve daily outflow of account \"%s\"; error was %v", account_name, err.Error())
+    }
+    if outflow.Day != day {
+        outflow.Day = day
+        outflow.Outflow = decimal.Zero(decimals)
+    }
+    return &outflow, nil
+}
+
+// Raw form of function which does no permissions checking.  Deletes the transfer limit overrides and the daily
+// outflow of the account.
+func delete_transfer_limits_ (stub shim.ChaincodeStubInterface, account_name string) error {
+    err := account_transfer_limits_table.Delete(stub, row_keys_of_AccountTransferLimits(&AccountTransferLimits{Account:account_name}), nil)
+    if err != nil && !util.IsNotFound(err) {
+        return err
+    }
+    err = daily_outflow_table.Delete(stub, row_keys_of_DailyOutflow(&DailyOutflow{Account:account_name}), nil)
+    if util.IsNotFound(err) {
+        return nil
+    }
+    return err
+}
+
+// Checks transfers against the transfer limits.  GetState doesn't see this transaction's writes, so the daily
+// outflows are tracked here, so that several transfers from an account in one transaction count together.
+type outflow_tracker struct {
+    stub        shim.ChaincodeStubInterface
+    outflows    map[string]*DailyOutflow
+    touched     []*DailyOutflow
+}
+
+func new_outflow_tracker (stub shim.ChaincodeStubInterface) *outflow_tracker {
+    return &outflow_tracker{stub:stub, outflows:make(map[string]*DailyOutflow)}
+}
+
+func (tracker *outflow_tracker) outflow (account_name string) (*DailyOutflow, error) {
+    if outflow,ok := tracker.outflows[account_name]; ok {
+        return outflow, nil
+    }
+    outflow,err := get_daily_outflow_(tracker.stub, account_name)
+    if err != nil {
+        return nil, err
+    }
+    tracker.outflows[account_name] = outflow
+    tracker.touched = append(tracker.touched, outflow)
+    return outflow, nil
+}
+
+// Returns an error describing the limit that a transfer of amount out of from_account (which hasn't been made
+// yet) would break, or nil if it breaks none.  A transfer within one account is never limited.
+func (tracker *outflow_tracker) check (from_account *Account, to_account_name string, amount decimal.Amount) error {
+    if from_account.Name == to_account_name {
+        return nil
+    }
+    limits,err := get_effective_transfer_limits_(tracker.stub, from_account.Name)
+    if err != nil {
+        return err
+    }
+    if limits.MaxTransfer != nil && amount.Cmp(*limits.MaxTransfer) > 0 {
+        return coded_error(TRANSFER_LIMIT_EXCEEDED, "Transfer of %v from account \"%s\" exceeds its maximum single transfer of %v", amount, from_account.Name, *limits.MaxTransfer)
+    }
+    if limits.MinBalance != nil {
+        balance,err := from_account.Balance.Sub(amount)
+        if err != nil {
+            return err
+        }
+        if balance.Cmp(*limits.MinBalance) < 0 {
+            return coded_error(TRANSFER_LIMIT_EXCEEDED, "Transfer of %v from account \"%s\" would leave a balance of %v, below its minimum retained balance of %v", amount, from_account.Name, balance, *limits.MinBalance)
+        }
+    }
+    if limits.DailyOutflow != nil {
+        outflow,err := tracker.outflow(from_account.Name)
+        if err != nil {
+            return err
+        }
+        total,err := outflow.Outflow.Add(amount)
+        if err != nil {
+            return err
+        }
+        if total.Cmp(*limits.DailyOutflow) > 0 {
+            return coded_error(TRANSFER_LIMIT_EXCEEDED, "Transfer of %v from account \"%s\" would bring its outflow on %s to %v, exceeding its daily outflow limit of %v", amount, from_account.Name, outflow.Day, total, *limits.DailyOutflow)
+        }
+    }
+    return nil
+}
+
+// Raw form of function which does no permissions checking.  Returns an error if from_account has any transfer
+// limits, since they can't be applied to a transfer of an asset other than the default asset.  A transfer within
+// one account is never limited.
+func check_asset_transfer_limits_ (stub shim.ChaincodeStubInterface, from_account *Account, to_account_name string, asset_symbol string) error {
+    if from_account.Name == to_account_name {
+        return nil
+    }
+    limits,err := get_effective_transfer_limits_(stub, from_account.Name)
+    if err != nil {
+        return err
+    }
+    if !limits.is_empty() {
+        return coded_error(TRANSFER_LIMIT_EXCEEDED, "Account \"%s\" has transfer limits, which only apply to the default asset, so it can't transfer %s", from_account.Name, asset_display_name(asset_symbol))
+    }
+    return nil
+}
+
+// Counts a transfer of amount out of the account named from_account_name towards its daily outflow.
+func (tracker *outflow_tracker) record (from_account_name string, to_account_name string, amount decimal.Amount) error {
+    if from_account_name == to_account_name {
+        return nil
+    }
+    outflow,err := tracker.outflow(from_account_name)
+    if err != nil {
+        return err
+    }
+    outflow.Outflow,err = outflow.Outflow.Add(amount)
+    return err
+}
+
+// Writes the daily outflows of the recorded transfers.
+func (tracker *outflow_tracker) write () error {
+    for _,outflow := range tracker.touched {
+        _,err := daily_outflow_table.Upsert(tracker.stub, outflow, nil)
+        if err != nil {
+            return wrap_error(err, "Could not update daily outflow of account \"%s\"; error was %v", outflow.Account, err.Error())
+        }
+    }
+    return nil
+}
+
+// Parses the max_transfer, daily_outflow and min_balance args of set_transfer_limits and
+// set_account_transfer_limits, where an empty arg is no limit.
+func parse_transfer_limits_args (stub shim.ChaincodeStubInterface, args []string) (*TransferLimits, error) {
+    var amounts [3]*decimal.Amount
+    for i,arg := range args {
+        if arg == "" {
+            continue
+        }
+        amount,err := parse_amount(stub, arg)
+        if err != nil {
+            return nil, coded_error(INVALID_PARAMS, "Invalid limit \"%s\"; %v", arg, err.Error())
+        }
+        if amount.Sign() < 0 {
+            return nil, coded_error(INVALID_PARAMS, "Invalid limit %v; expecting non-negative amount", amount)
+        }
+        amounts[i] = &amount
+    }
+    return &TransferLimits{MaxTransfer:amounts[0], DailyOutflow:amounts[1], MinBalance:amounts[2]}, nil
+}
+
+//
+// transfer limit related chaincode API functions
+//
+
+// Sets the ledger-wide transfer limits.  Args are max_transfer, daily_outflow and min_balance, each of which
+// may be empty for no limit.
+func (t *SimpleChaincode) set_transfer_limits (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 3; max_transfer, daily_outflow and min_balance")
+    }
+
+    // only Admin is allowed to set_transfer_limits
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to set_transfer_limits")
+    }
+
+    limits,err := parse_transfer_limits_args(stub, args)
+    if err != nil {
+        return error_response(err)
+    }
+    err = set_transfer_limits_(stub, limits)
+    if err != nil {
+        return error_response(err)
+    }
+
+    err = emit_event(stub, events.TRANSFER_LIMITS_SET, limits.event_data("", nil))
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Sets the transfer limits of an account, overriding the ledger-wide ones.  Args are account_name, max_transfer,
+// daily_outflow and min_balance, each of the latter being empty to use the ledger-wide limit.
+func (t *SimpleChaincode) set_account_transfer_limits (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 4 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 4; account_name, max_transfer, daily_outflow and min_balance")
+    }
+
+    // only Admin is allowed to set_account_transfer_limits
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to set_account_transfer_limits")
+    }
+
+    account_name := args[0]
+    if _,err = get_account_(stub, account_name); err != nil {
+        return error_response(err)
+    }
+    overrides,err := parse_transfer_limits_args(stub, args[1:])
+    if err != nil {
+        return error_response(err)
+    }
+    err = put_account_transfer_limits_(stub, &AccountTransferLimits{Account:account_name, Overrides:*overrides})
+    if err != nil {
+        return error_response(wrap_error(err, "Could not set transfer limits of account \"%s\"; error was %v", account_name, err.Error()))
+    }
+
+    err = emit_event(stub, events.TRANSFER_LIMITS_SET, overrides.event_data(account_name, nil))
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Sets the transfer limits of the accounts with a KYC level, overriding the ledger-wide ones.  Args are
+// kyc_level, max_transfer, daily_outflow and min_balance, each of the latter being empty to use the
+// ledger-wide limit.  Limits set for an account by set_account_transfer_limits take precedence.
+func (t *SimpleChaincode) set_kyc_transfer_limits (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 4 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 4; kyc_level, max_transfer, daily_outflow and min_balance")
+    }
+
+    // only Admin is allowed to set_kyc_transfer_limits
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to set_kyc_transfer_limits")
+    }
+
+    kyc_level,err := strconv.Atoi(args[0])
+    if err != nil || kyc_level < 0 {
+        return coded_error_response(INVALID_PARAMS, "Invalid kyc_level \"%s\"; expecting non-negative integer", args[0])
+    }
+    overrides,err := parse_transfer_limits_args(stub, args[1:])
+    if err != nil {
+        return error_response(err)
+    }
+    err = put_kyc_transfer_limits_(stub, &KYCTransferLimits{KYCLevel:kyc_level, Overrides:*overrides})
+    if err != nil {
+        return error_response(wrap_error(err, "Could not set transfer limits of KYC level %d; error was %v", kyc_level, err.Error()))
+    }
+
+    err = emit_event(stub, events.TRANSFER_LIMITS_SET, overrides.event_data("", &kyc_level))
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Query the ledger-wide transfer limits or, given account_name as the optional arg, the limits of that account
+// (along with the overrides for it and for its KYC level) and its outflow so far today.  Querying an account's
+// limits has the same authorization as query_balance.
+func (t *SimpleChaincode) query_transfer_limits (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) > 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 or 1 arguments, got %v", args)
+    }
+
+    var result interface{}
+    if len(args) == 0 {
+        err := check_permission(stub, "query_balance", "query_transfer_limits")
+        if err != nil {
+            return error_response(err)
+        }
+        result,err = get_transfer_limits_(stub)
+        if err != nil {
+            return error_response(err)
+        }
+    } else {
+        account_name := args[0]
+
+        // The account holder is allowed to query its limits, as is anyone with permission to query_balance.
+        is_holder,err := transactor_is_account_owner(stub, account_name)
+        if err != nil {
+            return error_response(err)
+        }
+        if !is_holder {
+            err = check_permission(stub, "query_balance", fmt.Sprintf("query transfer limits of account \"%s\"", account_name))
+            if err != nil {
+                return error_response(err)
+            }
+        }
+
+        if _,err = get_account_(stub, account_name); err != nil {
+            return error_response(err)
+        }
+        account_limits,err := get_account_transfer_limits_(stub, account_name)
+        if err != nil {
+            return error_response(err)
+        }
+        metadata,err := get_account_metadata_(stub, account_name)
+        if err != nil {
+            return error_response(err)
+        }
+        kyc_limits,err := get_kyc_transfer_limits_(stub, metadata.KYCLevel)
+        if err != nil {
+            return error_response(err)
+        }
+        limits,err := get_effective_transfer_limits_(stub, account_name)
+        if err != nil {
+            return error_response(err)
+        }
+        outflow,err := get_daily_outflow_(stub, account_name)
+        if err != nil {
+            return error_response(err)
+        }
+        result = struct {
+            Account     string          `json:"Account"`
+            Overrides   TransferLimits  `json:"Overrides"`
+            KYCLevel    int             `json:"KYCLevel"`
+            KYCOverrides TransferLimits `json:"KYCOverrides"`
+            Limits      TransferLimits  `json:"Limits"`
+            Day         string          `json:"Day"`
+            Outflow     decimal.Amount  `json:"Outflow"`
+        }{account_name, account_limits.Overrides, kyc_limits.KYCLevel, kyc_limits.Overrides, limits, outflow.Day, outflow.Outflow}
+    }
+
+    bytes,err := json.Marshal(result)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing transfer limits failed in query_transfer_limits because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
@@ -0,0 +1,99 @@
+package memstub
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "fmt"
+    mspprotos "github.com/hyperledger/fabric/protos/msp"
+    "math/big"
+    "time"
+    // NOTE: This is temporarily vendored INSIDE THE github.com/example_cc DIR!
+    "github.com/example_cc/golang/protobuf/proto"
+)
+
+// MSP is a throwaway certificate authority standing in for a Fabric MSP.  Certificates it issues are
+// generated on the fly and are only suitable for tests.
+type MSP struct {
+    ID          string
+    ca_key      *ecdsa.PrivateKey
+    ca_cert     *x509.Certificate
+    next_serial int64
+}
+
+// Identity is a transactor, i.e. what stub.GetCreator returns during a transaction.
+type Identity struct {
+    MspID       string
+    Cert        *x509.Certificate
+    CertPEM     []byte
+    // The proto-serialized mspprotos.SerializedIdentity.
+    Serialized  []byte
+}
+
+func NewMSP (msp_id string) (*MSP, error) {
+    ca_key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        return nil, fmt.Errorf("Could not generate CA key for MSP \"%s\"; error was %v", msp_id, err)
+    }
+    template := &x509.Certificate{
+        SerialNumber:           big.NewInt(1),
+        Subject:                pkix.Name{CommonName:"ca." + msp_id, Organization:[]string{msp_id}},
+        NotBefore:              DefaultStartTime.Add(-24*time.Hour),
+        NotAfter:               DefaultStartTime.Add(100*365*24*time.Hour),
+        KeyUsage:               x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+        BasicConstraintsValid:  true,
+        IsCA:                   true,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &ca_key.PublicKey, ca_key)
+    if err != nil {
+        return nil, fmt.Errorf("Could not create CA certificate for MSP \"%s\"; error was %v", msp_id, err)
+    }
+    ca_cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        return nil, fmt.Errorf("Could not parse CA certificate for MSP \"%s\"; error was %v", msp_id, err)
+    }
+    return &MSP{ID:msp_id, ca_key:ca_key, ca_cert:ca_cert, next_serial:2}, nil
+}
+
+// Issues a new certificate with the given subject common name and returns the corresponding Identity.
+func (msp *MSP) NewIdentity (common_name string) (*Identity, error) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        return nil, fmt.Errorf("Could not generate key for \"%s\"; error was %v", common_name, err)
+    }
+    template := &x509.Certificate{
+        SerialNumber:   big.NewInt(msp.next_serial),
+        Subject:        pkix.Name{CommonName:common_name, Organization:[]string{msp.ID}},
+        NotBefore:      DefaultStartTime.Add(-24*time.Hour),
+        NotAfter:       DefaultStartTime.Add(100*365*24*time.Hour),
+        KeyUsage:       x509.KeyUsageDigitalSignature,
+    }
+    msp.next_serial++
+    der, err := x509.CreateCertificate(rand.Reader, template, msp.ca_cert, &key.PublicKey, msp.ca_key)
+    if err != nil {
+        return nil, fmt.Errorf("Could not create certificate for \"%s\"; error was %v", common_name, err)
+    }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        return nil, fmt.Errorf("Could not parse certificate for \"%s\"; error was %v", common_name, err)
+    }
+    cert_pem := pem.EncodeToMemory(&pem.Block{Type:"CERTIFICATE", Bytes:der})
+    serialized, err := SerializeIdentity(msp.ID, cert_pem)
+    if err != nil {
+        return nil, err
+    }
+    return &Identity{MspID:msp.ID, Cert:cert, CertPEM:cert_pem, Serialized:serialized}, nil
+}
+
+// Produces the bytes that stub.GetCreator returns for the given MSP ID and IdBytes.  This is exposed
+// so that tests can construct malformed creators.
+func SerializeIdentity (msp_id string, id_bytes []byte) ([]byte, error) {
+    serialized, err := proto.Marshal(&mspprotos.SerializedIdentity{Mspid:msp_id, IdBytes:id_bytes})
+    if err != nil {
+        return nil, fmt.Errorf("Could not serialize identity for MSP \"%s\"; error was %v", msp_id, err)
+    }
+    return serialized, nil
+}
@@ -0,0 +1,387 @@
+// Package memstub provides an in-memory stand-in for shim.ChaincodeStubInterface so that chaincode
+// can be driven through Init/Invoke without a Fabric network.  It mimics the Fabric 1.0 peer's
+// transaction semantics: reads (including range queries) only see committed state, writes are
+// buffered in a write set, and the write set is committed only if the chaincode response status is
+// below shim.ERRORTHRESHOLD.
+package memstub
+
+import (
+    "errors"
+    "fmt"
+    "github.com/golang/protobuf/ptypes/timestamp"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    "github.com/hyperledger/fabric/protos/ledger/queryresult"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "sort"
+    "strings"
+    "time"
+    "unicode/utf8"
+)
+
+// These match the delimiters used by the Fabric 1.0 shim for composite keys.
+const (
+    min_unicode_rune_value rune = 0
+    max_unicode_rune_value rune = utf8.MaxRune
+)
+
+// The time that the first transaction is stamped with, unless MemStub.Now is set explicitly.
+var DefaultStartTime = time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// A chaincode event as set by stub.SetEvent during a committed transaction.
+type ChaincodeEvent struct {
+    TxID    string
+    Name    string
+    Payload []byte
+}
+
+type write_set_entry struct {
+    value       []byte
+    is_delete   bool
+}
+
+// MemStub implements shim.ChaincodeStubInterface over an in-memory key/value store.
+type MemStub struct {
+    Name        string
+    cc          shim.Chaincode
+
+    // The transaction timestamp of the next transaction.  It is advanced by TimeStep after each transaction.
+    Now         time.Time
+    TimeStep    time.Duration
+
+    // Committed ledger state.
+    state       map[string][]byte
+    history     map[string][]*queryresult.KeyModification
+    // Events emitted by committed transactions, in commit order.
+    Events      []ChaincodeEvent
+
+    // Per-transaction state; only meaningful between transaction start and end.
+    args        [][]byte
+    tx_id       string
+    tx_time     time.Time
+    creator     []byte
+    write_set   map[string]write_set_entry
+    event       *ChaincodeEvent
+}
+
+func NewMemStub (name string, cc shim.Chaincode) *MemStub {
+    return &MemStub{
+        Name:       name,
+        cc:         cc,
+        Now:        DefaultStartTime,
+        TimeStep:   time.Second,
+        state:      make(map[string][]byte),
+        history:    make(map[string][]*queryresult.KeyModification),
+    }
+}
+
+// Converts string args into the [][]byte form expected by MockInit and MockInvoke.
+func StringArgs (args ...string) [][]byte {
+    byte_args := make([][]byte, len(args))
+    for i,arg := range args {
+        byte_args[i] = []byte(arg)
+    }
+    return byte_args
+}
+
+// Calls Init on the chaincode as the given identity, committing the write set if it succeeded.
+func (stub *MemStub) MockInit (tx_id string, creator *Identity, args [][]byte) pb.Response {
+    return stub.run_transaction(tx_id, creator, args, stub.cc.Init)
+}
+
+// Calls Invoke on the chaincode as the given identity, committing the write set if it succeeded.
+func (stub *MemStub) MockInvoke (tx_id string, creator *Identity, args [][]byte) pb.Response {
+    return stub.run_transaction(tx_id, creator, args, stub.cc.Invoke)
+}
+
+func (stub *MemStub) run_transaction (
+    tx_id       string,
+    creator     *Identity,
+    args        [][]byte,
+    entry_point func(shim.ChaincodeStubInterface) pb.Response,
+) pb.Response {
+    stub.args = args
+    stub.tx_id = tx_id
+    stub.tx_time = stub.Now
+    stub.creator = nil
+    if creator != nil {
+        stub.creator = creator.Serialized
+    }
+    stub.write_set = make(map[string]write_set_entry)
+    stub.event = nil
+
+    response := entry_point(stub)
+    if response.Status < shim.ERRORTHRESHOLD {
+        stub.commit()
+    }
+
+    stub.args = nil
+    stub.tx_id = ""
+    stub.creator = nil
+    stub.write_set = nil
+    stub.event = nil
+    stub.Now = stub.Now.Add(stub.TimeStep)
+    return response
+}
+
+func (stub *MemStub) commit () {
+    // Sort the keys so that history is recorded deterministically.
+    keys := make([]string, 0, len(stub.write_set))
+    for key := range stub.write_set {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    for _,key := range keys {
+        entry := stub.write_set[key]
+        if entry.is_delete {
+            delete(stub.state, key)
+        } else {
+            stub.state[key] = entry.value
+        }
+        stub.history[key] = append(stub.history[key], &queryresult.KeyModification{
+            TxId:       stub.tx_id,
+            Value:      entry.value,
+            Timestamp:  to_timestamp(stub.tx_time),
+            IsDelete:   entry.is_delete,
+        })
+    }
+    if stub.event != nil {
+        stub.Events = append(stub.Events, *stub.event)
+    }
+}
+
+// Returns the committed value of the given key, or nil if it is not present.  This is intended for
+// inspecting the ledger from tests; chaincode should use GetState.
+func (stub *MemStub) CommittedState (key string) []byte {
+    return stub.state[key]
+}
+
+// Sets the committed value of the given key, deleting it if value is nil.  This is intended for seeding the
+// ledger from tests, e.g. with rows as written by an older version of a chaincode.
+func (stub *MemStub) PutCommittedState (key string, value []byte) {
+    if value == nil {
+        delete(stub.state, key)
+    } else {
+        stub.state[key] = value
+    }
+}
+
+// Returns the most recently committed event, or nil if no event has been committed.
+func (stub *MemStub) LastEvent () *ChaincodeEvent {
+    if len(stub.Events) == 0 {
+        return nil
+    }
+    return &stub.Events[len(stub.Events)-1]
+}
+
+func to_timestamp (t time.Time) *timestamp.Timestamp {
+    return &timestamp.Timestamp{Seconds:t.Unix(), Nanos:int32(t.Nanosecond())}
+}
+
+//
+// shim.ChaincodeStubInterface implementation
+//
+
+func (stub *MemStub) GetArgs () [][]byte {
+    return stub.args
+}
+
+func (stub *MemStub) GetStringArgs () []string {
+    string_args := make([]string, len(stub.args))
+    for i,arg := range stub.args {
+        string_args[i] = string(arg)
+    }
+    return string_args
+}
+
+func (stub *MemStub) GetFunctionAndParameters () (string, []string) {
+    string_args := stub.GetStringArgs()
+    if len(string_args) == 0 {
+        return "", []string{}
+    }
+    return string_args[0], string_args[1:]
+}
+
+func (stub *MemStub) GetArgsSlice () ([]byte, error) {
+    var args_slice []byte
+    for _,arg := range stub.args {
+        args_slice = append(args_slice, arg...)
+    }
+    return args_slice, nil
+}
+
+func (stub *MemStub) GetTxID () string {
+    return stub.tx_id
+}
+
+func (stub *MemStub) InvokeChaincode (chaincode_name string, args [][]byte, channel string) pb.Response {
+    return shim.Error(fmt.Sprintf("MemStub does not support InvokeChaincode (chaincode \"%s\", channel \"%s\")", chaincode_name, channel))
+}
+
+func (stub *MemStub) GetState (key string) ([]byte, error) {
+    return stub.state[key], nil
+}
+
+func (stub *MemStub) PutState (key string, value []byte) error {
+    if stub.write_set == nil {
+        return errors.New("PutState called outside of a transaction")
+    }
+    if key == "" {
+        return errors.New("PutState called with empty key")
+    }
+    stub.write_set[key] = write_set_entry{value:value}
+    return nil
+}
+
+func (stub *MemStub) DelState (key string) error {
+    if stub.write_set == nil {
+        return errors.New("DelState called outside of a transaction")
+    }
+    stub.write_set[key] = write_set_entry{is_delete:true}
+    return nil
+}
+
+// An empty end_key means the range is unbounded above, as with the Fabric peer.
+func (stub *MemStub) GetStateByRange (start_key, end_key string) (shim.StateQueryIteratorInterface, error) {
+    var keys []string
+    for key := range stub.state {
+        if key >= start_key && (end_key == "" || key < end_key) {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+
+    kvs := make([]*queryresult.KV, len(keys))
+    for i,key := range keys {
+        kvs[i] = &queryresult.KV{Namespace:stub.Name, Key:key, Value:stub.state[key]}
+    }
+    return &state_query_iterator{kvs:kvs}, nil
+}
+
+func (stub *MemStub) GetStateByPartialCompositeKey (object_type string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+    partial_composite_key, err := stub.CreateCompositeKey(object_type, attributes)
+    if err != nil {
+        return nil, err
+    }
+    return stub.GetStateByRange(partial_composite_key, partial_composite_key + string(max_unicode_rune_value))
+}
+
+func (stub *MemStub) CreateCompositeKey (object_type string, attributes []string) (string, error) {
+    if err := validate_composite_key_attribute(object_type); err != nil {
+        return "", err
+    }
+    composite_key := object_type + string(min_unicode_rune_value)
+    for _,attribute := range attributes {
+        if err := validate_composite_key_attribute(attribute); err != nil {
+            return "", err
+        }
+        composite_key += attribute + string(min_unicode_rune_value)
+    }
+    return composite_key, nil
+}
+
+func (stub *MemStub) SplitCompositeKey (composite_key string) (string, []string, error) {
+    components := strings.Split(composite_key, string(min_unicode_rune_value))
+    if len(components) < 2 || components[len(components)-1] != "" {
+        return "", nil, fmt.Errorf("\"%s\" is not a composite key", composite_key)
+    }
+    components = components[:len(components)-1]
+    return components[0], components[1:], nil
+}
+
+func validate_composite_key_attribute (attribute string) error {
+    if !utf8.ValidString(attribute) {
+        return fmt.Errorf("Not a valid utf8 string: [%x]", attribute)
+    }
+    for _,r := range attribute {
+        if r == min_unicode_rune_value || r == max_unicode_rune_value {
+            return fmt.Errorf("Input contains unicode %#U starting at position [%d]. %#U and %#U are not allowed in the input attribute of a composite key", r, strings.IndexRune(attribute, r), min_unicode_rune_value, max_unicode_rune_value)
+        }
+    }
+    return nil
+}
+
+func (stub *MemStub) GetQueryResult (query string) (shim.StateQueryIteratorInterface, error) {
+    return nil, errors.New("MemStub does not support rich queries")
+}
+
+func (stub *MemStub) GetHistoryForKey (key string) (shim.HistoryQueryIteratorInterface, error) {
+    return &history_query_iterator{modifications:stub.history[key]}, nil
+}
+
+func (stub *MemStub) GetCreator () ([]byte, error) {
+    return stub.creator, nil
+}
+
+func (stub *MemStub) GetTransient () (map[string][]byte, error) {
+    return map[string][]byte{}, nil
+}
+
+func (stub *MemStub) GetBinding () ([]byte, error) {
+    return nil, nil
+}
+
+func (stub *MemStub) GetSignedProposal () (*pb.SignedProposal, error) {
+    return nil, nil
+}
+
+func (stub *MemStub) GetTxTimestamp () (*timestamp.Timestamp, error) {
+    return to_timestamp(stub.tx_time), nil
+}
+
+// As with the Fabric peer, only the last event set during a transaction is kept.
+func (stub *MemStub) SetEvent (name string, payload []byte) error {
+    if name == "" {
+        return errors.New("Event name can not be nil string.")
+    }
+    stub.event = &ChaincodeEvent{TxID:stub.tx_id, Name:name, Payload:payload}
+    return nil
+}
+
+//
+// iterators
+//
+
+type state_query_iterator struct {
+    kvs     []*queryresult.KV
+    index   int
+}
+
+func (iter *state_query_iterator) HasNext () bool {
+    return iter.index < len(iter.kvs)
+}
+
+func (iter *state_query_iterator) Next () (*queryresult.KV, error) {
+    if !iter.HasNext() {
+        return nil, errors.New("state_query_iterator.Next called with no remaining results")
+    }
+    kv := iter.kvs[iter.index]
+    iter.index++
+    return kv, nil
+}
+
+func (iter *state_query_iterator) Close () error {
+    return nil
+}
+
+type history_query_iterator struct {
+    modifications   []*queryresult.KeyModification
+    index           int
+}
+
+func (iter *history_query_iterator) HasNext () bool {
+    return iter.index < len(iter.modifications)
+}
+
+func (iter *history_query_iterator) Next () (*queryresult.KeyModification, error) {
+    if !iter.HasNext() {
+        return nil, errors.New("history_query_iterator.Next called with no remaining results")
+    }
+    modification := iter.modifications[iter.index]
+    iter.index++
+    return modification, nil
+}
+
+func (iter *history_query_iterator) Close () error {
+    return nil
+}
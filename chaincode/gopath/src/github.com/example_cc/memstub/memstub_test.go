@@ -0,0 +1,65 @@
+package memstub
+
+import (
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "testing"
+)
+
+// Writes args[1] to key args[0] and returns the value that GetState saw afterward.  Fails if args[2] is "fail".
+type put_and_get_chaincode struct {
+}
+
+func (cc *put_and_get_chaincode) Init (stub shim.ChaincodeStubInterface) pb.Response {
+    return shim.Success(nil)
+}
+
+func (cc *put_and_get_chaincode) Invoke (stub shim.ChaincodeStubInterface) pb.Response {
+    args := stub.GetStringArgs()
+    if err := stub.PutState(args[0], []byte(args[1])); err != nil {
+        return shim.Error(err.Error())
+    }
+    value, _ := stub.GetState(args[0])
+    if len(args) > 2 && args[2] == "fail" {
+        return shim.Error("failing on purpose")
+    }
+    return shim.Success(value)
+}
+
+func TestWritesAreNotVisibleUntilCommitted (t *testing.T) {
+    stub := NewMemStub("test", new(put_and_get_chaincode))
+
+    response := stub.MockInvoke("tx0", nil, StringArgs("k", "v0"))
+    if response.Status != shim.OK || response.Payload != nil {
+        t.Fatalf("expected uncommitted write to be invisible within the transaction, got %v", response)
+    }
+    if string(stub.CommittedState("k")) != "v0" {
+        t.Fatalf("expected \"v0\" to be committed, got \"%s\"", string(stub.CommittedState("k")))
+    }
+
+    response = stub.MockInvoke("tx1", nil, StringArgs("k", "v1", "fail"))
+    if response.Status != shim.ERROR {
+        t.Fatalf("expected failure, got %v", response)
+    }
+    if string(stub.CommittedState("k")) != "v0" {
+        t.Fatalf("expected failed transaction to be rolled back, got \"%s\"", string(stub.CommittedState("k")))
+    }
+}
+
+func TestCompositeKeyRoundTrip (t *testing.T) {
+    stub := NewMemStub("test", new(put_and_get_chaincode))
+    composite_key, err := stub.CreateCompositeKey("Table", []string{"a", "b"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    object_type, attributes, err := stub.SplitCompositeKey(composite_key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if object_type != "Table" || len(attributes) != 2 || attributes[0] != "a" || attributes[1] != "b" {
+        t.Fatalf("SplitCompositeKey returned %s %v", object_type, attributes)
+    }
+    if _, err = stub.CreateCompositeKey("Table", []string{"a\x00b"}); err == nil {
+        t.Fatal("expected an attribute containing U+0000 to be rejected")
+    }
+}
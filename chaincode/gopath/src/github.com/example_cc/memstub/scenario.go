@@ -0,0 +1,337 @@
+package memstub
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+)
+
+// A Scenario is a scripted sequence of Init/Invoke calls, each made as a named identity, together with
+// the expected response of each call.  Scenarios are read from JSON files so that regression cases can be
+// added without writing Go code.  Example:
+//
+//     {
+//         "description": "account holders may transfer from their own accounts",
+//         "identities": {
+//             "admin": {"msp_id": "Org0MSP", "common_name": "Admin"},
+//             "alice": {"msp_id": "Org1MSP", "common_name": "Alice"}
+//         },
+//         "steps": [
+//             {"as": "admin", "init": true},
+//             {"as": "admin", "function": "create_account",
+//              "args": ["Alice", "100", "{{alice.msp_id}}", "{{alice.cert_pem}}"]},
+//             {"as": "alice", "function": "query_balance", "args": ["Alice"],
+//              "expect": {"payload": {"Name": "Alice", "Balance": 100}}}
+//         ]
+//     }
+type Scenario struct {
+    Description string                      `json:"description"`
+    Identities  map[string]IdentitySpec     `json:"identities"`
+    Steps       []ScenarioStep              `json:"steps"`
+}
+
+type IdentitySpec struct {
+    MspID       string  `json:"msp_id"`
+    CommonName  string  `json:"common_name"`
+}
+
+type ScenarioStep struct {
+    Description string          `json:"description"`
+    // Name of the identity (a key of Scenario.Identities) that makes the call.
+    As          string          `json:"as"`
+    // If true, then Init is called instead of Invoke.
+    Init        bool            `json:"init"`
+    // The function name; defaults to "init" for Init calls.
+    Function    string          `json:"function"`
+    // Occurrences of {{name.msp_id}} and {{name.cert_pem}} are replaced by the MSP ID and PEM-encoded
+    // certificate of the named identity.
+    Args        []string        `json:"args"`
+    // If nonempty, a duration (as accepted by time.ParseDuration, e.g. "24h") by which the clock is advanced
+    // before the call, in addition to the usual MemStub.TimeStep after each transaction.
+    Advance     string          `json:"advance"`
+    Expect      Expectation     `json:"expect"`
+}
+
+type Expectation struct {
+    // Defaults to shim.OK.
+    Status          int32           `json:"status"`
+    // If present, the response payload must be JSON equal to this value.
+    Payload         json.RawMessage `json:"payload"`
+    // If present, this must be a JSON object, and the response payload must be a JSON object having each of
+    // its fields with a matching value.  Fields of the response payload not mentioned are ignored, and this
+    // applies recursively to nested objects; other values, including arrays, must be JSON equal.
+    PayloadIncludes json.RawMessage `json:"payload_includes"`
+    // If present, the response payload must equal this string exactly.
+    PayloadString   *string         `json:"payload_string"`
+    // If present, the response message must contain this substring.
+    MessageContains string          `json:"message_contains"`
+    // If present, the transaction must have committed an event matching this.
+    Event           *EventExpectation   `json:"event"`
+}
+
+type EventExpectation struct {
+    Name            string          `json:"name"`
+    // As in Expectation, but applying to the event payload.
+    Payload         json.RawMessage `json:"payload"`
+    PayloadIncludes json.RawMessage `json:"payload_includes"`
+}
+
+func LoadScenario (path string) (*Scenario, error) {
+    scenario_bytes, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("Could not read scenario file \"%s\"; error was %v", path, err)
+    }
+    decoder := json.NewDecoder(bytes.NewReader(scenario_bytes))
+    decoder.DisallowUnknownFields()
+    var scenario Scenario
+    err = decoder.Decode(&scenario)
+    if err != nil {
+        return nil, fmt.Errorf("Could not parse scenario file \"%s\"; error was %v", path, err)
+    }
+    return &scenario, nil
+}
+
+// Runs every scenario file matching the glob pattern as a subtest, each against a fresh chaincode
+// instance returned by new_chaincode.
+func RunScenarioFiles (t *testing.T, pattern string, new_chaincode func() shim.Chaincode) {
+    paths, err := filepath.Glob(pattern)
+    if err != nil {
+        t.Fatalf("Bad scenario file pattern \"%s\"; error was %v", pattern, err)
+    }
+    if len(paths) == 0 {
+        t.Fatalf("No scenario files match pattern \"%s\"", pattern)
+    }
+    for _,path := range paths {
+        path := path
+        t.Run(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), func(t *testing.T) {
+            scenario, err := LoadScenario(path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            scenario.Run(t, new_chaincode())
+        })
+    }
+}
+
+// Runs the scenario against cc on a fresh MemStub, reporting each failed expectation through t.
+// The stub is returned so that the caller can make further assertions on the ledger.
+func (scenario *Scenario) Run (t testing.TB, cc shim.Chaincode) *MemStub {
+    identities, err := scenario.make_identities()
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub := NewMemStub("scenario", cc)
+    for i,step := range scenario.Steps {
+        identity, ok := identities[step.As]
+        if step.As != "" && !ok {
+            t.Fatalf("step %d: unknown identity \"%s\"", i, step.As)
+        }
+        function := step.Function
+        if function == "" && step.Init {
+            function = "init"
+        }
+        step_args := make([]string, len(step.Args))
+        for j,arg := range step.Args {
+            step_args[j] = expand_placeholders(arg, identities)
+        }
+        args := StringArgs(append([]string{function}, step_args...)...)
+        tx_id := fmt.Sprintf("tx%d", i)
+        if step.Advance != "" {
+            advance, err := time.ParseDuration(step.Advance)
+            if err != nil {
+                t.Fatalf("step %d: invalid advance \"%s\"; %v", i, step.Advance, err)
+            }
+            stub.Now = stub.Now.Add(advance)
+        }
+
+        var response pb.Response
+        if step.Init {
+            response = stub.MockInit(tx_id, identity, args)
+        } else {
+            response = stub.MockInvoke(tx_id, identity, args)
+        }
+        if err := step.Expect.check(response, stub, tx_id); err != nil {
+            t.Errorf("step %d (%s %s %v as \"%s\"%s): %v", i, step.kind(), function, step.Args, step.As, step.description_suffix(), err)
+        }
+    }
+    return stub
+}
+
+// One MSP is created per distinct MSP ID so that identities in the same MSP share an issuer.
+func (scenario *Scenario) make_identities () (map[string]*Identity, error) {
+    names := make([]string, 0, len(scenario.Identities))
+    for name := range scenario.Identities {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    msps := make(map[string]*MSP)
+    identities := make(map[string]*Identity)
+    for _,name := range names {
+        spec := scenario.Identities[name]
+        msp, ok := msps[spec.MspID]
+        if !ok {
+            var err error
+            msp, err = NewMSP(spec.MspID)
+            if err != nil {
+                return nil, err
+            }
+            msps[spec.MspID] = msp
+        }
+        identity, err := msp.NewIdentity(spec.CommonName)
+        if err != nil {
+            return nil, err
+        }
+        identities[name] = identity
+    }
+    return identities, nil
+}
+
+func expand_placeholders (arg string, identities map[string]*Identity) string {
+    for name,identity := range identities {
+        arg = strings.Replace(arg, "{{" + name + ".msp_id}}", identity.MspID, -1)
+        arg = strings.Replace(arg, "{{" + name + ".cert_pem}}", string(identity.CertPEM), -1)
+    }
+    return arg
+}
+
+func (step *ScenarioStep) kind () string {
+    if step.Init {
+        return "Init"
+    }
+    return "Invoke"
+}
+
+func (step *ScenarioStep) description_suffix () string {
+    if step.Description == "" {
+        return ""
+    }
+    return "; " + step.Description
+}
+
+func (expect *Expectation) check (response pb.Response, stub *MemStub, tx_id string) error {
+    expected_status := expect.Status
+    if expected_status == 0 {
+        expected_status = shim.OK
+    }
+    if response.Status != expected_status {
+        return fmt.Errorf("expected status %d but got %d (message: \"%s\")", expected_status, response.Status, response.Message)
+    }
+    if expect.MessageContains != "" && !strings.Contains(response.Message, expect.MessageContains) {
+        return fmt.Errorf("expected message containing \"%s\" but got \"%s\"", expect.MessageContains, response.Message)
+    }
+    if expect.PayloadString != nil && string(response.Payload) != *expect.PayloadString {
+        return fmt.Errorf("expected payload \"%s\" but got \"%s\"", *expect.PayloadString, string(response.Payload))
+    }
+    if len(expect.Payload) > 0 {
+        equal, err := json_equal(expect.Payload, response.Payload)
+        if err != nil {
+            return err
+        }
+        if !equal {
+            return fmt.Errorf("expected payload %s but got %s", string(expect.Payload), string(response.Payload))
+        }
+    }
+    if len(expect.PayloadIncludes) > 0 {
+        if err := check_payload_includes(expect.PayloadIncludes, response.Payload); err != nil {
+            return err
+        }
+    }
+    if expect.Event != nil {
+        if err := expect.Event.check(stub.LastEvent(), tx_id); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (expect *EventExpectation) check (event *ChaincodeEvent, tx_id string) error {
+    if event == nil || event.TxID != tx_id {
+        return fmt.Errorf("expected event \"%s\" but none was committed", expect.Name)
+    }
+    if event.Name != expect.Name {
+        return fmt.Errorf("expected event \"%s\" but got \"%s\"", expect.Name, event.Name)
+    }
+    if len(expect.Payload) > 0 {
+        equal, err := json_equal(expect.Payload, event.Payload)
+        if err != nil {
+            return err
+        }
+        if !equal {
+            return fmt.Errorf("expected event payload %s but got %s", string(expect.Payload), string(event.Payload))
+        }
+    }
+    if len(expect.PayloadIncludes) > 0 {
+        if err := check_payload_includes(expect.PayloadIncludes, event.Payload); err != nil {
+            return fmt.Errorf("event \"%s\": %v", event.Name, err)
+        }
+    }
+    return nil
+}
+
+func check_payload_includes (expected []byte, actual []byte) error {
+    expected_value, err := decode_json(expected)
+    if err != nil {
+        return fmt.Errorf("expected payload fields %s are not valid JSON; error was %v", string(expected), err)
+    }
+    if _, ok := expected_value.(map[string]interface{}); !ok {
+        return fmt.Errorf("expected payload fields %s are not a JSON object", string(expected))
+    }
+    actual_value, err := decode_json(actual)
+    if err != nil {
+        return fmt.Errorf("payload %s is not valid JSON; error was %v", string(actual), err)
+    }
+    if !json_includes(expected_value, actual_value) {
+        return fmt.Errorf("expected payload to include fields %s but got %s", string(expected), string(actual))
+    }
+    return nil
+}
+
+// Objects match if each field of expected matches the same field of actual; other values must be equal.
+func json_includes (expected interface{}, actual interface{}) bool {
+    expected_fields, ok := expected.(map[string]interface{})
+    if !ok {
+        return reflect.DeepEqual(expected, actual)
+    }
+    actual_fields, ok := actual.(map[string]interface{})
+    if !ok {
+        return false
+    }
+    for field,expected_field_value := range expected_fields {
+        actual_field_value, ok := actual_fields[field]
+        if !ok || !json_includes(expected_field_value, actual_field_value) {
+            return false
+        }
+    }
+    return true
+}
+
+func json_equal (expected []byte, actual []byte) (bool, error) {
+    expected_value, err := decode_json(expected)
+    if err != nil {
+        return false, fmt.Errorf("expected payload %s is not valid JSON; error was %v", string(expected), err)
+    }
+    actual_value, err := decode_json(actual)
+    if err != nil {
+        return false, fmt.Errorf("payload %s is not valid JSON; error was %v", string(actual), err)
+    }
+    return reflect.DeepEqual(expected_value, actual_value), nil
+}
+
+// Numbers are decoded as json.Number so that comparisons are exact.
+func decode_json (json_bytes []byte) (interface{}, error) {
+    decoder := json.NewDecoder(bytes.NewReader(json_bytes))
+    decoder.UseNumber()
+    var value interface{}
+    err := decoder.Decode(&value)
+    return value, err
+}
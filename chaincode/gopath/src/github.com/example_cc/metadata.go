@@ -0,0 +1,307 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "regexp"
+    "time"
+)
+
+//
+// account metadata
+//
+// Each account has AccountMetadata describing who holds it, which is set by create_account and changed by the
+// admin using update_account_metadata, and an AccountActivity row recording the last transaction that modified
+// the Account row, which is written by create_account_ and overwrite_account_.  Both are kept apart from the
+// Account row, so that neither is read or written by transfers that don't need it.  Accounts created before
+// metadata existed have default metadata (KYC level 0) and no activity until they are next modified.
+//
+
+const ACCOUNT_METADATA_TABLE = "AccountMetadataTable"
+const ACCOUNT_ACTIVITY_TABLE = "AccountActivityTable"
+
+const (
+    MAX_DISPLAY_NAME_LENGTH     = 128
+    MAX_ORG_MSP_ID_LENGTH       = 128
+    MAX_ACCOUNT_ATTRIBUTES      = 32
+    MAX_ATTRIBUTE_VALUE_LENGTH  = 256
+)
+
+var attribute_name_regexp = regexp.MustCompile("^[a-z][a-z0-9_]*$")
+
+func ValidateAttributeName (attribute string) error {
+    if !attribute_name_regexp.MatchString(attribute) {
+        return coded_error(INVALID_PARAMS, "Invalid attribute name \"%s\"; must consist of lowercase letters, digits and '_', starting with a letter", attribute)
+    }
+    return nil
+}
+
+type AccountMetadata struct {
+    Account     string              `json:"Account"`
+    DisplayName string              `json:"DisplayName"`
+    // The MSP ID of the organization that owns the account.  By default, that of the account's owner (or, if it
+    // has none, of the transactor who created it).
+    OrgMspID    string              `json:"OrgMspID"`
+    // The level of know-your-customer verification of the holder; 0 (the default) is none.  Transfer limits
+    // can be set per KYC level (see KYCTransferLimits).
+    KYCLevel    int                 `json:"KYCLevel"`
+    // Any other attributes, keyed by attribute names (see ValidateAttributeName).
+    Attributes  map[string]string   `json:"Attributes"`
+    // The create_account transaction; empty (and nil) for accounts created before metadata existed.
+    CreatedTxID string              `json:"CreatedTxID"`
+    CreatedAt   *time.Time          `json:"CreatedAt,omitempty"`
+}
+
+func row_keys_of_AccountMetadata (metadata *AccountMetadata) []string {
+    return []string{metadata.Account}
+}
+
+var account_metadata_table = util.NewTable(ACCOUNT_METADATA_TABLE, AccountMetadata{}, func (row interface{}) []string { return row_keys_of_AccountMetadata(row.(*AccountMetadata)) }, nil)
+
+func (metadata *AccountMetadata) event_data () *events.AccountMetadata {
+    return &events.AccountMetadata{Account:metadata.Account, DisplayName:metadata.DisplayName, OrgMspID:metadata.OrgMspID, KYCLevel:metadata.KYCLevel, Attributes:metadata.Attributes}
+}
+
+// A change to AccountMetadata, as given to create_account and update_account_metadata as a JSON object, e.g.
+// {"DisplayName":"Alice Smith","KYCLevel":2,"Attributes":{"country":"NZ"}}.  Omitted fields are unchanged,
+// and an attribute whose value is empty is removed.
+type AccountMetadataUpdate struct {
+    DisplayName *string             `json:"DisplayName"`
+    OrgMspID    *string             `json:"OrgMspID"`
+    KYCLevel    *int                `json:"KYCLevel"`
+    Attributes  map[string]string   `json:"Attributes"`
+}
+
+func parse_account_metadata_update (s string) (*AccountMetadataUpdate, error) {
+    var update AccountMetadataUpdate
+    err := json.Unmarshal([]byte(s), &update)
+    if err != nil {
+        return nil, coded_error(INVALID_PARAMS, "Malformed metadata \"%s\"; expecting a JSON object such as {\"DisplayName\":\"Alice Smith\",\"KYCLevel\":2}", s)
+    }
+    if update.DisplayName != nil && len(*update.DisplayName) > MAX_DISPLAY_NAME_LENGTH {
+        return nil, coded_error(INVALID_PARAMS, "Invalid DisplayName; it is longer than %d bytes", MAX_DISPLAY_NAME_LENGTH)
+    }
+    if update.OrgMspID != nil && len(*update.OrgMspID) > MAX_ORG_MSP_ID_LENGTH {
+        return nil, coded_error(INVALID_PARAMS, "Invalid OrgMspID; it is longer than %d bytes", MAX_ORG_MSP_ID_LENGTH)
+    }
+    if update.KYCLevel != nil && *update.KYCLevel < 0 {
+        return nil, coded_error(INVALID_PARAMS, "Invalid KYCLevel %d; expecting non-negative integer", *update.KYCLevel)
+    }
+    for attribute,value := range update.Attributes {
+        if err := ValidateAttributeName(attribute); err != nil {
+            return nil, err
+        }
+        if len(value) > MAX_ATTRIBUTE_VALUE_LENGTH {
+            return nil, coded_error(INVALID_PARAMS, "Invalid value of attribute \"%s\"; it is longer than %d bytes", attribute, MAX_ATTRIBUTE_VALUE_LENGTH)
+        }
+    }
+    return &update, nil
+}
+
+func (update *AccountMetadataUpdate) apply_to (metadata *AccountMetadata) error {
+    if update.DisplayName != nil {
+        metadata.DisplayName = *update.DisplayName
+    }
+    if update.OrgMspID != nil {
+        metadata.OrgMspID = *update.OrgMspID
+    }
+    if update.KYCLevel != nil {
+        metadata.KYCLevel = *update.KYCLevel
+    }
+    for attribute,value := range update.Attributes {
+        if value == "" {
+            delete(metadata.Attributes, attribute)
+        } else {
+            metadata.Attributes[attribute] = value
+        }
+    }
+    if len(metadata.Attributes) > MAX_ACCOUNT_ATTRIBUTES {
+        return fmt.Errorf("Account \"%s\" can't have more than %d attributes", metadata.Account, MAX_ACCOUNT_ATTRIBUTES)
+    }
+    return nil
+}
+
+// The last transaction that modified an Account row.
+type AccountActivity struct {
+    Account     string      `json:"Account"`
+    TxID        string      `json:"TxID"`
+    Timestamp   time.Time   `json:"Timestamp"`
+}
+
+func row_keys_of_AccountActivity (activity *AccountActivity) []string {
+    return []string{activity.Account}
+}
+
+var account_activity_table = util.NewTable(ACCOUNT_ACTIVITY_TABLE, AccountActivity{}, func (row interface{}) []string { return row_keys_of_AccountActivity(row.(*AccountActivity)) }, nil)
+
+// Everything recorded about an account, as returned by query_account.  LastActivity is nil if the account
+// hasn't been modified since before activity was recorded.
+type AccountRecord struct {
+    Account         *Account            `json:"Account"`
+    Metadata        *AccountMetadata    `json:"Metadata"`
+    LastActivity    *AccountActivity    `json:"LastActivity"`
+}
+
+// Raw form of function which does no permissions checking.  Returns default metadata if the account has none.
+func get_account_metadata_ (stub shim.ChaincodeStubInterface, account_name string) (*AccountMetadata, error) {
+    metadata := AccountMetadata{Account:account_name}
+    err := account_metadata_table.Get(stub, row_keys_of_AccountMetadata(&metadata), &metadata)
+    if err != nil && !util.IsNotFound(err) {
+        return nil, wrap_error(err, "Could not retrieve metadata of account \"%s\"; error was %v", account_name, err.Error())
+    }
+    if metadata.Attributes == nil {
+        metadata.Attributes = make(map[string]string)
+    }
+    return &metadata, nil
+}
+
+// Raw form of function which does no permissions checking
+func put_account_metadata_ (stub shim.ChaincodeStubInterface, metadata *AccountMetadata) error {
+    _,err := account_metadata_table.Upsert(stub, metadata, nil)
+    if err != nil {
+        return wrap_error(err, "Could not store metadata of account \"%s\"; error was %v", metadata.Account, err.Error())
+    }
+    return nil
+}
+
+// Raw form of function which does no permissions checking.  Records that this transaction modified the account.
+// The row is written without being read, so this may be called any number of times in a transaction.
+func put_account_activity_ (stub shim.ChaincodeStubInterface, account_name string) error {
+    tx_time,err := get_tx_time(stub)
+    if err != nil {
+        return err
+    }
+    activity := &AccountActivity{Account:account_name, TxID:stub.GetTxID(), Timestamp:tx_time}
+    _,err = account_activity_table.Upsert(stub, activity, nil)
+    if err != nil {
+        return wrap_error(err, "Could not record activity of account \"%s\"; error was %v", account_name, err.Error())
+    }
+    return nil
+}
+
+// Raw form of function which does no permissions checking.  Returns nil if no activity was recorded.
+func get_account_activity_ (stub shim.ChaincodeStubInterface, account_name string) (*AccountActivity, error) {
+    activity := AccountActivity{Account:account_name}
+    err := account_activity_table.Get(stub, row_keys_of_AccountActivity(&activity), &activity)
+    if util.IsNotFound(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, wrap_error(err, "Could not retrieve activity of account \"%s\"; error was %v", account_name, err.Error())
+    }
+    return &activity, nil
+}
+
+// Raw form of function which does no permissions checking.  Deletes the metadata and activity of the account.
+func delete_account_metadata_ (stub shim.ChaincodeStubInterface, account_name string) error {
+    err := account_metadata_table.Delete(stub, []string{account_name}, nil)
+    if err != nil && !util.IsNotFound(err) {
+        return err
+    }
+    err = account_activity_table.Delete(stub, []string{account_name}, nil)
+    if util.IsNotFound(err) {
+        return nil
+    }
+    return err
+}
+
+//
+// account metadata related chaincode API functions
+//
+
+// Changes the metadata of an account.  Args are account_name and the change, as a JSON AccountMetadataUpdate.
+func (t *SimpleChaincode) update_account_metadata (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 2; account_name and metadata")
+    }
+
+    // only Admin is allowed to update_account_metadata
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to update_account_metadata")
+    }
+
+    account_name := args[0]
+    update,err := parse_account_metadata_update(args[1])
+    if err != nil {
+        return error_response(err)
+    }
+    account,err := get_account_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if account.Status == ACCOUNT_CLOSED {
+        return shim.Error(fmt.Sprintf("Could not update metadata of account \"%s\" because it is closed", account_name))
+    }
+
+    metadata,err := get_account_metadata_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    err = update.apply_to(metadata)
+    if err != nil {
+        return error_response(err)
+    }
+    err = put_account_metadata_(stub, metadata)
+    if err != nil {
+        return error_response(err)
+    }
+
+    err = emit_event(stub, events.ACCOUNT_METADATA_UPDATED, metadata.event_data())
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(metadata)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing metadata failed in update_account_metadata because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// Query everything recorded about an account, as an AccountRecord.  The single arg is account_name.  The
+// account holder is allowed to query_account, as is anyone with permission to query_balance.
+func (t *SimpleChaincode) query_account (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1; account_name")
+    }
+
+    account_name := args[0]
+    is_holder,err := transactor_is_account_owner(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_holder {
+        err = check_permission(stub, "query_balance", fmt.Sprintf("query account \"%s\"", account_name))
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    record := &AccountRecord{}
+    record.Account,err = get_account_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    record.Metadata,err = get_account_metadata_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    record.LastActivity,err = get_account_activity_(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(record)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing account failed in query_account because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
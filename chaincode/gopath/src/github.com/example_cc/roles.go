@@ -0,0 +1,366 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "regexp"
+)
+
+//
+// role-based access control
+//
+// The admin (see Admin) is the super-admin: it implicitly holds every role, and is the only user allowed to
+// grant and revoke roles or to change which roles each chaincode function requires.  Everyone else is
+// authorized to call a function if they hold at least one of the roles it requires.  Both the role grants and
+// the per-function required roles are stored as rows in CONFIG_TABLE.
+//
+
+const (
+    ACCOUNT_MANAGER_ROLE    = "account_manager"
+    AUDITOR_ROLE            = "auditor"
+    TREASURER_ROLE          = "treasurer"
+    ISSUER_ROLE             = "issuer"
+    KEEPER_ROLE             = "keeper"
+)
+
+// The roles required by each permission-checked function until set_function_roles is used to change them.
+// Functions that aren't listed here can't be given required roles.
+var default_function_roles = map[string][]string{
+    "create_account":       {ACCOUNT_MANAGER_ROLE},
+    "delete_account":       {ACCOUNT_MANAGER_ROLE},
+    "set_account_owner":    {ACCOUNT_MANAGER_ROLE},
+    "transfer":             {TREASURER_ROLE},
+    "query_balance":        {AUDITOR_ROLE, TREASURER_ROLE},
+    "query_account_names":  {AUDITOR_ROLE, ACCOUNT_MANAGER_ROLE},
+    "query_assets":         {AUDITOR_ROLE, ACCOUNT_MANAGER_ROLE, TREASURER_ROLE},
+    "mint":                 {ISSUER_ROLE},
+    "burn":                 {ISSUER_ROLE},
+    "query_total_supply":   {AUDITOR_ROLE, ISSUER_ROLE},
+    "query_role_grants":    {AUDITOR_ROLE},
+    "query_function_roles": {AUDITOR_ROLE},
+    "query_admin":          {AUDITOR_ROLE},
+    "run_due_transfers":    {KEEPER_ROLE},
+    "run_migrations":       {KEEPER_ROLE},
+}
+
+var role_name_regexp = regexp.MustCompile("^[a-z][a-z0-9_]*$")
+
+func ValidateRoleName (role string) error {
+    if !role_name_regexp.MatchString(role) {
+        return coded_error(INVALID_PARAMS, "Invalid role name \"%s\"; must consist of lowercase letters, digits and '_', starting with a letter", role)
+    }
+    return nil
+}
+
+type RoleGrant struct {
+    Role        string      `json:"Role"`
+    Identity    Identity    `json:"Identity"`
+}
+
+func row_keys_of_RoleGrant (grant *RoleGrant) []string {
+    return []string{"RoleGrant", grant.Role, grant.Identity.MspID, grant.Identity.Subject, grant.Identity.Issuer}
+}
+
+var role_grant_table = util.NewTable(CONFIG_TABLE, RoleGrant{}, func (row interface{}) []string { return row_keys_of_RoleGrant(row.(*RoleGrant)) }, nil)
+
+type FunctionRoles struct {
+    Function    string      `json:"Function"`
+    Roles       []string    `json:"Roles"`
+}
+
+func row_keys_of_FunctionRoles (function_roles *FunctionRoles) []string {
+    return []string{"FunctionRoles", function_roles.Function}
+}
+
+var function_roles_table = util.NewTable(CONFIG_TABLE, FunctionRoles{}, func (row interface{}) []string { return row_keys_of_FunctionRoles(row.(*FunctionRoles)) }, nil)
+
+// Raw form of function which does no permissions checking.  Granting an already-granted role is not an error.
+func grant_role_ (stub shim.ChaincodeStubInterface, grant *RoleGrant) error {
+    _,err := role_grant_table.Upsert(stub, grant, nil)
+    return err
+}
+
+// Raw form of function which does no permissions checking
+func revoke_role_ (stub shim.ChaincodeStubInterface, grant *RoleGrant) error {
+    err := role_grant_table.Delete(stub, row_keys_of_RoleGrant(grant), nil)
+    if err != nil {
+        return wrap_error(err, "Could not revoke role \"%s\" from %v; error was %v", grant.Role, &grant.Identity, err.Error())
+    }
+    return nil
+}
+
+func identity_has_role_ (stub shim.ChaincodeStubInterface, identity *Identity, role string) (bool, error) {
+    return role_grant_table.Exists(stub, row_keys_of_RoleGrant(&RoleGrant{Role:role, Identity:*identity}))
+}
+
+// If role is empty, then the grants of all roles are returned.
+func get_role_grants_ (stub shim.ChaincodeStubInterface, role string) ([]RoleGrant, error) {
+    row_keys := []string{"RoleGrant"}
+    if role != "" {
+        row_keys = append(row_keys, role)
+    }
+    var grants []RoleGrant
+    err := role_grant_table.Scan(stub, row_keys, &grants)
+    if err != nil {
+        return nil, wrap_error(err, "Could not get role grants; %v", err.Error())
+    }
+    return grants, nil
+}
+
+// Returns the roles required by the given function, as set by set_function_roles, or else the default.
+func get_function_roles_ (stub shim.ChaincodeStubInterface, function string) ([]string, error) {
+    default_roles,ok := default_function_roles[function]
+    if !ok {
+        return nil, fmt.Errorf("Function \"%s\" is not permission-checked", function)
+    }
+    var function_roles FunctionRoles
+    err := function_roles_table.Get(stub, row_keys_of_FunctionRoles(&FunctionRoles{Function:function}), &function_roles)
+    if util.IsNotFound(err) {
+        return default_roles, nil
+    }
+    if err != nil {
+        return nil, wrap_error(err, "Could not retrieve roles for function \"%s\"; error was %v", function, err.Error())
+    }
+    return function_roles.Roles, nil
+}
+
+// Raw form of function which does no permissions checking
+func set_function_roles_ (stub shim.ChaincodeStubInterface, function_roles *FunctionRoles) error {
+    if _,ok := default_function_roles[function_roles.Function]; !ok {
+        return fmt.Errorf("Function \"%s\" is not permission-checked", function_roles.Function)
+    }
+    for _,role := range function_roles.Roles {
+        if err := ValidateRoleName(role); err != nil {
+            return err
+        }
+    }
+    _,err := function_roles_table.Upsert(stub, function_roles, nil)
+    return err
+}
+
+// An error is returned only if the transactor's identity could not be determined or the ledger could not be read.
+func transactor_has_permission (stub shim.ChaincodeStubInterface, function string) (bool, error) {
+    transactor,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return false, err
+    }
+    return identity_has_permission_(stub, transactor, function)
+}
+
+// Raw form of function which does no permissions checking.  Returns whether identity would be authorized to
+// call function, e.g. for something done later on its behalf.  An error is returned only if the ledger could
+// not be read; if the admin can't be retrieved, then identity is not considered to be the admin.
+func identity_has_permission_ (stub shim.ChaincodeStubInterface, identity *Identity, function string) (bool, error) {
+    admin,err := get_admin(stub)
+    if err == nil && *identity == admin.Identity {
+        return true, nil
+    }
+    roles,err := get_function_roles_(stub, function)
+    if err != nil {
+        return false, err
+    }
+    for _,role := range roles {
+        has_role,err := identity_has_role_(stub, identity, role)
+        if err != nil {
+            return false, err
+        }
+        if has_role {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// Returns nil if the transactor is authorized to call function, and otherwise an error describing why not.
+// action describes what was being attempted, for use in the error message.
+func check_permission (stub shim.ChaincodeStubInterface, function string, action string) error {
+    has_permission,err := transactor_has_permission(stub, function)
+    if err != nil {
+        return err
+    }
+    if has_permission {
+        return nil
+    }
+    transactor,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return err
+    }
+    roles,err := get_function_roles_(stub, function)
+    if err != nil {
+        return err
+    }
+    return coded_error(UNAUTHORIZED, "User %v is not authorized to %s; requires being the admin or having one of the roles %v", transactor, action, roles)
+}
+
+//
+// role related chaincode API functions
+//
+
+func parse_role_grant_args (args []string) (*RoleGrant, error) {
+    if len(args) != 3 {
+        return nil, coded_error(INVALID_PARAMS, "Incorrect number of arguments. Expecting 3; role, msp_id and cert_pem")
+    }
+    role := args[0]
+    if err := ValidateRoleName(role); err != nil {
+        return nil, err
+    }
+    identity,err := IdentityFromCertificatePEM(args[1], []byte(args[2]))
+    if err != nil {
+        return nil, coded_error(INVALID_PARAMS, "Invalid identity for role \"%s\"; %v", role, err.Error())
+    }
+    return &RoleGrant{Role:role, Identity:*identity}, nil
+}
+
+// Grants a role to the identity given by an MSP ID and PEM-encoded certificate.
+func (t *SimpleChaincode) grant_role (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    grant,err := parse_role_grant_args(args)
+    if err != nil {
+        return error_response(err)
+    }
+
+    // only Admin is allowed to grant_role
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to grant_role")
+    }
+
+    err = grant_role_(stub, grant)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not grant role \"%s\" to %v; error was %v", grant.Role, &grant.Identity, err.Error()))
+    }
+
+    err = emit_event(stub, events.ROLE_GRANTED, &events.RoleGrant{Role:grant.Role, Identity:events.Identity(grant.Identity)})
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Revokes a role from the identity given by an MSP ID and PEM-encoded certificate.
+func (t *SimpleChaincode) revoke_role (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    grant,err := parse_role_grant_args(args)
+    if err != nil {
+        return error_response(err)
+    }
+
+    // only Admin is allowed to revoke_role
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to revoke_role")
+    }
+
+    err = revoke_role_(stub, grant)
+    if err != nil {
+        return error_response(err)
+    }
+
+    err = emit_event(stub, events.ROLE_REVOKED, &events.RoleGrant{Role:grant.Role, Identity:events.Identity(grant.Identity)})
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Sets the roles (given as a JSON array of role names) of which the transactor must hold at least one in order
+// to call the given function.  An empty array restricts the function to the admin.
+func (t *SimpleChaincode) set_function_roles (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 2; function and roles (JSON array)")
+    }
+
+    // only Admin is allowed to set_function_roles
+    is_admin,err := transactor_is_admin(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_admin {
+        return coded_error_response(UNAUTHORIZED, "Only admin user is authorized to set_function_roles")
+    }
+
+    function_roles := FunctionRoles{Function:args[0], Roles:[]string{}}
+    err = json.Unmarshal([]byte(args[1]), &function_roles.Roles)
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Malformed roles \"%s\"; expecting a JSON array of role names", args[1])
+    }
+
+    err = set_function_roles_(stub, &function_roles)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not set_function_roles; %v", err.Error()))
+    }
+
+    err = emit_event(stub, events.FUNCTION_ROLES_SET, &events.FunctionRolesSet{Function:function_roles.Function, Roles:function_roles.Roles})
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Query the role grants, optionally only those of the role given as the single arg.
+func (t *SimpleChaincode) query_role_grants (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) > 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 or 1 arguments, got %v", args)
+    }
+
+    err := check_permission(stub, "query_role_grants", "query_role_grants")
+    if err != nil {
+        return error_response(err)
+    }
+
+    role := ""
+    if len(args) == 1 {
+        role = args[0]
+        if err = ValidateRoleName(role); err != nil {
+            return error_response(err)
+        }
+    }
+    grants,err := get_role_grants_(stub, role)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not query_role_grants due to error %v", err.Error()))
+    }
+
+    bytes,err := json.Marshal(grants)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing role grants failed in query_role_grants because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// Query the roles required by each permission-checked function, as a JSON object mapping function name to roles.
+func (t *SimpleChaincode) query_function_roles (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 arguments, got %v", args)
+    }
+
+    err := check_permission(stub, "query_function_roles", "query_function_roles")
+    if err != nil {
+        return error_response(err)
+    }
+
+    roles_of_function := make(map[string][]string)
+    for function := range default_function_roles {
+        roles,err := get_function_roles_(stub, function)
+        if err != nil {
+            return error_response(wrap_error(err, "Could not query_function_roles due to error %v", err.Error()))
+        }
+        roles_of_function[function] = roles
+    }
+
+    bytes,err := json.Marshal(roles_of_function)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing function roles failed in query_function_roles because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
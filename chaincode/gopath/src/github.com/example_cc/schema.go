@@ -0,0 +1,257 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "strconv"
+)
+
+//
+// schema versioning and data migration
+//
+// The SchemaVersion row in CONFIG_TABLE records the version of the data layout on the ledger.  A ledger
+// instantiated by this chaincode starts at CURRENT_SCHEMA_VERSION; a ledger instantiated before the row existed
+// is at version 1.  When Init is called for a chaincode upgrade and the ledger is behind, the registered
+// migrations bring it up to date, one version at a time, as run_migrations is called repeatedly.  Each call
+// migrates a bounded number of rows and records where it stopped, so that a large table can be migrated over
+// many transactions.  Functions which change the ledger are refused until the migration is complete.
+//
+
+// A migration which brings the ledger data from one schema version to the next, by rewriting the rows of a
+// util table.
+type Migration struct {
+    // Describes the change to the data layout, for query_schema_version.
+    Description string
+    // The table whose rows are migrated.
+    Table       *util.Table
+    // Returns the migrated form of a row of Table (a pointer to its row type), or nil if it needs no change.
+    // This must be idempotent, so that a row may safely be migrated more than once.
+    migrate_row func (stub shim.ChaincodeStubInterface, row_json_bytes []byte) (row interface{}, err error)
+}
+
+// migrations[i] migrates from schema version i+1 to i+2.  Migrations must never be removed or reordered, only
+// appended, since ledgers may be at any earlier version.
+var migrations = []Migration{
+    {
+        Description:"Adds the Status and Held fields to the Account rows stored before they existed, and brings balances to the ledger's number of decimals.",
+        Table:account_table,
+        migrate_row:migrate_account_row,
+    },
+    {
+        Description:"Builds the Status and OwnerMspID indexes of the Account rows.",
+        Table:account_table,
+        migrate_row:reindex_account_row,
+    },
+}
+
+var CURRENT_SCHEMA_VERSION = 1 + len(migrations)
+
+// The number of rows run_migrations migrates unless told otherwise.
+const DEFAULT_MIGRATION_BATCH_SIZE = 100
+const MAX_MIGRATION_BATCH_SIZE = 1000
+
+type SchemaVersion struct {
+    // The version of the data layout on the ledger.
+    Version         int         `json:"Version"`
+    // While the ledger is being migrated from Version to Version+1, the row keys of the next row to migrate,
+    // or empty if the migration hasn't started.
+    Bookmark        []string    `json:"Bookmark,omitempty"`
+    // The number of rows migrated from Version to Version+1 so far.
+    RowsMigrated    int         `json:"RowsMigrated"`
+}
+
+// The payload of query_schema_version and run_migrations.
+type SchemaStatus struct {
+    SchemaVersion
+    // The version expected by the chaincode.
+    CurrentVersion  int         `json:"CurrentVersion"`
+    // The descriptions of the migrations yet to be completed, in order.
+    PendingMigrations   []string    `json:"PendingMigrations"`
+}
+
+func (schema *SchemaVersion) status () *SchemaStatus {
+    status := &SchemaStatus{SchemaVersion:*schema, CurrentVersion:CURRENT_SCHEMA_VERSION, PendingMigrations:[]string{}}
+    for version := schema.Version; version < CURRENT_SCHEMA_VERSION; version++ {
+        status.PendingMigrations = append(status.PendingMigrations, migrations[version-1].Description)
+    }
+    return status
+}
+
+var schema_version_table = util.NewTable(CONFIG_TABLE, SchemaVersion{}, util.FixedRowKeys("SchemaVersion"), nil)
+
+// Raw form of function which does no permissions checking.  A ledger without the row predates it, so is at
+// version 1.
+func get_schema_version_ (stub shim.ChaincodeStubInterface) (*SchemaVersion, error) {
+    schema := SchemaVersion{Version:1}
+    err := schema_version_table.Get(stub, schema_version_table.RowKeys(&schema), &schema)
+    if err != nil && !util.IsNotFound(err) {
+        return nil, wrap_error(err, "Could not retrieve SchemaVersion; error was %v", err.Error())
+    }
+    return &schema, nil
+}
+
+// Raw form of function which does no permissions checking
+func set_schema_version_ (stub shim.ChaincodeStubInterface, schema *SchemaVersion) error {
+    _,err := schema_version_table.Upsert(stub, schema, nil)
+    if err != nil {
+        return wrap_error(err, "Error setting %s SchemaVersion value to %v; error was %v", CONFIG_TABLE, schema, err.Error())
+    }
+    return nil
+}
+
+// Returns an error if the ledger is being migrated, in which case it must not be changed other than by
+// run_migrations.
+func check_schema_is_current (stub shim.ChaincodeStubInterface) error {
+    schema,err := get_schema_version_(stub)
+    if err != nil {
+        return err
+    }
+    if schema.Version < CURRENT_SCHEMA_VERSION {
+        return coded_error(MIGRATION_PENDING, "Ledger data is being migrated from schema version %d to %d; run_migrations must be called until the migration is complete", schema.Version, CURRENT_SCHEMA_VERSION)
+    }
+    return nil
+}
+
+// Raw form of function which does no permissions checking.  Migrates up to max_rows rows towards the next
+// schema version, returning the number of rows migrated.  At most one migration is worked on per call, since
+// the rows written by a transaction can't be read back by it for the next migration.
+func run_migrations_ (stub shim.ChaincodeStubInterface, max_rows int) (*SchemaVersion, int, error) {
+    schema,err := get_schema_version_(stub)
+    if err != nil {
+        return nil, 0, err
+    }
+    if schema.Version >= CURRENT_SCHEMA_VERSION {
+        return schema, 0, nil
+    }
+
+    migration := &migrations[schema.Version-1]
+    // The rows are read undecoded, since they may be in a layout that the row type no longer describes.
+    rows,next_row_keys,err := util.GetTableRowsPage(stub, migration.Table.Name, []string{}, "", schema.Bookmark, max_rows)
+    if err != nil {
+        return nil, 0, wrap_error(err, "Could not migrate %s from schema version %d; %v", migration.Table.Name, schema.Version, err.Error())
+    }
+    for _,row_json_bytes := range rows {
+        row,err := migration.migrate_row(stub, row_json_bytes)
+        if err != nil {
+            return nil, 0, wrap_error(err, "Could not migrate %s row \"%s\" from schema version %d; %v", migration.Table.Name, string(row_json_bytes), schema.Version, err.Error())
+        }
+        if row == nil {
+            continue
+        }
+        err = migration.Table.Update(stub, row)
+        if err != nil {
+            return nil, 0, wrap_error(err, "Could not migrate %s row %v from schema version %d; %v", migration.Table.Name, migration.Table.RowKeys(row), schema.Version, err.Error())
+        }
+    }
+
+    if len(next_row_keys) == 0 {
+        fmt.Printf("Migrated %d rows of %s from schema version %d to %d\n", schema.RowsMigrated+len(rows), migration.Table.Name, schema.Version, schema.Version+1)
+        *schema = SchemaVersion{Version:schema.Version+1}
+    } else {
+        schema.Bookmark = next_row_keys
+        schema.RowsMigrated += len(rows)
+    }
+    err = set_schema_version_(stub, schema)
+    if err != nil {
+        return nil, 0, err
+    }
+    return schema, len(rows), nil
+}
+
+// Migration from schema version 1 to 2.
+func migrate_account_row (stub shim.ChaincodeStubInterface, row_json_bytes []byte) (interface{}, error) {
+    var account Account
+    err := json.Unmarshal(row_json_bytes, &account)
+    if err != nil {
+        return nil, err
+    }
+    decimals,err := get_ledger_decimals(stub)
+    if err != nil {
+        return nil, err
+    }
+    err = normalize_account_(&account, decimals)
+    if err != nil {
+        return nil, err
+    }
+    account_json_bytes,err := json.Marshal(&account)
+    if err != nil {
+        return nil, err
+    }
+    if bytes.Equal(account_json_bytes, row_json_bytes) {
+        return nil, nil
+    }
+    return &account, nil
+}
+
+//
+// schema related chaincode API functions
+//
+
+// Migrates the next batch of rows of the ledger towards the current schema version.  The optional arg is the
+// maximum number of rows to migrate, which defaults to DEFAULT_MIGRATION_BATCH_SIZE.  The payload is a
+// SchemaStatus; this must be called until its PendingMigrations is empty.
+func (t *SimpleChaincode) run_migrations (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) > 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 or 1 arguments, got %v", args)
+    }
+
+    max_rows := DEFAULT_MIGRATION_BATCH_SIZE
+    if len(args) == 1 && args[0] != "" {
+        var err error
+        max_rows,err = strconv.Atoi(args[0])
+        if err != nil || max_rows < 1 || max_rows > MAX_MIGRATION_BATCH_SIZE {
+            return coded_error_response(INVALID_PARAMS, "Invalid max_rows \"%s\"; expecting integer between 1 and %d", args[0], MAX_MIGRATION_BATCH_SIZE)
+        }
+    }
+
+    err := check_permission(stub, "run_migrations", "run_migrations")
+    if err != nil {
+        return error_response(err)
+    }
+
+    old_schema,err := get_schema_version_(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    schema,rows_migrated,err := run_migrations_(stub, max_rows)
+    if err != nil {
+        return error_response(err)
+    }
+
+    if schema.Version != old_schema.Version || rows_migrated > 0 {
+        err = emit_event(stub, events.SCHEMA_MIGRATED, &events.SchemaMigrated{Version:schema.Version, CurrentVersion:CURRENT_SCHEMA_VERSION, RowsMigrated:rows_migrated})
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    bytes,err := json.Marshal(schema.status())
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing schema status failed in run_migrations because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// Query the schema version of the ledger and the migrations pending.  Anyone may query it, since clients need
+// to know when a migration is blocking changes.
+func (t *SimpleChaincode) query_schema_version (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 arguments, got %v", args)
+    }
+
+    schema,err := get_schema_version_(stub)
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(schema.status())
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing schema status failed in query_schema_version because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
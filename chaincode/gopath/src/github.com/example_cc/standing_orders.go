@@ -0,0 +1,522 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/decimal"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "time"
+)
+
+//
+// standing orders
+//
+// A standing order transfers an amount of the default asset from one account to another at its Start time and
+// then every Interval until its End time (or only once, if it has no Interval).  Nothing happens on a ledger by
+// itself, so a keeper (anyone with permission to run_due_transfers) must call run_due_transfers periodically,
+// which makes every transfer that is due as of the transaction time.  Each transfer is made on behalf of the
+// order's creator, with the same authorization and checks as transfer, and is recorded as a
+// StandingOrderExecution row whether or not it succeeded; a failed transfer is not retried.  Only orders with
+// transfers still to make are stored, as StandingOrder rows keyed by the "from" account.
+//
+
+const STANDING_ORDER_TABLE = "StandingOrderTable"
+const STANDING_ORDER_EXECUTION_TABLE = "StandingOrderExecutionTable"
+
+// The most transfers that run_due_transfers makes in one transaction; the rest remain due.
+const MAX_DUE_TRANSFERS_PER_RUN = 100
+
+type StandingOrder struct {
+    // The ID of the create_standing_order transaction.
+    OrderID     string          `json:"OrderID"`
+    FromAccount string          `json:"FromAccount"`
+    ToAccount   string          `json:"ToAccount"`
+    Amount      decimal.Amount  `json:"Amount"`
+    // The transactor who created the order, on whose behalf the transfers are made.
+    Creator     Identity        `json:"Creator"`
+    Start       time.Time       `json:"Start"`
+    // A Go duration (e.g. "24h"), or empty if the order makes a single transfer.
+    Interval    string          `json:"Interval,omitempty"`
+    // No transfer is due after this time; nil if the order doesn't end.
+    End         *time.Time      `json:"End,omitempty"`
+    // When the next transfer is due.
+    NextDue     time.Time       `json:"NextDue"`
+    // The number of transfers attempted so far.
+    Executions  int             `json:"Executions"`
+}
+
+func row_keys_of_StandingOrder (order *StandingOrder) []string {
+    return []string{order.FromAccount, order.OrderID}
+}
+
+var standing_order_table = util.NewTable(STANDING_ORDER_TABLE, StandingOrder{}, func (row interface{}) []string { return row_keys_of_StandingOrder(row.(*StandingOrder)) }, nil)
+
+func (order *StandingOrder) event_data () events.StandingOrder {
+    return events.StandingOrder{OrderID:order.OrderID, FromAccount:order.FromAccount, ToAccount:order.ToAccount, Amount:order.Amount.String(), Start:order.Start, Interval:order.Interval, End:order.End}
+}
+
+// Moves NextDue past the transfer that was due, returning false if the order has no transfers left.
+func (order *StandingOrder) advance () (bool, error) {
+    order.Executions++
+    if order.Interval == "" {
+        return false, nil
+    }
+    interval,err := time.ParseDuration(order.Interval)
+    if err != nil {
+        return false, fmt.Errorf("Standing order \"%s\" has an invalid interval \"%s\"", order.OrderID, order.Interval)
+    }
+    order.NextDue = order.NextDue.Add(interval)
+    return order.End == nil || !order.NextDue.After(*order.End), nil
+}
+
+// The record of one transfer attempted for a standing order.
+type StandingOrderExecution struct {
+    OrderID     string          `json:"OrderID"`
+    // 1 for the first transfer of the order, and so on.
+    Sequence    int             `json:"Sequence"`
+    Due         time.Time       `json:"Due"`
+    // The run_due_transfers transaction which attempted the transfer.
+    TxID        string          `json:"TxID"`
+    Timestamp   time.Time       `json:"Timestamp"`
+    FromAccount string          `json:"FromAccount"`
+    ToAccount   string          `json:"ToAccount"`
+    Amount      decimal.Amount  `json:"Amount"`
+    // Nil if the transfer was free or wasn't made.
+    Fee         *TransferFee    `json:"Fee,omitempty"`
+    // Why the transfer wasn't made, or empty if it was.
+    Error       string          `json:"Error,omitempty"`
+}
+
+// The sequence number is zero-padded so that the executions of an order are in order.
+func row_keys_of_StandingOrderExecution (execution *StandingOrderExecution) []string {
+    return []string{execution.FromAccount, execution.OrderID, fmt.Sprintf("%010d", execution.Sequence)}
+}
+
+var standing_order_execution_table = util.NewTable(STANDING_ORDER_EXECUTION_TABLE, StandingOrderExecution{}, func (row interface{}) []string { return row_keys_of_StandingOrderExecution(row.(*StandingOrderExecution)) }, nil)
+
+func (execution *StandingOrderExecution) event_data () events.StandingOrderExecution {
+    event_data := events.StandingOrderExecution{OrderID:execution.OrderID, Sequence:execution.Sequence, Due:execution.Due, FromAccount:execution.FromAccount, ToAccount:execution.ToAccount, Amount:execution.Amount.String(), Error:execution.Error}
+    if execution.Fee != nil {
+        event_data.Fee = execution.Fee.Amount.String()
+        event_data.FeeCollector = execution.Fee.Collector
+    }
+    return event_data
+}
+
+// The payload of run_due_transfers.  MoreDue is true if some transfers are still due because the run made
+// MAX_DUE_TRANSFERS_PER_RUN transfers already.
+type DueTransfersReport struct {
+    Executions  []StandingOrderExecution    `json:"Executions"`
+    MoreDue     bool                        `json:"MoreDue"`
+}
+
+// Raw form of function which does no permissions checking
+func get_standing_order_ (stub shim.ChaincodeStubInterface, account_name string, order_id string) (*StandingOrder, error) {
+    order := StandingOrder{FromAccount:account_name, OrderID:order_id}
+    err := standing_order_table.Get(stub, row_keys_of_StandingOrder(&order), &order)
+    if util.IsNotFound(err) {
+        return nil, coded_error(NOT_FOUND, "Account \"%s\" has no standing order \"%s\"; it may have finished or been cancelled", account_name, order_id)
+    }
+    if err != nil {
+        return nil, wrap_error(err, "Could not retrieve standing order \"%s\" of account \"%s\"; error was %v", order_id, account_name, err.Error())
+    }
+    return &order, nil
+}
+
+// Raw form of function which does no permissions checking.  Returns the standing orders from the account, or
+// from every account if account_name is empty.
+func get_standing_orders_ (stub shim.ChaincodeStubInterface, account_name string) ([]StandingOrder, error) {
+    row_keys := []string{}
+    if account_name != "" {
+        row_keys = []string{account_name}
+    }
+    var orders []StandingOrder
+    err := standing_order_table.Scan(stub, row_keys, &orders)
+    if err != nil {
+        return nil, wrap_error(err, "Could not get standing orders of account \"%s\"; %v", account_name, err.Error())
+    }
+    return orders, nil
+}
+
+// Raw form of function which does no permissions checking.  Deletes every standing order from the account;
+// their executions are kept.
+func delete_standing_orders_ (stub shim.ChaincodeStubInterface, account_name string) error {
+    orders,err := get_standing_orders_(stub, account_name)
+    if err != nil {
+        return err
+    }
+    for i := range orders {
+        err = standing_order_table.Delete(stub, row_keys_of_StandingOrder(&orders[i]), nil)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Raw form of function which does no permissions checking.  Returns the executions of a standing order, oldest
+// first.
+func get_standing_order_executions_ (stub shim.ChaincodeStubInterface, account_name string, order_id string) ([]StandingOrderExecution, error) {
+    var executions []StandingOrderExecution
+    err := standing_order_execution_table.Scan(stub, []string{account_name, order_id}, &executions)
+    if err != nil {
+        return nil, wrap_error(err, "Could not get executions of standing order \"%s\" of account \"%s\"; %v", order_id, account_name, err.Error())
+    }
+    return executions, nil
+}
+
+// Attempts the transfer of a standing order that is due, in memory only (see move_with_fee_), as its creator
+// would be authorized to make it by transfer.  A failed transfer is described by the returned execution's
+// Error; an error is returned only if the ledger could not be read.
+func execute_standing_order_ (stub shim.ChaincodeStubInterface, cache *account_cache, tracker *outflow_tracker, order *StandingOrder, tx_time time.Time) (*StandingOrderExecution, error) {
+    execution := &StandingOrderExecution{
+        OrderID:        order.OrderID,
+        Sequence:       order.Executions + 1,
+        Due:            order.NextDue,
+        TxID:           stub.GetTxID(),
+        Timestamp:      tx_time,
+        FromAccount:    order.FromAccount,
+        ToAccount:      order.ToAccount,
+        Amount:         order.Amount,
+    }
+
+    // As for transfer, the account holder is allowed to transfer, as is anyone with permission to transfer.
+    from_account,err := cache.get(order.FromAccount)
+    if err != nil {
+        execution.Error = fmt.Sprintf("Error in retrieving \"from\" account \"%s\"; %v", order.FromAccount, err.Error())
+        return execution, nil
+    }
+    is_authorized := from_account.Owner != nil && *from_account.Owner == order.Creator
+    if !is_authorized {
+        is_authorized,err = identity_has_permission_(stub, &order.Creator, "transfer")
+        if err != nil {
+            return nil, err
+        }
+    }
+    if !is_authorized {
+        execution.Error = fmt.Sprintf("User %v is no longer authorized to transfer from account \"%s\"", &order.Creator, order.FromAccount)
+        return execution, nil
+    }
+
+    execution.Fee,err = move_with_fee_(stub, cache, tracker, order.FromAccount, order.ToAccount, order.Amount)
+    if err != nil {
+        execution.Error = err.Error()
+    }
+    return execution, nil
+}
+
+//
+// standing order related chaincode API functions
+//
+
+// Creates a standing order.  Args are from_account_name, to_account_name, amount, start (an RFC 3339 time,
+// e.g. "2017-01-31T00:00:00Z"), and optionally interval (a Go duration, e.g. "24h"; empty for a single
+// transfer) and end (an RFC 3339 time; empty for no end).  This has the same authorization as transfer.  The
+// payload is the created StandingOrder, whose OrderID identifies it in cancel_standing_order.
+func (t *SimpleChaincode) create_standing_order (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) < 4 || len(args) > 6 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 4 to 6; 2 names, 1 value, start, and optionally interval and end")
+    }
+
+    from_account_name := args[0]
+    to_account_name := args[1]
+    amount,err := parse_amount(stub, args[2])
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid standing order amount \"%s\"; %v", args[2], err.Error())
+    }
+    if amount.Sign() <= 0 {
+        return coded_error_response(INVALID_PARAMS, "Invalid standing order amount %v; expecting positive amount", amount)
+    }
+    start,err := time.Parse(time.RFC3339, args[3])
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid start \"%s\"; expecting an RFC 3339 time such as \"2017-01-31T00:00:00Z\"", args[3])
+    }
+    start = start.UTC()
+    tx_time,err := get_tx_time(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    if start.Before(tx_time) {
+        return coded_error_response(INVALID_PARAMS, "Invalid start %v; it must not be earlier than the transaction time %v", start.Format(time.RFC3339), tx_time.Format(time.RFC3339))
+    }
+    interval := ""
+    if len(args) >= 5 && args[4] != "" {
+        duration,err := time.ParseDuration(args[4])
+        if err != nil || duration <= 0 {
+            return coded_error_response(INVALID_PARAMS, "Invalid interval \"%s\"; expecting a positive duration such as \"24h\"", args[4])
+        }
+        interval = args[4]
+    }
+    var end *time.Time
+    if len(args) == 6 && args[5] != "" {
+        end_time,err := time.Parse(time.RFC3339, args[5])
+        if err != nil {
+            return coded_error_response(INVALID_PARAMS, "Invalid end \"%s\"; expecting an RFC 3339 time such as \"2017-12-31T00:00:00Z\"", args[5])
+        }
+        end_time = end_time.UTC()
+        if end_time.Before(start) {
+            return coded_error_response(INVALID_PARAMS, "Invalid end %v; it must not be earlier than the start %v", end_time.Format(time.RFC3339), start.Format(time.RFC3339))
+        }
+        end = &end_time
+    }
+
+    // The account holder is allowed to create_standing_order, as is anyone with permission to transfer (e.g.
+    // Admin).
+    is_holder,err := transactor_is_account_owner(stub, from_account_name)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_holder {
+        err = check_permission(stub, "transfer", fmt.Sprintf("transfer from account \"%s\"", from_account_name))
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    if from_account_name == to_account_name {
+        return shim.Error(fmt.Sprintf("Can't create a standing order from account \"%s\" to itself", from_account_name))
+    }
+    from_account,err := get_account_(stub, from_account_name)
+    if err != nil {
+        return error_response(wrap_error(err, "Error in retrieving \"from\" account \"%s\"; %v", from_account_name, err.Error()))
+    }
+    to_account,err := get_account_(stub, to_account_name)
+    if err != nil {
+        return error_response(wrap_error(err, "Error in retrieving \"to\" account \"%s\"; %v", to_account_name, err.Error()))
+    }
+    for _,account := range []*Account{from_account, to_account} {
+        err = check_account_is_active(account)
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    creator,err := GetTransactorIdentity(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    order := &StandingOrder{
+        OrderID:        stub.GetTxID(),
+        FromAccount:    from_account_name,
+        ToAccount:      to_account_name,
+        Amount:         amount,
+        Creator:        *creator,
+        Start:          start,
+        Interval:       interval,
+        End:            end,
+        NextDue:        start,
+    }
+    err = standing_order_table.Insert(stub, order)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not create standing order \"%s\"; error was %v", order.OrderID, err.Error()))
+    }
+
+    event_data := order.event_data()
+    err = emit_event(stub, events.STANDING_ORDER_CREATED, &event_data)
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(order)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing standing order failed in create_standing_order because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// Cancels a standing order, so that it makes no further transfers.  Args are from_account_name and order_id.
+// The creator of the order and the holder of the "from" account are allowed to cancel_standing_order, as is
+// anyone with permission to transfer (e.g. Admin).
+func (t *SimpleChaincode) cancel_standing_order (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 2; from_account_name and order_id")
+    }
+
+    from_account_name := args[0]
+    order_id := args[1]
+    order,err := get_standing_order_(stub, from_account_name, order_id)
+    if err != nil {
+        return error_response(err)
+    }
+
+    is_authorized,err := transactor_is(stub, &order.Creator)
+    if err != nil {
+        return error_response(err)
+    }
+    if !is_authorized {
+        is_authorized,err = transactor_is_account_owner(stub, from_account_name)
+        if err != nil {
+            return error_response(err)
+        }
+    }
+    if !is_authorized {
+        err = check_permission(stub, "transfer", fmt.Sprintf("cancel standing order \"%s\" of account \"%s\"", order_id, from_account_name))
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    err = standing_order_table.Delete(stub, row_keys_of_StandingOrder(order), nil)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not cancel standing order \"%s\"; error was %v", order_id, err.Error()))
+    }
+
+    event_data := order.event_data()
+    err = emit_event(stub, events.STANDING_ORDER_CANCELLED, &event_data)
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Makes every standing order transfer that is due as of the transaction time, up to MAX_DUE_TRANSFERS_PER_RUN
+// of them, oldest due first within each order.  Only keepers (anyone with permission to run_due_transfers, e.g.
+// Admin) are allowed to run_due_transfers.  The payload is a DueTransfersReport.
+func (t *SimpleChaincode) run_due_transfers (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 arguments, got %v", args)
+    }
+
+    err := check_permission(stub, "run_due_transfers", "run_due_transfers")
+    if err != nil {
+        return error_response(err)
+    }
+
+    tx_time,err := get_tx_time(stub)
+    if err != nil {
+        return error_response(err)
+    }
+    orders,err := get_standing_orders_(stub, "")
+    if err != nil {
+        return error_response(err)
+    }
+
+    cache := new_account_cache(stub)
+    tracker := new_outflow_tracker(stub)
+    report := &DueTransfersReport{Executions:[]StandingOrderExecution{}}
+    event_data := &events.DueTransfersRun{Executions:[]events.StandingOrderExecution{}}
+    for i := range orders {
+        order := &orders[i]
+        executions := order.Executions
+        is_finished := false
+        for !is_finished && !order.NextDue.After(tx_time) {
+            if len(report.Executions) == MAX_DUE_TRANSFERS_PER_RUN {
+                report.MoreDue = true
+                break
+            }
+            execution,err := execute_standing_order_(stub, cache, tracker, order, tx_time)
+            if err != nil {
+                return error_response(err)
+            }
+            err = standing_order_execution_table.Insert(stub, execution)
+            if err != nil {
+                return error_response(wrap_error(err, "Could not record execution %d of standing order \"%s\"; error was %v", execution.Sequence, order.OrderID, err.Error()))
+            }
+            report.Executions = append(report.Executions, *execution)
+            event_data.Executions = append(event_data.Executions, execution.event_data())
+
+            has_more,err := order.advance()
+            if err != nil {
+                return error_response(err)
+            }
+            is_finished = !has_more
+        }
+
+        if is_finished {
+            err = standing_order_table.Delete(stub, row_keys_of_StandingOrder(order), nil)
+        } else if order.Executions != executions {
+            err = standing_order_table.Update(stub, order)
+        }
+        if err != nil {
+            return error_response(wrap_error(err, "Could not update standing order \"%s\"; error was %v", order.OrderID, err.Error()))
+        }
+    }
+
+    err = cache.write()
+    if err != nil {
+        return error_response(wrap_error(err, "Could not run_due_transfers; %v", err.Error()))
+    }
+    err = tracker.write()
+    if err != nil {
+        return error_response(wrap_error(err, "Could not run_due_transfers; %v", err.Error()))
+    }
+
+    if len(report.Executions) > 0 {
+        err = emit_event(stub, events.DUE_TRANSFERS_RUN, event_data)
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    bytes,err := json.Marshal(report)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing report failed in run_due_transfers because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// Query the standing orders from an account.  The single arg is account_name.  The account holder is allowed
+// to query_standing_orders, as is anyone with permission to query_balance.
+func (t *SimpleChaincode) query_standing_orders (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1; account_name")
+    }
+
+    account_name := args[0]
+    err := check_standing_order_query_permission(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+
+    orders,err := get_standing_orders_(stub, account_name)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not query_standing_orders for account \"%s\"; error was %v", account_name, err.Error()))
+    }
+
+    bytes,err := json.Marshal(orders)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing standing orders failed in query_standing_orders because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// Query the transfers attempted for a standing order, oldest first, including those of orders that have
+// finished or been cancelled.  Args are from_account_name and order_id.  This has the same authorization as
+// query_standing_orders.
+func (t *SimpleChaincode) query_standing_order_executions (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 2; from_account_name and order_id")
+    }
+
+    account_name := args[0]
+    order_id := args[1]
+    err := check_standing_order_query_permission(stub, account_name)
+    if err != nil {
+        return error_response(err)
+    }
+
+    executions,err := get_standing_order_executions_(stub, account_name, order_id)
+    if err != nil {
+        return error_response(err)
+    }
+
+    bytes,err := json.Marshal(executions)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing executions failed in query_standing_order_executions because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
+
+// The account holder is allowed to query the standing orders from the account, as is anyone with permission
+// to query_balance.
+func check_standing_order_query_permission (stub shim.ChaincodeStubInterface, account_name string) error {
+    is_holder,err := transactor_is_account_owner(stub, account_name)
+    if err != nil || is_holder {
+        return err
+    }
+    return check_permission(stub, "query_balance", fmt.Sprintf("query standing orders of account \"%s\"", account_name))
+}
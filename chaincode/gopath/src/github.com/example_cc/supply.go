@@ -0,0 +1,312 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/decimal"
+    "github.com/example_cc/events"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//
+// supply
+//
+// The total supply of the default asset is the TotalSupply row in CONFIG_TABLE (the supply of any other asset
+// is Asset.Supply).  It equals the sum of all Account balances: transfers leave it unchanged, and only
+// create_account (via initial_balance), mint and burn change it.
+//
+
+type TotalSupply struct {
+    Supply  decimal.Amount  `json:"Supply"`
+}
+
+var total_supply_table = util.NewTable(CONFIG_TABLE, TotalSupply{}, util.FixedRowKeys("TotalSupply"), nil)
+
+// Raw form of function which does no permissions checking.  Ledgers created before TotalSupply existed have
+// no such row, in which case it is computed from the account balances.
+func get_total_supply_ (stub shim.ChaincodeStubInterface) (decimal.Amount, error) {
+    decimals,err := get_ledger_decimals(stub)
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+    var total_supply TotalSupply
+    err = total_supply_table.Get(stub, total_supply_table.RowKeys(&total_supply), &total_supply)
+    if err == nil {
+        return total_supply.Supply.Rescale(decimals)
+    }
+    if !util.IsNotFound(err) {
+        return decimal.Amount{}, wrap_error(err, "Could not retrieve TotalSupply; error was %v", err.Error())
+    }
+
+    var accounts []Account
+    err = account_table.Scan(stub, []string{}, &accounts)
+    if err != nil {
+        return decimal.Amount{}, wrap_error(err, "Could not compute TotalSupply; %v", err.Error())
+    }
+    supply := decimal.Zero(decimals)
+    for _,account := range accounts {
+        supply,err = supply.Add(account.Balance)
+        if err != nil {
+            return decimal.Amount{}, wrap_error(err, "Could not compute TotalSupply; %v", err.Error())
+        }
+    }
+    return supply.Rescale(decimals)
+}
+
+// Raw form of function which does no permissions checking.  Returns the new total supply.
+func add_to_total_supply_ (stub shim.ChaincodeStubInterface, amount decimal.Amount) (decimal.Amount, error) {
+    supply,err := get_total_supply_(stub)
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+    supply,err = supply.Add(amount)
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+    if supply.Sign() < 0 {
+        return decimal.Amount{}, fmt.Errorf("The total supply can't become negative (%v)", supply)
+    }
+    _,err = total_supply_table.Upsert(stub, &TotalSupply{Supply:supply}, nil)
+    if err != nil {
+        return decimal.Amount{}, wrap_error(err, "Error setting %s TotalSupply value to %v; error was %v", CONFIG_TABLE, supply, err.Error())
+    }
+    return supply, nil
+}
+
+// Moves every balance of the account (whose asset balances are given) into the account named to_account_name,
+// zeroing the balances in memory, so that the account can then be deleted.  The transactor must be authorized
+// to transfer from the account.
+func sweep_account_ (stub shim.ChaincodeStubInterface, account *Account, asset_balances []AssetBalance, to_account_name string) error {
+    if to_account_name == account.Name {
+        return fmt.Errorf("Can't sweep account \"%s\" into itself", account.Name)
+    }
+
+    // The account holder is allowed to transfer, as is anyone with permission to transfer (e.g. Admin).
+    is_holder := false
+    if account.Owner != nil {
+        var err error
+        is_holder,err = transactor_is(stub, account.Owner)
+        if err != nil {
+            return err
+        }
+    }
+    if !is_holder {
+        err := check_permission(stub, "transfer", fmt.Sprintf("transfer from account \"%s\"", account.Name))
+        if err != nil {
+            return err
+        }
+    }
+
+    to_account,err := get_account_(stub, to_account_name)
+    if err != nil {
+        return wrap_error(err, "Error in retrieving \"to\" account \"%s\"; %v", to_account_name, err.Error())
+    }
+    err = move_balance_(account, to_account, account.Balance)
+    if err != nil {
+        return err
+    }
+    err = overwrite_account_(stub, to_account)
+    if err != nil {
+        return err
+    }
+
+    for i := range asset_balances {
+        asset_balance := &asset_balances[i]
+        asset,err := get_asset_(stub, asset_balance.Asset)
+        if err != nil {
+            return err
+        }
+        to_balance,err := get_asset_balance_(stub, to_account_name, asset)
+        if err != nil {
+            return err
+        }
+        err = move_amount_(&asset_balance.Balance, &to_balance.Balance, false, asset_balance.Balance)
+        if err != nil {
+            return err
+        }
+        err = put_asset_balance_(stub, to_balance)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Returns nil if the transactor may call function ("mint" or "burn") for the asset, i.e. if they are the
+// asset's issuer or have permission to call function.
+func check_supply_permission (stub shim.ChaincodeStubInterface, function string, asset *Asset) error {
+    if asset != nil && asset.Issuer != nil {
+        is_issuer,err := transactor_is(stub, asset.Issuer)
+        if err != nil || is_issuer {
+            return err
+        }
+    }
+    symbol := DEFAULT_ASSET
+    if asset != nil {
+        symbol = asset.Symbol
+    }
+    return check_permission(stub, function, fmt.Sprintf("%s %s", function, asset_display_name(symbol)))
+}
+
+func asset_display_name (symbol string) string {
+    if symbol == DEFAULT_ASSET {
+        return "the default asset"
+    }
+    return fmt.Sprintf("asset \"%s\"", symbol)
+}
+
+// Raw form of function which does no permissions checking.  Adds amount (which may be negative, for burning)
+// to the balance of the account and to the supply of the asset (nil for the default asset).  Returns the new
+// supply.
+func change_supply_ (stub shim.ChaincodeStubInterface, account_name string, asset *Asset, amount decimal.Amount) (decimal.Amount, error) {
+    account,err := get_account_(stub, account_name)
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+    err = check_account_is_active(account)
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+
+    if asset == nil {
+        balance,err := account.Balance.Add(amount)
+        if err != nil {
+            return decimal.Amount{}, err
+        }
+        if balance.Cmp(account.Held) < 0 {
+            available,err := account.available()
+            if err != nil {
+                return decimal.Amount{}, err
+            }
+            return decimal.Amount{}, fmt.Errorf("Can't burn %v; the available balance of account \"%s\" is only %v", amount.Abs(), account_name, available)
+        }
+        account.Balance = balance
+        err = overwrite_account_(stub, account)
+        if err != nil {
+            return decimal.Amount{}, err
+        }
+        return add_to_total_supply_(stub, amount)
+    }
+
+    asset_balance,err := get_asset_balance_(stub, account_name, asset)
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+    balance,err := asset_balance.Balance.Add(amount)
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+    if balance.Sign() < 0 {
+        return decimal.Amount{}, fmt.Errorf("Can't burn %v %s; the balance of account \"%s\" is only %v", amount.Abs(), asset.Symbol, account_name, asset_balance.Balance)
+    }
+    if amount.Sign() >= 0 {
+        err = issue_(asset, amount)
+    } else {
+        err = retire_(asset, amount.Abs())
+    }
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+    asset_balance.Balance = balance
+    err = put_asset_balance_(stub, asset_balance)
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+    err = overwrite_asset_(stub, asset)
+    if err != nil {
+        return decimal.Amount{}, err
+    }
+    return asset.Supply, nil
+}
+
+//
+// supply related chaincode API functions
+//
+
+// Implements mint and burn, whose args are account_name, amount (positive), and optionally asset.
+func (t *SimpleChaincode) change_supply (stub shim.ChaincodeStubInterface, function string, args []string) pb.Response {
+    if len(args) != 2 && len(args) != 3 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 2 or 3; account_name, amount, and optionally asset")
+    }
+
+    account_name := args[0]
+    asset_symbol := DEFAULT_ASSET
+    var asset *Asset
+    if len(args) == 3 && args[2] != DEFAULT_ASSET {
+        asset_symbol = args[2]
+        var err error
+        asset,err = get_asset_(stub, asset_symbol)
+        if err != nil {
+            return error_response(err)
+        }
+    }
+
+    err := check_supply_permission(stub, function, asset)
+    if err != nil {
+        return error_response(err)
+    }
+
+    amount,err := parse_asset_amount(stub, asset_symbol, args[1])
+    if err != nil {
+        return coded_error_response(INVALID_PARAMS, "Invalid amount \"%s\"; %v", args[1], err.Error())
+    }
+    if amount.Sign() <= 0 {
+        return coded_error_response(INVALID_PARAMS, "Invalid amount %v; expecting positive amount", amount)
+    }
+
+    event_type := events.MINTED
+    delta := amount
+    if function == "burn" {
+        event_type = events.BURNED
+        delta = amount.Neg()
+    }
+    supply,err := change_supply_(stub, account_name, asset, delta)
+    if err != nil {
+        return error_response(wrap_error(err, "Could not %s into account \"%s\"; %v", function, account_name, err.Error()))
+    }
+
+    err = emit_event(stub, event_type, &events.SupplyChanged{Account:account_name, Asset:asset_symbol, Amount:amount.String(), Supply:supply.String()})
+    if err != nil {
+        return error_response(err)
+    }
+
+    return shim.Success(nil)
+}
+
+// Query the total supply of the default asset, or of the asset given as the optional arg.
+func (t *SimpleChaincode) query_total_supply (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) > 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 0 or 1 arguments, got %v", args)
+    }
+
+    err := check_permission(stub, "query_total_supply", "query_total_supply")
+    if err != nil {
+        return error_response(err)
+    }
+
+    result := struct {
+        Asset   string          `json:"Asset"`
+        Supply  decimal.Amount  `json:"Supply"`
+    }{}
+    if len(args) == 1 && args[0] != DEFAULT_ASSET {
+        asset,err := get_asset_(stub, args[0])
+        if err != nil {
+            return error_response(err)
+        }
+        result.Asset = asset.Symbol
+        result.Supply = asset.Supply
+    } else {
+        result.Supply,err = get_total_supply_(stub)
+        if err != nil {
+            return error_response(wrap_error(err, "Could not query_total_supply due to error %v", err.Error()))
+        }
+    }
+
+    bytes,err := json.Marshal(&result)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing total supply failed in query_total_supply because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/example_cc/decimal"
+    "github.com/example_cc/util"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+    "regexp"
+    "time"
+)
+
+//
+// transfer records
+//
+// Every transfer made by the transfer function is recorded as a TransferRecord row keyed by its transaction ID,
+// along with the optional memo and client reference ID given by the sender.  A client reference ID can be used
+// only once per sender (a ClientReference row records its use), so that a client retrying a transfer whose
+// outcome it didn't learn can't make the transfer twice.
+//
+
+const TRANSFER_RECORD_TABLE = "TransferRecordTable"
+const CLIENT_REFERENCE_TABLE = "ClientReferenceTable"
+
+const MAX_MEMO_LENGTH = 256
+
+var client_ref_id_regexp = regexp.MustCompile("^[A-Za-z0-9._:-]{1,64}$")
+
+func ValidateClientRefID (client_ref_id string) error {
+    if !client_ref_id_regexp.MatchString(client_ref_id) {
+        return coded_error(INVALID_PARAMS, "Invalid client reference ID \"%s\"; must be 1 to 64 letters, digits, '.', '_', ':' and '-'", client_ref_id)
+    }
+    return nil
+}
+
+type TransferRecord struct {
+    TxID        string          `json:"TxID"`
+    Timestamp   time.Time       `json:"Timestamp"`
+    // The transactor who made the transfer.
+    Sender      Identity        `json:"Sender"`
+    FromAccount string          `json:"FromAccount"`
+    ToAccount   string          `json:"ToAccount"`
+    Asset       string          `json:"Asset,omitempty"`
+    Amount      decimal.Amount  `json:"Amount"`
+    Memo        string          `json:"Memo,omitempty"`
+    ClientRefID string          `json:"ClientRefID,omitempty"`
+}
+
+func row_keys_of_TransferRecord (record *TransferRecord) []string {
+    return []string{record.TxID}
+}
+
+var transfer_record_table = util.NewTable(TRANSFER_RECORD_TABLE, TransferRecord{}, func (row interface{}) []string { return row_keys_of_TransferRecord(row.(*TransferRecord)) }, nil)
+
+// The use of a client reference ID by a sender.
+type ClientReference struct {
+    Sender      Identity        `json:"Sender"`
+    ClientRefID string          `json:"ClientRefID"`
+    // The transaction which used the client reference ID.
+    TxID        string          `json:"TxID"`
+}
+
+func row_keys_of_ClientReference (reference *ClientReference) []string {
+    return []string{reference.Sender.MspID, reference.Sender.Subject, reference.Sender.Issuer, reference.ClientRefID}
+}
+
+var client_reference_table = util.NewTable(CLIENT_REFERENCE_TABLE, ClientReference{}, func (row interface{}) []string { return row_keys_of_ClientReference(row.(*ClientReference)) }, nil)
+
+// Raw form of function which does no permissions checking.  Fails if the record's sender has already used its
+// client reference ID.
+func create_transfer_record_ (stub shim.ChaincodeStubInterface, record *TransferRecord) error {
+    if record.ClientRefID != "" {
+        reference := ClientReference{Sender:record.Sender, ClientRefID:record.ClientRefID, TxID:record.TxID}
+        err := client_reference_table.Insert(stub, &reference)
+        if util.IsAlreadyExists(err) {
+            var old_reference ClientReference
+            err = client_reference_table.Get(stub, row_keys_of_ClientReference(&reference), &old_reference)
+            if err != nil {
+                return err
+            }
+            return coded_error(DUPLICATE_CLIENT_REF_ID, "Client reference ID \"%s\" was already used by %v in transaction \"%s\", so this transfer was not made again", record.ClientRefID, &record.Sender, old_reference.TxID)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    err := transfer_record_table.Insert(stub, record)
+    if err != nil {
+        return wrap_error(err, "Could not record transfer \"%s\"; error was %v", record.TxID, err.Error())
+    }
+    return nil
+}
+
+// Raw form of function which does no permissions checking
+func get_transfer_record_ (stub shim.ChaincodeStubInterface, tx_id string) (*TransferRecord, error) {
+    var record TransferRecord
+    err := transfer_record_table.Get(stub, []string{tx_id}, &record)
+    if util.IsNotFound(err) {
+        return nil, coded_error(NOT_FOUND, "There is no transfer record \"%s\"", tx_id)
+    }
+    if err != nil {
+        return nil, wrap_error(err, "Could not retrieve transfer record \"%s\"; error was %v", tx_id, err.Error())
+    }
+    return &record, nil
+}
+
+//
+// transfer record related chaincode API functions
+//
+
+// Query the record of the transfer made by the transaction whose ID is the single arg.  The sender and the
+// holders of both accounts are allowed to query it, as is anyone with permission to query_balance.
+func (t *SimpleChaincode) query_transfer_record (stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return coded_error_response(INVALID_PARAMS, "Incorrect number of arguments. Expecting 1; tx_id")
+    }
+
+    // A lookup error is reported only after the permission check, so that it doesn't reveal which records exist.
+    record,lookup_err := get_transfer_record_(stub, args[0])
+
+    is_party := false
+    var err error
+    if lookup_err == nil {
+        is_party,err = transactor_is(stub, &record.Sender)
+        if err != nil {
+            return error_response(err)
+        }
+        for _,account_name := range []string{record.FromAccount, record.ToAccount} {
+            if is_party {
+                break
+            }
+            is_party,err = transactor_is_account_owner(stub, account_name)
+            if err != nil {
+                return error_response(err)
+            }
+        }
+    }
+    if !is_party {
+        err = check_permission(stub, "query_balance", fmt.Sprintf("query transfer record \"%s\"", args[0]))
+        if err != nil {
+            return error_response(err)
+        }
+    }
+    if lookup_err != nil {
+        return error_response(lookup_err)
+    }
+
+    bytes,err := json.Marshal(record)
+    if err != nil {
+        return shim.Error(fmt.Sprintf("Serializing transfer record failed in query_transfer_record because json.Marshal failed with error %v", err))
+    }
+    return shim.Success(bytes)
+}
@@ -0,0 +1,346 @@
+package util
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    "unicode/utf8"
+)
+
+//
+// secondary indexes
+//
+// A secondary index of a table lets its rows be found by keys other than the leading row keys, e.g. accounts by
+// their status.  For each row, the index has an entry whose composite key consists of the row's index keys
+// followed by its row keys, stored under the object type "<table_name>~<index_name>".  InsertTableRow,
+// DeleteTableRow and the Table methods maintain the entries of every index defined on a table.
+//
+// Since stub.GetState doesn't see the writes of the current transaction, a row written more than once in a
+// transaction can leave a stale entry behind, i.e. one whose index keys are no longer the row's.
+// GetTableRowsByIndex skips stale entries, and CheckIndex finds (and optionally repairs) them.
+//
+
+// Returns the index keys of a row, given its JSON, or nil if the row is to be left out of the index.
+type IndexKeysFunc func (row_json_bytes []byte) ([]string, error)
+
+type Index struct {
+    TableName   string
+    Name        string
+    index_keys  IndexKeysFunc
+}
+
+// The indexes of each table, by table name.
+var table_indexes = make(map[string][]*Index)
+
+// Defines a secondary index on a table; this should be done during package initialization, so that every
+// change to the table maintains the index.  Panics if the table already has an index with the
+// same name.
+func DefineIndex (table_name string, index_name string, index_keys IndexKeysFunc) *Index {
+    for _,index := range table_indexes[table_name] {
+        if index.Name == index_name {
+            panic(fmt.Sprintf("DefineIndex: table %s already has an index named %s", table_name, index_name))
+        }
+    }
+    index := &Index{TableName:table_name, Name:index_name, index_keys:index_keys}
+    table_indexes[table_name] = append(table_indexes[table_name], index)
+    return index
+}
+
+func (index *Index) object_type () string {
+    return index.TableName + "~" + index.Name
+}
+
+// Returns the ledger state key of the entry for the row with the given row keys and JSON, or "" if the row is
+// left out of the index.
+func (index *Index) entry_key (
+    stub            shim.ChaincodeStubInterface,
+    row_keys        []string,
+    row_json_bytes  []byte,
+) (string, error) {
+    index_keys, err := index.index_keys(row_json_bytes)
+    if err != nil {
+        return "", fmt.Errorf("Could not determine the %s keys of %s row %v; error was %v", index.Name, index.TableName, row_keys, err)
+    }
+    if index_keys == nil {
+        return "", nil
+    }
+    entry_key, err := stub.CreateCompositeKey(index.object_type(), append(append([]string{}, index_keys...), row_keys...))
+    if err != nil {
+        return "", fmt.Errorf("Could not create the %s entry key of %s row %v; stub.CreateCompositeKey failed with error %v", index.Name, index.TableName, row_keys, err)
+    }
+    return entry_key, nil
+}
+
+// The value of an index entry is the JSON of the row keys, so that an entry can be resolved to its row without
+// splitting its key.
+func put_index_entry (stub shim.ChaincodeStubInterface, entry_key string, row_keys []string) error {
+    bytes, err := json.Marshal(row_keys)
+    if err != nil {
+        return fmt.Errorf("Could not put index entry; json.Marshal failed with error %v", err)
+    }
+    err = stub.PutState(entry_key, bytes)
+    if err != nil {
+        return fmt.Errorf("Could not put index entry; stub.PutState(\"%v\") failed with error %v", entry_key, err)
+    }
+    return nil
+}
+
+// Updates the entries of every index of a table for a change of one row.  old_row_json_bytes is nil if the row
+// didn't exist, and new_row_json_bytes is nil if it is being deleted.
+func update_index_entries (
+    stub                shim.ChaincodeStubInterface,
+    table_name          string,
+    row_keys            []string,
+    old_row_json_bytes  []byte,
+    new_row_json_bytes  []byte,
+) error {
+    for _,index := range table_indexes[table_name] {
+        old_entry_key := ""
+        if old_row_json_bytes != nil {
+            var err error
+            old_entry_key, err = index.entry_key(stub, row_keys, old_row_json_bytes)
+            if err != nil {
+                return err
+            }
+        }
+        new_entry_key := ""
+        if new_row_json_bytes != nil {
+            var err error
+            new_entry_key, err = index.entry_key(stub, row_keys, new_row_json_bytes)
+            if err != nil {
+                return err
+            }
+        }
+        if old_entry_key != "" && old_entry_key != new_entry_key {
+            err := stub.DelState(old_entry_key)
+            if err != nil {
+                return fmt.Errorf("Could not delete index entry; stub.DelState(\"%v\") failed with error %v", old_entry_key, err)
+            }
+        }
+        // The new entry is put even if it is unchanged, so that rewriting a row restores a missing entry.
+        if new_entry_key != "" {
+            err := put_index_entry(stub, new_entry_key, row_keys)
+            if err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// Returns the JSON of the rows whose leading index keys are index_keys, in index key order.  Stale entries
+// (see above) are skipped.
+func GetTableRowsByIndex (
+    stub            shim.ChaincodeStubInterface,
+    index           *Index,
+    index_keys      []string,
+) ([][]byte, error) {
+    state_query_iterator, err := stub.GetStateByPartialCompositeKey(index.object_type(), index_keys)
+    if err != nil {
+        return nil, fmt.Errorf("GetTableRowsByIndex failed because stub.GetStateByPartialCompositeKey failed with error %v", err)
+    }
+    defer state_query_iterator.Close()
+
+    rows := [][]byte{}
+    for state_query_iterator.HasNext() {
+        query_result_kv, err := state_query_iterator.Next()
+        if err != nil {
+            return nil, fmt.Errorf("GetTableRowsByIndex failed because iteration failed with error %v", err)
+        }
+        row_keys, row_json_bytes, is_stale, err := resolve_index_entry(stub, index, query_result_kv.Key, query_result_kv.Value)
+        if err != nil {
+            return nil, fmt.Errorf("GetTableRowsByIndex failed; %v", err)
+        }
+        if is_stale {
+            fmt.Printf("GetTableRowsByIndex: skipping stale %s entry for %s row %v\n", index.Name, index.TableName, row_keys)
+            continue
+        }
+        rows = append(rows, row_json_bytes)
+    }
+    return rows, nil
+}
+
+// Like GetTableRowsByIndex, but returns up to page_size rows as GetTableRowsPage does.  index_keys must be all of
+// the index keys, so that the entries are in row key order.  If start_row_keys is not empty, then the page
+// starts at the entry of the row having those row keys (or the next one after it).  If there are further
+// entries, then the row keys of the first of them are returned as next_row_keys; otherwise next_row_keys is
+// nil.  Stale entries are skipped, and don't count towards page_size.
+func GetTableRowsByIndexPage (
+    stub            shim.ChaincodeStubInterface,
+    index           *Index,
+    index_keys      []string,
+    start_row_keys  []string,
+    page_size       int,
+) (rows [][]byte, next_row_keys []string, err error) {
+    if page_size < 1 {
+        return nil, nil, fmt.Errorf("GetTableRowsByIndexPage failed because page_size %d is not positive", page_size)
+    }
+    start_key, err := stub.CreateCompositeKey(index.object_type(), index_keys)
+    if err != nil {
+        return nil, nil, fmt.Errorf("GetTableRowsByIndexPage failed because stub.CreateCompositeKey failed with error %v", err)
+    }
+    end_key := start_key + string(utf8.MaxRune)
+    if len(start_row_keys) > 0 {
+        bookmark_key, err := stub.CreateCompositeKey(index.object_type(), append(append([]string{}, index_keys...), start_row_keys...))
+        if err != nil {
+            return nil, nil, fmt.Errorf("GetTableRowsByIndexPage failed because stub.CreateCompositeKey failed with error %v", err)
+        }
+        if bookmark_key > start_key {
+            start_key = bookmark_key
+        }
+    }
+
+    state_query_iterator, err := stub.GetStateByRange(start_key, end_key)
+    if err != nil {
+        return nil, nil, fmt.Errorf("GetTableRowsByIndexPage failed because stub.GetStateByRange failed with error %v", err)
+    }
+    defer state_query_iterator.Close()
+
+    rows = [][]byte{}
+    for state_query_iterator.HasNext() {
+        query_result_kv, err := state_query_iterator.Next()
+        if err != nil {
+            return nil, nil, fmt.Errorf("GetTableRowsByIndexPage failed because iteration failed with error %v", err)
+        }
+        if len(rows) == page_size {
+            err = json.Unmarshal(query_result_kv.Value, &next_row_keys)
+            if err != nil {
+                return nil, nil, fmt.Errorf("GetTableRowsByIndexPage failed because json.Unmarshal of %s entry \"%s\" failed with error %v", index.Name, string(query_result_kv.Value), err)
+            }
+            break
+        }
+        row_keys, row_json_bytes, is_stale, err := resolve_index_entry(stub, index, query_result_kv.Key, query_result_kv.Value)
+        if err != nil {
+            return nil, nil, fmt.Errorf("GetTableRowsByIndexPage failed; %v", err)
+        }
+        if is_stale {
+            fmt.Printf("GetTableRowsByIndexPage: skipping stale %s entry for %s row %v\n", index.Name, index.TableName, row_keys)
+            continue
+        }
+        rows = append(rows, row_json_bytes)
+    }
+    return rows, next_row_keys, nil
+}
+
+// Returns the row keys and JSON of the row that an index entry refers to, and whether the entry is stale,
+// i.e. the row is missing or its entry key is different.
+func resolve_index_entry (
+    stub                shim.ChaincodeStubInterface,
+    index               *Index,
+    entry_key           string,
+    entry_value         []byte,
+) (row_keys []string, row_json_bytes []byte, is_stale bool, err error) {
+    err = json.Unmarshal(entry_value, &row_keys)
+    if err != nil {
+        return nil, nil, false, fmt.Errorf("json.Unmarshal of %s entry \"%s\" failed with error %v", index.Name, string(entry_value), err)
+    }
+    row_key, err := stub.CreateCompositeKey(index.TableName, row_keys)
+    if err != nil {
+        return nil, nil, false, fmt.Errorf("stub.CreateCompositeKey failed with error %v", err)
+    }
+    row_json_bytes, err = stub.GetState(row_key)
+    if err != nil {
+        return nil, nil, false, fmt.Errorf("stub.GetState(\"%v\") failed with error %v", row_key, err)
+    }
+    if row_json_bytes == nil {
+        return row_keys, nil, true, nil
+    }
+    expected_entry_key, err := index.entry_key(stub, row_keys, row_json_bytes)
+    if err != nil {
+        return nil, nil, false, err
+    }
+    return row_keys, row_json_bytes, expected_entry_key != entry_key, nil
+}
+
+type IndexCheckReport struct {
+    Table           string      `json:"Table"`
+    Index           string      `json:"Index"`
+    RowsChecked     int         `json:"RowsChecked"`
+    // The row keys of the rows whose entries were missing.
+    MissingEntries  [][]string  `json:"MissingEntries"`
+    // The row keys that stale entries referred to.
+    StaleEntries    [][]string  `json:"StaleEntries"`
+    // Whether the missing entries were put and the stale ones deleted.
+    Repaired        bool        `json:"Repaired"`
+}
+
+// Checks that an index has exactly one entry for each of the table's rows (other than those left out of it),
+// and if repair is true, rebuilds the index by putting the missing entries and deleting the stale ones.  This
+// reads the whole table and index in one transaction.
+func CheckIndex (
+    stub            shim.ChaincodeStubInterface,
+    index           *Index,
+    repair          bool,
+) (*IndexCheckReport, error) {
+    report := &IndexCheckReport{Table:index.TableName, Index:index.Name, MissingEntries:[][]string{}, StaleEntries:[][]string{}, Repaired:repair}
+
+    entry_iterator, err := stub.GetStateByPartialCompositeKey(index.object_type(), []string{})
+    if err != nil {
+        return nil, fmt.Errorf("CheckIndex failed because stub.GetStateByPartialCompositeKey failed with error %v", err)
+    }
+    defer entry_iterator.Close()
+    valid_entry_keys := make(map[string]bool)
+    for entry_iterator.HasNext() {
+        query_result_kv, err := entry_iterator.Next()
+        if err != nil {
+            return nil, fmt.Errorf("CheckIndex failed because iteration failed with error %v", err)
+        }
+        row_keys, _, is_stale, err := resolve_index_entry(stub, index, query_result_kv.Key, query_result_kv.Value)
+        if err != nil {
+            return nil, fmt.Errorf("CheckIndex failed; %v", err)
+        }
+        if !is_stale {
+            valid_entry_keys[query_result_kv.Key] = true
+            continue
+        }
+        report.StaleEntries = append(report.StaleEntries, row_keys)
+        if repair {
+            err = stub.DelState(query_result_kv.Key)
+            if err != nil {
+                return nil, fmt.Errorf("CheckIndex failed because stub.DelState(\"%v\") failed with error %v", query_result_kv.Key, err)
+            }
+        }
+    }
+
+    row_iterator, err := stub.GetStateByPartialCompositeKey(index.TableName, []string{})
+    if err != nil {
+        return nil, fmt.Errorf("CheckIndex failed because stub.GetStateByPartialCompositeKey failed with error %v", err)
+    }
+    defer row_iterator.Close()
+    for row_iterator.HasNext() {
+        query_result_kv, err := row_iterator.Next()
+        if err != nil {
+            return nil, fmt.Errorf("CheckIndex failed because iteration failed with error %v", err)
+        }
+        _, row_keys, err := stub.SplitCompositeKey(query_result_kv.Key)
+        if err != nil {
+            return nil, fmt.Errorf("CheckIndex failed because stub.SplitCompositeKey failed with error %v", err)
+        }
+        report.RowsChecked++
+        entry_key, err := index.entry_key(stub, row_keys, query_result_kv.Value)
+        if err != nil {
+            return nil, fmt.Errorf("CheckIndex failed; %v", err)
+        }
+        if entry_key == "" || valid_entry_keys[entry_key] {
+            continue
+        }
+        report.MissingEntries = append(report.MissingEntries, row_keys)
+        if repair {
+            err = put_index_entry(stub, entry_key, row_keys)
+            if err != nil {
+                return nil, fmt.Errorf("CheckIndex failed; %v", err)
+            }
+        }
+    }
+    return report, nil
+}
+
+// Returns the index of the table with the given name, or nil if there is none.
+func GetIndex (table_name string, index_name string) *Index {
+    for _,index := range table_indexes[table_name] {
+        if index.Name == index_name {
+            return index
+        }
+    }
+    return nil
+}
@@ -0,0 +1,405 @@
+package util
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    "reflect"
+)
+
+//
+// typed tables
+//
+// A Table ties a table name to the type of its rows, the function giving the row keys of a row, and the codec
+// its rows are stored with, so that callers deal in rows rather than in keys and bytes.  It is a layer over the
+// same composite keys as InsertTableRow and friends, and maintains the table's secondary indexes (see
+// DefineIndex) in the same way; the two can be used on the same table.  Several Tables may share a table name
+// as long as their row keys don't collide, e.g. the different kinds of rows of a configuration table.
+//
+// Row arguments are pointers to the row type, e.g. *Account for a Table created with Account{}; passing any
+// other type is an error.
+//
+
+// The errors a Table reports for a row which is missing or already present, wrapped in a *RowError.  Use
+// IsNotFound and IsAlreadyExists to check for them.
+var ErrNotFound = errors.New("row does not exist")
+var ErrAlreadyExists = errors.New("row existed already")
+
+type RowError struct {
+    Table   string
+    // The Table method which failed, e.g. "Get".
+    Op      string
+    RowKeys []string
+    // ErrNotFound or ErrAlreadyExists.
+    Err     error
+}
+
+func (e *RowError) Error() string {
+    if e.Err == ErrNotFound {
+        return fmt.Sprintf("%s.%s failed because row with keys %v does not exist", e.Table, e.Op, e.RowKeys)
+    }
+    return fmt.Sprintf("%s.%s failed because the %v; row keys were %v", e.Table, e.Op, e.Err, e.RowKeys)
+}
+
+// Returns whether err is, or is a *RowError for, ErrNotFound.
+func IsNotFound (err error) bool {
+    if row_error, ok := err.(*RowError); ok {
+        err = row_error.Err
+    }
+    return err == ErrNotFound
+}
+
+// Returns whether err is, or is a *RowError for, ErrAlreadyExists.
+func IsAlreadyExists (err error) bool {
+    if row_error, ok := err.(*RowError); ok {
+        err = row_error.Err
+    }
+    return err == ErrAlreadyExists
+}
+
+// Encodes rows for storage in the ledger state.  Secondary index key functions are given rows in this encoding.
+type RowCodec interface {
+    Marshal (row interface{}) ([]byte, error)
+    Unmarshal (bytes []byte, row interface{}) error
+}
+
+type json_codec struct{}
+
+func (json_codec) Marshal (row interface{}) ([]byte, error) {
+    return json.Marshal(row)
+}
+
+func (json_codec) Unmarshal (bytes []byte, row interface{}) error {
+    return json.Unmarshal(bytes, row)
+}
+
+// The codec of InsertTableRow and friends.
+var JSONCodec RowCodec = json_codec{}
+
+// Returns the row keys of a row, which is a pointer to the table's row type.
+type RowKeysFunc func (row interface{}) []string
+
+// Returns a RowKeysFunc giving the same row keys for every row, for a table of a single row, e.g. a setting
+// in a configuration table.
+func FixedRowKeys (row_keys ...string) RowKeysFunc {
+    return func (row interface{}) []string {
+        return row_keys
+    }
+}
+
+type Table struct {
+    Name        string
+    row_type    reflect.Type
+    row_keys    RowKeysFunc
+    codec       RowCodec
+}
+
+// Creates a Table whose rows are of the type of row_prototype (a zero value of it, or a pointer to one), e.g.
+// Account{}.  If codec is nil, then rows are stored as JSON.
+func NewTable (name string, row_prototype interface{}, row_keys RowKeysFunc, codec RowCodec) *Table {
+    row_type := reflect.TypeOf(row_prototype)
+    if row_type.Kind() == reflect.Ptr {
+        row_type = row_type.Elem()
+    }
+    if codec == nil {
+        codec = JSONCodec
+    }
+    return &Table{Name:name, row_type:row_type, row_keys:row_keys, codec:codec}
+}
+
+func (table *Table) check_row_type (op string, row interface{}) error {
+    if reflect.TypeOf(row) != reflect.PtrTo(table.row_type) {
+        return fmt.Errorf("%s.%s failed because the row is a %T rather than a *%v", table.Name, op, row, table.row_type)
+    }
+    return nil
+}
+
+// Returns the row keys of row.
+func (table *Table) RowKeys (row interface{}) []string {
+    return table.row_keys(row)
+}
+
+// Returns the ledger state key and stored bytes of the row with the given row keys; the bytes are nil if the
+// row doesn't exist.
+func (table *Table) get_row_bytes (stub shim.ChaincodeStubInterface, op string, row_keys []string) (string, []byte, error) {
+    composite_key, err := stub.CreateCompositeKey(table.Name, row_keys)
+    if err != nil {
+        return "", nil, fmt.Errorf("%s.%s failed because stub.CreateCompositeKey failed with error %v", table.Name, op, err)
+    }
+    bytes, err := stub.GetState(composite_key)
+    if err != nil {
+        return "", nil, fmt.Errorf("%s.%s failed because stub.GetState(\"%v\") failed with error %v", table.Name, op, composite_key, err)
+    }
+    return composite_key, bytes, nil
+}
+
+// Stores row, whose old stored bytes are old_bytes (nil if it didn't exist), and updates the table's indexes.
+func (table *Table) put_row (
+    stub            shim.ChaincodeStubInterface,
+    op              string,
+    composite_key   string,
+    row_keys        []string,
+    old_bytes       []byte,
+    row             interface{},
+) error {
+    bytes, err := table.codec.Marshal(row)
+    if err != nil {
+        return fmt.Errorf("%s.%s failed because encoding the row failed with error %v", table.Name, op, err)
+    }
+    err = stub.PutState(composite_key, bytes)
+    if err != nil {
+        return fmt.Errorf("%s.%s failed because stub.PutState(\"%v\") failed with error %v", table.Name, op, composite_key, err)
+    }
+    err = update_index_entries(stub, table.Name, row_keys, old_bytes, bytes)
+    if err != nil {
+        return fmt.Errorf("%s.%s failed because updating indexes failed with error %v", table.Name, op, err)
+    }
+    return nil
+}
+
+// Decodes stored bytes into row, which has been checked to be a pointer to the row type.
+func (table *Table) decode_row (op string, bytes []byte, row interface{}) error {
+    err := table.codec.Unmarshal(bytes, row)
+    if err != nil {
+        return fmt.Errorf("%s.%s failed because decoding row \"%s\" failed with error %v", table.Name, op, string(bytes), err)
+    }
+    return nil
+}
+
+// Reads the row with the given row keys into row.  Returns a *RowError for ErrNotFound if there is no such row,
+// in which case row is unchanged; so a row holding default values can be read with
+//
+//     if err := table.Get(stub, row_keys, &row); err != nil && !util.IsNotFound(err) { ... }
+func (table *Table) Get (stub shim.ChaincodeStubInterface, row_keys []string, row interface{}) error {
+    err := table.check_row_type("Get", row)
+    if err != nil {
+        return err
+    }
+    _, bytes, err := table.get_row_bytes(stub, "Get", row_keys)
+    if err != nil {
+        return err
+    }
+    if bytes == nil {
+        return &RowError{Table:table.Name, Op:"Get", RowKeys:row_keys, Err:ErrNotFound}
+    }
+    return table.decode_row("Get", bytes, row)
+}
+
+// Returns whether the row with the given row keys exists.
+func (table *Table) Exists (stub shim.ChaincodeStubInterface, row_keys []string) (bool, error) {
+    _, bytes, err := table.get_row_bytes(stub, "Exists", row_keys)
+    if err != nil {
+        return false, err
+    }
+    return bytes != nil, nil
+}
+
+// Stores a new row.  Returns a *RowError for ErrAlreadyExists if a row with the same row keys exists.
+func (table *Table) Insert (stub shim.ChaincodeStubInterface, row interface{}) error {
+    err := table.check_row_type("Insert", row)
+    if err != nil {
+        return err
+    }
+    row_keys := table.row_keys(row)
+    composite_key, old_bytes, err := table.get_row_bytes(stub, "Insert", row_keys)
+    if err != nil {
+        return err
+    }
+    if old_bytes != nil {
+        return &RowError{Table:table.Name, Op:"Insert", RowKeys:row_keys, Err:ErrAlreadyExists}
+    }
+    return table.put_row(stub, "Insert", composite_key, row_keys, nil, row)
+}
+
+// Overwrites an existing row.  Returns a *RowError for ErrNotFound if there is no row with the same row keys.
+func (table *Table) Update (stub shim.ChaincodeStubInterface, row interface{}) error {
+    err := table.check_row_type("Update", row)
+    if err != nil {
+        return err
+    }
+    row_keys := table.row_keys(row)
+    composite_key, old_bytes, err := table.get_row_bytes(stub, "Update", row_keys)
+    if err != nil {
+        return err
+    }
+    if old_bytes == nil {
+        return &RowError{Table:table.Name, Op:"Update", RowKeys:row_keys, Err:ErrNotFound}
+    }
+    return table.put_row(stub, "Update", composite_key, row_keys, old_bytes, row)
+}
+
+// Stores a row, whether or not it exists.  If old_row is not nil and the row existed, then the old row is read
+// into old_row; the returned bool is whether the row existed.
+func (table *Table) Upsert (stub shim.ChaincodeStubInterface, row interface{}, old_row interface{}) (bool, error) {
+    err := table.check_row_type("Upsert", row)
+    if err != nil {
+        return false, err
+    }
+    row_keys := table.row_keys(row)
+    composite_key, old_bytes, err := table.get_row_bytes(stub, "Upsert", row_keys)
+    if err != nil {
+        return false, err
+    }
+    if old_bytes != nil && old_row != nil {
+        err = table.check_row_type("Upsert", old_row)
+        if err != nil {
+            return false, err
+        }
+        err = table.decode_row("Upsert", old_bytes, old_row)
+        if err != nil {
+            return false, err
+        }
+    }
+    return old_bytes != nil, table.put_row(stub, "Upsert", composite_key, row_keys, old_bytes, row)
+}
+
+// Deletes the row with the given row keys.  If old_row is not nil, then the deleted row is read into it.
+// Returns a *RowError for ErrNotFound if there is no such row.
+func (table *Table) Delete (stub shim.ChaincodeStubInterface, row_keys []string, old_row interface{}) error {
+    composite_key, old_bytes, err := table.get_row_bytes(stub, "Delete", row_keys)
+    if err != nil {
+        return err
+    }
+    if old_bytes == nil {
+        return &RowError{Table:table.Name, Op:"Delete", RowKeys:row_keys, Err:ErrNotFound}
+    }
+    if old_row != nil {
+        err = table.check_row_type("Delete", old_row)
+        if err != nil {
+            return err
+        }
+        err = table.decode_row("Delete", old_bytes, old_row)
+        if err != nil {
+            return err
+        }
+    }
+    err = stub.DelState(composite_key)
+    if err != nil {
+        return fmt.Errorf("%s.Delete failed because stub.DelState(\"%v\") failed with error %v", table.Name, composite_key, err)
+    }
+    err = update_index_entries(stub, table.Name, row_keys, old_bytes, nil)
+    if err != nil {
+        return fmt.Errorf("%s.Delete failed because updating indexes failed with error %v", table.Name, err)
+    }
+    return nil
+}
+
+// Returns the reflect.Value of the slice that rows points to, checking that it is a *[]<row type>.
+func (table *Table) rows_slice (op string, rows interface{}) (reflect.Value, error) {
+    if reflect.TypeOf(rows) != reflect.PtrTo(reflect.SliceOf(table.row_type)) {
+        return reflect.Value{}, fmt.Errorf("%s.%s failed because the rows are a %T rather than a *[]%v", table.Name, op, rows, table.row_type)
+    }
+    return reflect.ValueOf(rows).Elem(), nil
+}
+
+// Appends the decoded rows to the slice that rows_slice is.
+func (table *Table) append_rows (op string, rows_slice reflect.Value, rows_bytes [][]byte) error {
+    for _,bytes := range rows_bytes {
+        row := reflect.New(table.row_type)
+        err := table.decode_row(op, bytes, row.Interface())
+        if err != nil {
+            return err
+        }
+        rows_slice.Set(reflect.Append(rows_slice, row.Elem()))
+    }
+    return nil
+}
+
+// Sets rows, which is a pointer to a slice of the row type (e.g. *[]Account), to the rows (in key order) whose
+// leading row keys are row_keys; empty row_keys gives the whole table.
+func (table *Table) Scan (stub shim.ChaincodeStubInterface, row_keys []string, rows interface{}) error {
+    rows_slice, err := table.rows_slice("Scan", rows)
+    if err != nil {
+        return err
+    }
+    state_query_iterator, err := stub.GetStateByPartialCompositeKey(table.Name, row_keys)
+    if err != nil {
+        return fmt.Errorf("%s.Scan failed because stub.GetStateByPartialCompositeKey failed with error %v", table.Name, err)
+    }
+    defer state_query_iterator.Close()
+
+    rows_bytes := [][]byte{}
+    for state_query_iterator.HasNext() {
+        query_result_kv, err := state_query_iterator.Next()
+        if err != nil {
+            return fmt.Errorf("%s.Scan failed because iteration failed with error %v", table.Name, err)
+        }
+        rows_bytes = append(rows_bytes, query_result_kv.Value)
+    }
+    rows_slice.Set(reflect.MakeSlice(rows_slice.Type(), 0, len(rows_bytes)))
+    return table.append_rows("Scan", rows_slice, rows_bytes)
+}
+
+// Like Scan, but gets a page of rows as GetTableRowsPage does, returning the row keys at which the next page
+// starts, or nil if there are no further rows.
+func (table *Table) ScanPage (
+    stub            shim.ChaincodeStubInterface,
+    row_keys        []string,
+    last_key_prefix string,
+    start_row_keys  []string,
+    page_size       int,
+    rows            interface{},
+) (next_row_keys []string, err error) {
+    rows_slice, err := table.rows_slice("ScanPage", rows)
+    if err != nil {
+        return nil, err
+    }
+    rows_bytes, next_row_keys, err := GetTableRowsPage(stub, table.Name, row_keys, last_key_prefix, start_row_keys, page_size)
+    if err != nil {
+        return nil, fmt.Errorf("%s.ScanPage failed; %v", table.Name, err)
+    }
+    rows_slice.Set(reflect.MakeSlice(rows_slice.Type(), 0, len(rows_bytes)))
+    err = table.append_rows("ScanPage", rows_slice, rows_bytes)
+    if err != nil {
+        return nil, err
+    }
+    return next_row_keys, nil
+}
+
+// Like Scan, but gets the rows whose leading index keys in the given index of the table are index_keys, in
+// index key order (see GetTableRowsByIndex).
+func (table *Table) ScanIndex (stub shim.ChaincodeStubInterface, index *Index, index_keys []string, rows interface{}) error {
+    if index.TableName != table.Name {
+        return fmt.Errorf("%s.ScanIndex failed because index %s is an index of %s", table.Name, index.Name, index.TableName)
+    }
+    rows_slice, err := table.rows_slice("ScanIndex", rows)
+    if err != nil {
+        return err
+    }
+    rows_bytes, err := GetTableRowsByIndex(stub, index, index_keys)
+    if err != nil {
+        return fmt.Errorf("%s.ScanIndex failed; %v", table.Name, err)
+    }
+    rows_slice.Set(reflect.MakeSlice(rows_slice.Type(), 0, len(rows_bytes)))
+    return table.append_rows("ScanIndex", rows_slice, rows_bytes)
+}
+
+// Like ScanIndex, but gets a page of rows as GetTableRowsByIndexPage does, returning the row keys at which the
+// next page starts, or nil if there are no further rows.
+func (table *Table) ScanIndexPage (
+    stub            shim.ChaincodeStubInterface,
+    index           *Index,
+    index_keys      []string,
+    start_row_keys  []string,
+    page_size       int,
+    rows            interface{},
+) (next_row_keys []string, err error) {
+    if index.TableName != table.Name {
+        return nil, fmt.Errorf("%s.ScanIndexPage failed because index %s is an index of %s", table.Name, index.Name, index.TableName)
+    }
+    rows_slice, err := table.rows_slice("ScanIndexPage", rows)
+    if err != nil {
+        return nil, err
+    }
+    rows_bytes, next_row_keys, err := GetTableRowsByIndexPage(stub, index, index_keys, start_row_keys, page_size)
+    if err != nil {
+        return nil, fmt.Errorf("%s.ScanIndexPage failed; %v", table.Name, err)
+    }
+    rows_slice.Set(reflect.MakeSlice(rows_slice.Type(), 0, len(rows_bytes)))
+    err = table.append_rows("ScanIndexPage", rows_slice, rows_bytes)
+    if err != nil {
+        return nil, err
+    }
+    return next_row_keys, nil
+}
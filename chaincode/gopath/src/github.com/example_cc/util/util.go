@@ -6,6 +6,7 @@ import (
     "fmt"
     "github.com/hyperledger/fabric/core/chaincode/shim"
     "reflect" // This is only used in InterfaceIsNil
+    "unicode/utf8"
 )
 
 // Taken from https://stackoverflow.com/questions/13901819/quick-way-to-detect-empty-values-via-reflection-in-go
@@ -92,6 +93,19 @@ func getTableRowAndCompositeKey (
     return
 }
 
+// Returns the ledger state key under which the given table row is stored, e.g. for use with stub.GetHistoryForKey.
+func TableRowKey (
+    stub            shim.ChaincodeStubInterface,
+    table_name      string,
+    row_keys        []string,
+) (string, error) {
+    composite_key, err := stub.CreateCompositeKey(table_name, row_keys)
+    if err != nil {
+        return "", fmt.Errorf("TableRowKey failed because stub.CreateCompositeKey failed with error %v", err)
+    }
+    return composite_key, nil
+}
+
 // If row_value is nil, then don't bother unmarshaling the data.  Thus a check for the
 // presence of a particular table row can be done by specifying nil for row_value.
 func GetTableRow (
@@ -133,6 +147,62 @@ func GetTableRows (
     return row_json_bytes_channel, nil
 }
 
+// Returns up to page_size rows (in key order) whose leading row keys are row_keys and whose next row key starts
+// with last_key_prefix.  If start_row_keys is not empty, then the page starts at the row having those row keys
+// (or the next one after it).  If there are further rows, then the row keys of the first of them are returned
+// as next_row_keys, which can be passed as start_row_keys to get the next page; otherwise next_row_keys is nil.
+// This uses stub.GetStateByRange, so only the rows of the requested page (plus one) are read.
+func GetTableRowsPage (
+    stub            shim.ChaincodeStubInterface,
+    table_name      string,
+    row_keys        []string,
+    last_key_prefix string,
+    start_row_keys  []string,
+    page_size       int,
+) (rows [][]byte, next_row_keys []string, err error) {
+    if page_size < 1 {
+        return nil, nil, fmt.Errorf("GetTableRowsPage failed because page_size %d is not positive", page_size)
+    }
+    partial_composite_key,err := stub.CreateCompositeKey(table_name, row_keys)
+    if err != nil {
+        return nil, nil, fmt.Errorf("GetTableRowsPage failed because stub.CreateCompositeKey failed with error %v", err)
+    }
+    start_key := partial_composite_key + last_key_prefix
+    end_key := start_key + string(utf8.MaxRune)
+    if len(start_row_keys) > 0 {
+        bookmark_key,err := stub.CreateCompositeKey(table_name, start_row_keys)
+        if err != nil {
+            return nil, nil, fmt.Errorf("GetTableRowsPage failed because stub.CreateCompositeKey failed with error %v", err)
+        }
+        if bookmark_key > start_key {
+            start_key = bookmark_key
+        }
+    }
+
+    state_query_iterator,err := stub.GetStateByRange(start_key, end_key)
+    if err != nil {
+        return nil, nil, fmt.Errorf("GetTableRowsPage failed because stub.GetStateByRange failed with error %v", err)
+    }
+    defer state_query_iterator.Close()
+
+    rows = [][]byte{}
+    for state_query_iterator.HasNext() {
+        query_result_kv,err := state_query_iterator.Next()
+        if err != nil {
+            return nil, nil, fmt.Errorf("GetTableRowsPage failed because iteration failed with error %v", err)
+        }
+        if len(rows) == page_size {
+            _,next_row_keys,err = stub.SplitCompositeKey(query_result_kv.Key)
+            if err != nil {
+                return nil, nil, fmt.Errorf("GetTableRowsPage failed because stub.SplitCompositeKey failed with error %v", err)
+            }
+            break
+        }
+        rows = append(rows, query_result_kv.Value)
+    }
+    return rows, next_row_keys, nil
+}
+
 // This is effectively a strongly typed enum declaration.
 type InsertTableRow_FailureOption uint8
 const (
@@ -187,6 +257,16 @@ func InsertTableRow (
         return
     }
 
+    // Retrieve the old row for updating the table's secondary indexes (see DefineIndex), if there are any
+    var old_bytes []byte
+    if row_was_found && len(table_indexes[table_name]) > 0 {
+        old_bytes, err = stub.GetState(composite_key)
+        if err != nil {
+            err = fmt.Errorf("InsertTableRow failed because stub.GetState(\"%v\") failed with error %v", composite_key, err)
+            return
+        }
+    }
+
     // Store the data in the ledger state
     err = stub.PutState(composite_key, bytes)
     if err != nil {
@@ -194,6 +274,12 @@ func InsertTableRow (
         return
     }
 
+    err = update_index_entries(stub, table_name, row_keys, old_bytes, bytes)
+    if err != nil {
+        err = fmt.Errorf("InsertTableRow failed because updating indexes failed with error %v", err)
+        return
+    }
+
     // Return with success.
     err = nil
     return
@@ -223,6 +309,16 @@ func DeleteTableRow (
         return
     }
 
+    // Retrieve the old row for updating the table's secondary indexes (see DefineIndex), if there are any
+    var old_bytes []byte
+    if row_was_found && len(table_indexes[table_name]) > 0 {
+        old_bytes, err = stub.GetState(composite_key)
+        if err != nil {
+            err = fmt.Errorf("DeleteTableRow failed because stub.GetState(\"%v\") failed with error %v", composite_key, err)
+            return
+        }
+    }
+
     // Actually delete the row
     err = stub.DelState(composite_key)
     if err != nil {
@@ -230,6 +326,14 @@ func DeleteTableRow (
         return
     }
 
+    if old_bytes != nil {
+        err = update_index_entries(stub, table_name, row_keys, old_bytes, nil)
+        if err != nil {
+            err = fmt.Errorf("DeleteTableRow failed because updating indexes failed with error %v", err)
+            return
+        }
+    }
+
     // Return with success
     err = nil
     return
@@ -97,7 +97,8 @@ app.use('/', express.static(path.join(__dirname, '../client')));
 
 const temp_hardcoded_channel_name = 'mychannel';
 
-function invoke (invoking_user_org_name, fcn, req_query_arg_names, req, res) {
+// extra_args, if specified, are appended to the args taken from req.query.
+function invoke (invoking_user_org_name, fcn, req_query_arg_names, req, res, extra_args) {
     var key_error = check_request_query_for_keys(req.query, ['invoking_user_name'].concat(req_query_arg_names));
     if (key_error !== null) {
         res.write(key_error);
@@ -113,6 +114,9 @@ function invoke (invoking_user_org_name, fcn, req_query_arg_names, req, res) {
         // TODO: throw error if the req.query lookups fail
         args.push(req.query[req_query_arg_name]);
     }
+    if (extra_args) {
+        args.push(...extra_args);
+    }
 
     console.log('invoke - req_query_arg_names = %j', req_query_arg_names);
     console.log('invoke - args = %j', args);
@@ -189,6 +193,12 @@ function query (invoking_user_org_name, fcn, req_query_arg_names, req, res, comp
     });
 }
 
+// Returns the PEM enrollment certificate of a fabric-client User.  Identity has no public accessor for its
+// certificate, so this uses the documented serialized form of the User (as persisted in its key value store).
+function user_certificate_pem (user) {
+    return JSON.parse(user.toString()).enrollment.identity.certificate;
+}
+
 // This defines a service endpoint on the server to which HTTP POST requests will be made,
 // ( e.g. localhost:3000/create_account?invoking_user_name=Admin&account_name=Bob&initial_balance=123 ).
 app.post('/create_account', function(req, res){
@@ -200,8 +210,9 @@ app.post('/create_account', function(req, res){
     simple_client.register_and_enroll_user_in_org__p(account_name, undefined, 'user', 'org1.department1', 'org0', 'admin')
     .then(user => {
         // invoking_user_name is a required argument implicitly; this will be replaced if/when user sessions
-        // are added to the web client/server.
-        invoke('org0', 'create_account', ['account_name', 'initial_balance'], req, res);
+        // are added to the web client/server.  The new user's MSP ID and certificate bind the account to
+        // its holder's identity.
+        invoke('org0', 'create_account', ['account_name', 'initial_balance'], req, res, [user.getIdentity().getMSPId(), user_certificate_pem(user)]);
     })
     .catch(err => {
         // For some reason, err is empty if the user is already registered, so just make up an error message.
@@ -49,6 +49,29 @@ process.on('unhandledRejection', (r) => logger.error(r));
 // ./appcfg.json is the read-only configuration for application
 const simple_client = new SimpleClient(require('./netcfg.json'), require('./appcfg.json'), Boolean(JSON.parse(process.env.TLS_ENABLED)));
 
+// Returns a promise for the fabric-client User of the given name in the given org.
+function get_user__p (org_name, user_name) {
+    return simple_client.organizations[org_name].client.getUserContext(user_name, true);
+}
+
+// Returns the PEM enrollment certificate of a fabric-client User, as app.js does.
+function user_certificate_pem (user) {
+    return JSON.parse(user.toString()).enrollment.identity.certificate;
+}
+
+// The balance query results hold the certificate subjects of the owners, which depend on the network's crypto
+// material, so only the owner's MSP ID is checked.
+function assert_account (result, name, balance, owner_msp_id) {
+    const account = JSON.parse(result.toString());
+    assert(account.Name == name && account.Balance == balance && account.Status == 'active' && account.Held == '0', 'unexpected account ' + result);
+    assert(account.Owner && account.Owner.MspID == owner_msp_id, 'unexpected owner of account ' + result);
+}
+
+// Set once the chaincode is instantiated; see below.
+let admin_org_name;
+let alice_owner;
+let bob_owner;
+
 function sleep__p (delay_milliseconds) {
     for (let i = 0; i < 10; i++) {
         logger.debug('---------------------------------------------------------------------------');
@@ -128,28 +151,66 @@ Promise.resolve()
     // in order to wait for install/instantiate to complete?  Why doesn't it do that already?
     return sleep__p(5000)
 })
+// Instantiation is proposed by the Admin of each org, and the chaincode admin is whichever of them instantiated it
+// first, so it is found by seeing whose query_admin (which only the chaincode admin may call) succeeds.
 .then(() => {
     logger.debug('**************************************************');
     logger.debug('**************************************************');
     logger.debug('**************************************************');
     const channel_name = 'mychannel';
+    return Promise.all(['org0', 'org1'].map(org_name => {
+        return simple_client.query__p({
+            channel_name: channel_name,
+            invoking_user_name: 'Admin',
+            invoking_user_org_name: org_name,
+            fcn: 'query_admin',
+            args: []
+        })
+        .then(() => org_name, () => null);
+    }));
+})
+.then(org_names => {
+    admin_org_name = org_names.find(org_name => org_name != null);
+    assert(admin_org_name, 'neither org\'s Admin is the chaincode admin');
+    logger.debug('the chaincode admin is the Admin of %s', admin_org_name);
+    // The accounts are held by User1 of each org.  These are looked up one at a time, since getUserContext
+    // also sets the user of the org's client.
+    return get_user__p('org0', 'User1');
+})
+.then(user => {
+    alice_owner = user;
+    return get_user__p('org1', 'User1');
+})
+.then(user => {
+    bob_owner = user;
+    const channel_name = 'mychannel';
     return Promise.all([
         simple_client.invoke__p({
             channel_name: channel_name,
             invoking_user_name: 'Admin',
-            invoking_user_org_name: 'org0',
+            invoking_user_org_name: admin_org_name,
             fcn: 'create_account',
-            args: ['alice', '123']
+            args: ['alice', '123', alice_owner.getIdentity().getMSPId(), user_certificate_pem(alice_owner)]
         }),
         simple_client.invoke__p({
             channel_name: channel_name,
             invoking_user_name: 'Admin',
-            invoking_user_org_name: 'org1',
+            invoking_user_org_name: admin_org_name,
             fcn: 'create_account',
-            args: ['bob', '456']
+            args: ['bob', '456', bob_owner.getIdentity().getMSPId(), user_certificate_pem(bob_owner)]
         })
     ]);
 })
+.then(() => {
+    // alice's holder is also made an auditor, so that they may query any balance.
+    return simple_client.invoke__p({
+        channel_name: 'mychannel',
+        invoking_user_name: 'Admin',
+        invoking_user_org_name: admin_org_name,
+        fcn: 'grant_role',
+        args: ['auditor', alice_owner.getIdentity().getMSPId(), user_certificate_pem(alice_owner)]
+    });
+})
 .then(() => {
     logger.debug('**************************************************');
     logger.debug('**************************************************');
@@ -158,28 +219,28 @@ Promise.resolve()
     return Promise.all([
         simple_client.query__p({
             channel_name: channel_name,
-            invoking_user_name: 'User1', // TEMP HACK
+            invoking_user_name: 'User1',
             invoking_user_org_name: 'org0',
             fcn: 'query_balance',
             args: ['alice']
         }),
         simple_client.query__p({
             channel_name: channel_name,
-            invoking_user_name: 'User1', // TEMP HACK
+            invoking_user_name: 'User1',
             invoking_user_org_name: 'org0',
             fcn: 'query_balance',
             args: ['bob']
         }),
         simple_client.query__p({
             channel_name: channel_name,
-            invoking_user_name: 'User1', // TEMP HACK
-            invoking_user_org_name: 'org1',
+            invoking_user_name: 'Admin',
+            invoking_user_org_name: admin_org_name,
             fcn: 'query_balance',
             args: ['alice']
         }),
         simple_client.query__p({
             channel_name: channel_name,
-            invoking_user_name: 'User1', // TEMP HACK
+            invoking_user_name: 'User1',
             invoking_user_org_name: 'org1',
             fcn: 'query_balance',
             args: ['bob']
@@ -187,10 +248,25 @@ Promise.resolve()
     ]);
 })
 .then(results => {
-    assert(results[0] == '{"Name":"alice","Balance":123}', 'unexpected results');
-    assert(results[1] == '{"Name":"bob","Balance":456}', 'unexpected results');
-    assert(results[2] == '{"Name":"alice","Balance":123}', 'unexpected results');
-    assert(results[3] == '{"Name":"bob","Balance":456}', 'unexpected results');
+    assert_account(results[0], 'alice', '123', 'Org0MSP');
+    assert_account(results[1], 'bob', '456', 'Org1MSP');
+    assert_account(results[2], 'alice', '123', 'Org0MSP');
+    assert_account(results[3], 'bob', '456', 'Org1MSP');
+})
+.then(() => {
+    // bob's holder is not an auditor, so may not query alice's balance.
+    return simple_client.query__p({
+        channel_name: 'mychannel',
+        invoking_user_name: 'User1',
+        invoking_user_org_name: 'org1',
+        fcn: 'query_balance',
+        args: ['alice']
+    })
+    .then(() => {
+        assert(false, 'expected bob\'s holder to be refused query_balance of alice');
+    }, err => {
+        logger.debug('query_balance of alice by bob\'s holder was refused as expected; %s', err.message);
+    });
 })
 
 